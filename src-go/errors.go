@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Machine-readable error codes returned in {"error": {"code": ...}}
+const (
+	CodeBadRequest          = "bad_request"
+	CodeUnauthorized        = "unauthorized"
+	CodeNotFound            = "not_found"
+	CodeRateLimited         = "rate_limited"
+	CodeInternal            = "internal"
+	CodeAccountMissing      = "account_missing"
+	CodeReauthRequired      = "reauth_required"
+	CodeForbidden           = "forbidden"
+	CodeModelNotFound       = "model_not_found"
+	CodeAmbiguousModel      = "ambiguous_model"
+	CodeUpstreamRateLimited = "upstream_rate_limited"
+	CodeUpstreamError       = "upstream_error"
+)
+
+// ErrAccountMissing is returned when neither ACCOUNT_JSON nor the account file is available
+var ErrAccountMissing = errors.New("account file not found")
+
+// appError is an error with the HTTP status and code it is reported with
+type appError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *appError) Error() string {
+	return e.Message
+}
+
+// newAppError builds an appError for a response written by a handler or middleware
+func newAppError(status int, code, message string) *appError {
+	return &appError{Status: status, Code: code, Message: message}
+}
+
+// toAppError maps err to its status and code. Upstream API errors keep their
+// status, a revoked refresh token is a 401 since only re-authenticating fixes
+// it, and anything unrecognized is a 500.
+func toAppError(err error) *appError {
+	var appErr *appError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	status, code := http.StatusInternalServerError, CodeInternal
+	var refreshErr *TokenRefreshError
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &refreshErr) && refreshErr.InvalidGrant():
+		status, code = http.StatusUnauthorized, CodeReauthRequired
+	case errors.Is(err, ErrAccountMissing):
+		status, code = http.StatusServiceUnavailable, CodeAccountMissing
+	case errors.Is(err, errModelNotFound):
+		status, code = http.StatusNotFound, CodeModelNotFound
+	case errors.Is(err, errAmbiguousModel):
+		status, code = http.StatusBadRequest, CodeAmbiguousModel
+	case errors.As(err, &apiErr) && apiErr.StatusCode >= http.StatusBadRequest:
+		status, code = apiErr.StatusCode, CodeUpstreamError
+		switch apiErr.StatusCode {
+		case http.StatusForbidden:
+			code = CodeForbidden
+		case http.StatusTooManyRequests:
+			code = CodeUpstreamRateLimited
+		}
+	}
+	return newAppError(status, code, err.Error())
+}
+
+// respondError aborts the request with err's status and a JSON error body
+func respondError(c *gin.Context, err error) {
+	c.AbortWithStatusJSON(errorResponse(c, err))
+}
+
+// abortWithError aborts the request with a JSON error body without logging it,
+// for middleware rejections that the request log already records
+func abortWithError(c *gin.Context, err *appError) {
+	c.AbortWithStatusJSON(err.Status, errorBody(c, err))
+}
+
+// errorResponse logs a failed request and builds its status and body. A forbidden
+// account also gets an empty quota flagged is_forbidden.
+func errorResponse(c *gin.Context, err error) (int, gin.H) {
+	appErr := toAppError(err)
+	requestLogger(c).Warn("Request failed", "status", appErr.Status, "code", appErr.Code, "error", redactError(err))
+	body := errorBody(c, appErr)
+	if appErr.Code == CodeForbidden {
+		body["quota"] = formatQuota(forbiddenQuota(), false, defaultQuotaFormat)
+	}
+	return appErr.Status, body
+}
+
+// errorBody builds the {"error": {"code", "message"}} body, including the
+// request ID when one is set
+func errorBody(c *gin.Context, err *appError) gin.H {
+	body := gin.H{"error": gin.H{"code": err.Code, "message": err.Message}}
+	if id := c.GetString(requestIDKey); id != "" {
+		body["request_id"] = id
+	}
+	return body
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative quota.proto
+
+// grpcQuotaServer implements the generated QuotaServiceServer on top of QuotaService
+type grpcQuotaServer struct {
+	UnimplementedQuotaServiceServer
+	service *QuotaService
+}
+
+// GetQuota fetches quota like /quota/all and converts it to its protobuf message
+func (g *grpcQuotaServer) GetQuota(ctx context.Context, req *GetQuotaRequest) (*GetQuotaResponse, error) {
+	maxAge := DebounceMaxAge
+	if req.MaxAgeSeconds != nil {
+		if req.GetMaxAgeSeconds() < 0 {
+			return nil, grpcError(newAppError(http.StatusBadRequest, CodeBadRequest, "max_age_seconds must be a non-negative number of seconds"))
+		}
+		maxAge = time.Duration(req.GetMaxAgeSeconds()) * time.Second
+	}
+
+	quotaRaw, err := g.service.getQuotaData(ctx, req.GetProject(), maxAge)
+	if err != nil {
+		slog.Warn("gRPC quota request failed", "error", redactError(err))
+		return nil, grpcError(err)
+	}
+	return &GetQuotaResponse{Quota: quotaToProto(g.service.formatDisplayQuota(quotaRaw))}, nil
+}
+
+// quotaToProto converts formatted quota to its protobuf message
+func quotaToProto(quota *FormattedQuota) *Quota {
+	models := make([]*ModelQuota, 0, len(quota.Models))
+	for _, model := range quota.Models {
+		models = append(models, &ModelQuota{
+			Name:              model.Name,
+			DisplayName:       model.DisplayName,
+			Percentage:        int32(model.Percentage),
+			ResetTime:         model.ResetTime,
+			ResetTimeUnix:     model.ResetTimeUnix,
+			ResetTimeRelative: model.ResetTimeRelative,
+			ResetTimeLocal:    model.ResetTimeLocal,
+			PercentagePrecise: model.PercentagePrecise,
+		})
+	}
+	return &Quota{
+		Models:      models,
+		LastUpdated: quota.LastUpdated,
+		IsForbidden: quota.IsForbidden,
+		IsStale:     quota.IsStale,
+		Alert:       quota.Alert,
+		Alerts:      quota.Alerts,
+	}
+}
+
+// grpcError converts err to a gRPC status, carrying the REST error code as the
+// ErrorInfo reason
+func grpcError(err error) error {
+	appErr := toAppError(err)
+	st := status.New(grpcCode(appErr.Status), appErr.Message)
+	if detailed, detailErr := st.WithDetails(&errdetails.ErrorInfo{Reason: appErr.Code}); detailErr == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// grpcCode maps an HTTP status to the closest gRPC code
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+	if httpStatus >= http.StatusInternalServerError {
+		return codes.Internal
+	}
+	return codes.Unknown
+}
+
+// grpcAPIKeyAuth requires API_KEY in "authorization: Bearer <key>" or "x-api-key"
+// metadata, like APIKeyAuth does for HTTP. An empty key disables authentication.
+func grpcAPIKeyAuth(apiKey string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if apiKey == "" {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		provided := firstMetadata(md, "x-api-key")
+		if auth := firstMetadata(md, "authorization"); provided == "" && strings.HasPrefix(auth, "Bearer ") {
+			provided = strings.TrimPrefix(auth, "Bearer ")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(apiKey)) != 1 {
+			return nil, grpcError(newAppError(http.StatusUnauthorized, CodeUnauthorized, "unauthorized"))
+		}
+		return handler(ctx, req)
+	}
+}
+
+// firstMetadata returns the first value of a metadata key, or ""
+func firstMetadata(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// newGRPCServer builds the gRPC server for service, using TLS when tlsConfig is set
+func newGRPCServer(service *QuotaService, config *Config, tlsConfig *tls.Config) *grpc.Server {
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(grpcAPIKeyAuth(config.APIKey))}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	srv := grpc.NewServer(opts...)
+	RegisterQuotaServiceServer(srv, &grpcQuotaServer{service: service})
+	return srv
+}
+
+// startGRPC serves the gRPC QuotaService on GRPC_PORT until ctx is cancelled.
+// The returned channel is closed once the server has stopped, or immediately
+// when GRPC_PORT is unset.
+func startGRPC(ctx context.Context, service *QuotaService, config *Config, tlsConfig *tls.Config) (<-chan struct{}, error) {
+	done := make(chan struct{})
+	if config.GRPCPort <= 0 {
+		close(done)
+		return done, nil
+	}
+
+	addr, err := listenAddress(config.Host, strconv.Itoa(config.GRPCPort))
+	if err != nil {
+		return nil, err
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := newGRPCServer(service, config, tlsConfig)
+	slog.Info("Starting gRPC server", "addr", addr)
+	go func() {
+		defer close(done)
+		if err := serveGRPC(ctx, srv, lis, shutdownTimeout(config)); err != nil {
+			slog.Error("gRPC server failed", "error", err)
+		}
+	}()
+	return done, nil
+}
+
+// serveGRPC runs srv on lis until ctx is cancelled, then stops it gracefully,
+// giving in-flight calls up to timeout to complete
+func serveGRPC(ctx context.Context, srv *grpc.Server, lis net.Listener, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(lis)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		srv.Stop()
+	}
+	return nil
+}
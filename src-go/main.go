@@ -1,9 +1,24 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -15,26 +30,231 @@ func main() {
 		log.Printf("Warning: .env file not found: %v", err)
 	}
 
-	// Get port from environment
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8000"
+	config := LoadConfig()
+	slog.SetDefault(newLogger(config.LogLevel, config.LogFormat, os.Stderr))
+
+	once := flag.Bool("once", false, "Print quota once and exit instead of starting the server")
+	flag.BoolVar(once, "o", false, "Shorthand for --once")
+	format := flag.String("format", "overview", "Output format for --once: overview, status, or json")
+	flag.Parse()
+
+	if *once {
+		service := NewQuotaService(NewCloudCodeClient(config))
+		if err := runOnce(service, *format, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	// Validate port
-	if _, err := strconv.Atoi(port); err != nil {
-		log.Fatalf("Invalid PORT value: %s", port)
+	addr, err := serverAddress(config)
+	if err != nil {
+		log.Fatalf("Invalid bind address: %v", err)
 	}
 
-	// Create Gin router
-	r := gin.Default()
+	// Create Gin router; setupRoutes adds JSON panic recovery in place of gin's
+	r := gin.New()
+	r.Use(gin.Logger())
 
 	// Setup routes
-	setupRoutes(r)
+	service := setupRoutes(r)
+
+	// Stop the server and background work on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	done := startBackgroundRefresh(ctx, service, config)
+
+	// Fail fast on unusable TLS files before binding the port
+	tlsConfig, err := serverTLSConfig(config)
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+
+	grpcDone, err := startGRPC(ctx, service, config, tlsConfig)
+	if err != nil {
+		log.Fatalf("Failed to start gRPC server: %v", err)
+	}
 
 	// Start server
-	log.Printf("Starting server on port %s", port)
-	if err := r.Run(":" + port); err != nil {
+	srv := &http.Server{Addr: addr, Handler: r, TLSConfig: tlsConfig}
+	if tlsConfig != nil {
+		log.Printf("Starting HTTPS server on %s", addr)
+	} else {
+		log.Printf("Starting server on %s", addr)
+	}
+	if err := serve(ctx, srv, shutdownTimeout(config)); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+
+	<-done
+	<-grpcDone
+	log.Printf("Server stopped")
+}
+
+// serve runs srv until ctx is cancelled, then shuts it down gracefully,
+// giving in-flight requests up to timeout to complete. It serves HTTPS when
+// srv.TLSConfig carries a certificate.
+func serve(ctx context.Context, srv *http.Server, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil && len(srv.TLSConfig.Certificates) > 0 {
+			errCh <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down server (timeout %s)", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// hostnamePattern matches a DNS hostname: dot-separated labels of letters, digits,
+// and inner hyphens
+var hostnamePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+
+// listenAddress joins the bind host and port into a listen address. An empty
+// host listens on all interfaces; otherwise it must be an IP (IPv6 optionally
+// bracketed) or a hostname.
+func listenAddress(host, port string) (string, error) {
+	if host == "" {
+		return ":" + port, nil
+	}
+
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if net.ParseIP(host) == nil && (len(host) > 253 || !hostnamePattern.MatchString(host)) {
+		return "", fmt.Errorf("%q is not an IP address or hostname", host)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// serverAddress returns the HTTP listen address from HOST and PORT, which may
+// come from CONFIG_FILE as well as the environment
+func serverAddress(config *Config) (string, error) {
+	if config.Port <= 0 || config.Port > 65535 {
+		return "", fmt.Errorf("invalid PORT value: %d", config.Port)
+	}
+	return listenAddress(config.Host, strconv.Itoa(config.Port))
+}
+
+// serverTLSConfig loads TLS_CERT_FILE and TLS_KEY_FILE, returning nil when both
+// are unset so the server stays on plain HTTP
+func serverTLSConfig(config *Config) (*tls.Config, error) {
+	if config.TLSCertFile == "" && config.TLSKeyFile == "" {
+		return nil, nil
+	}
+	if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	certPEM, err := os.ReadFile(config.TLSCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read TLS_CERT_FILE: %w", err)
+	}
+	keyPEM, err := os.ReadFile(config.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read TLS_KEY_FILE: %w", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate or key: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// shutdownTimeout returns the configured shutdown grace period
+func shutdownTimeout(config *Config) time.Duration {
+	if config.ShutdownTimeoutSeconds <= 0 {
+		return DefaultShutdownTimeoutSeconds * time.Second
+	}
+	return time.Duration(config.ShutdownTimeoutSeconds) * time.Second
+}
+
+// startBackgroundRefresh keeps the quota cache warm until ctx is cancelled.
+// The returned channel is closed once the refresher has stopped, or immediately
+// when refresh is disabled or no account is configured.
+func startBackgroundRefresh(ctx context.Context, service *QuotaService, config *Config) <-chan struct{} {
+	done := make(chan struct{})
+	if config.BackgroundRefreshSeconds <= 0 {
+		close(done)
+		return done
+	}
+	if !service.client.HasAccount() {
+		slog.Warn("Background refresh disabled: no account configured")
+		close(done)
+		return done
+	}
+
+	interval := time.Duration(config.BackgroundRefreshSeconds) * time.Second
+	slog.Info("Starting background quota refresh", "interval", interval)
+	go func() {
+		defer close(done)
+		superviseRefresher(ctx, service.client.clock, interval, RefresherRestartDelay, func() error {
+			_, err := service.getQuotaData(ctx, "", DebounceMaxAge)
+			return err
+		}, &service.refresher)
+	}()
+	return done
+}
+
+// newLogger creates a structured logger with the given level and format (text or json)
+func newLogger(level, format string, w io.Writer) *slog.Logger {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		logLevel = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	if strings.ToLower(format) == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// runOnce fetches quota and writes it to w in the requested format
+func runOnce(service *QuotaService, format string, w io.Writer) error {
+	switch format {
+	case "overview":
+		overview, err := service.getOverview(context.Background(), "", DebounceMaxAge)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, overview)
+		return err
+	case "status":
+		status, err := service.getStatus(context.Background(), "", DebounceMaxAge)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, status)
+		return err
+	case "json":
+		quotaRaw, err := service.getQuotaData(context.Background(), "", DebounceMaxAge)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(service.formatDisplayQuota(quotaRaw), "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	default:
+		return fmt.Errorf("unknown format: %s (expected overview, status, or json)", format)
+	}
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PrometheusContentType is the Prometheus text exposition format content type
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// OpenMetricsContentType is the OpenMetrics text format content type
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// GetPrometheusTextfile returns quota metrics in the Prometheus text format,
+// suitable for writing into the node_exporter textfile collector directory
+func (s *QuotaService) GetPrometheusTextfile(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, PrometheusContentType, []byte(buildPrometheusText(formatQuota(quotaRaw, false, s.quotaFormat()))))
+}
+
+// buildPrometheusText renders quota as Prometheus text exposition format
+func buildPrometheusText(quota *FormattedQuota) string {
+	var b strings.Builder
+
+	writeMetricHeader(&b, "antigravity_quota_remaining_percent", "Remaining quota percentage per model.")
+	for _, model := range quota.Models {
+		fmt.Fprintf(&b, "antigravity_quota_remaining_percent{model=\"%s\"} %d\n", escapeLabelValue(model.Name), model.Percentage)
+	}
+
+	writeMetricHeader(&b, "antigravity_quota_reset_timestamp_seconds", "Unix time when the model's quota resets.")
+	for _, model := range quota.Models {
+		resetTime, ok := parseResetTime(model.ResetTime)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, "antigravity_quota_reset_timestamp_seconds{model=\"%s\"} %d\n", escapeLabelValue(model.Name), resetTime.Unix())
+	}
+
+	writeMetricHeader(&b, "antigravity_quota_stale", "1 if the quota data is stale because a fresh fetch failed.")
+	fmt.Fprintf(&b, "antigravity_quota_stale %d\n", boolToInt(quota.IsStale))
+
+	writeMetricHeader(&b, "antigravity_quota_last_updated_timestamp_seconds", "Unix time when the quota was last formatted.")
+	fmt.Fprintf(&b, "antigravity_quota_last_updated_timestamp_seconds %d\n", quota.LastUpdated)
+
+	return b.String()
+}
+
+// GetOpenMetrics returns each model's remaining fraction in the OpenMetrics text
+// format, with the reset time carried as a label on the sample
+func (s *QuotaService) GetOpenMetrics(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, OpenMetricsContentType, []byte(buildOpenMetricsText(quotaRaw, s.quotaFormat())))
+}
+
+// buildOpenMetricsText renders quota as OpenMetrics text, ending with the
+// required EOF marker. Models without a reset time get no reset_time label.
+func buildOpenMetricsText(quotaRaw *QuotaResponse, format quotaFormat) string {
+	var b strings.Builder
+
+	writeMetricHeader(&b, "antigravity_quota_remaining_fraction", "Remaining quota fraction per model, labelled with its reset time.")
+	for _, model := range formatQuota(quotaRaw, false, format).Models {
+		fraction := quotaRaw.Models[model.Name].QuotaInfo.Fraction(format.fractionIsPercent)
+		labels := fmt.Sprintf("model=\"%s\"", escapeLabelValue(model.Name))
+		if resetTime, ok := parseResetTime(model.ResetTime); ok {
+			labels += fmt.Sprintf(",reset_time=\"%s\"", resetTime.UTC().Format(time.RFC3339))
+		}
+		fmt.Fprintf(&b, "antigravity_quota_remaining_fraction{%s} %s\n", labels, strconv.FormatFloat(fraction, 'g', -1, 64))
+	}
+
+	b.WriteString("# EOF\n")
+	return b.String()
+}
+
+// writeMetricHeader writes the HELP and TYPE lines for a gauge
+func writeMetricHeader(b *strings.Builder, name, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s gauge\n", name)
+}
+
+// escapeLabelValue escapes backslashes, double quotes, and newlines in a label value
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
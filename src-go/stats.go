@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Stats holds in-memory request counters, safe for concurrent use. Increments
+// hold the read lock and Reset the write lock, so a reset never lands between
+// an increment's lookup and its add.
+type Stats struct {
+	mu             sync.RWMutex
+	endpoints      map[string]*atomic.Int64
+	cacheHits      atomic.Int64
+	cacheMisses    atomic.Int64
+	upstreamErrors atomic.Int64
+}
+
+// StatsSnapshot is a point-in-time copy of the counters
+type StatsSnapshot struct {
+	Endpoints      map[string]int64 `json:"endpoints"`
+	CacheHits      int64            `json:"cache_hits"`
+	CacheMisses    int64            `json:"cache_misses"`
+	UpstreamErrors int64            `json:"upstream_errors"`
+}
+
+// NewStats creates an empty stats collector
+func NewStats() *Stats {
+	return &Stats{endpoints: make(map[string]*atomic.Int64)}
+}
+
+// RecordEndpoint increments the hit counter for a route path
+func (s *Stats) RecordEndpoint(path string) {
+	s.mu.RLock()
+	if counter, exists := s.endpoints[path]; exists {
+		counter.Add(1)
+		s.mu.RUnlock()
+		return
+	}
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counter, exists := s.endpoints[path]
+	if !exists {
+		counter = &atomic.Int64{}
+		s.endpoints[path] = counter
+	}
+	counter.Add(1)
+}
+
+// RecordCacheHit increments the cache hit counter
+func (s *Stats) RecordCacheHit() {
+	s.increment(&s.cacheHits)
+}
+
+// RecordCacheMiss increments the cache miss counter
+func (s *Stats) RecordCacheMiss() {
+	s.increment(&s.cacheMisses)
+}
+
+// RecordUpstreamError increments the upstream error counter
+func (s *Stats) RecordUpstreamError() {
+	s.increment(&s.upstreamErrors)
+}
+
+// increment adds one to counter under the read lock, so it can't interleave with Reset
+func (s *Stats) increment(counter *atomic.Int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counter.Add(1)
+}
+
+// Snapshot returns the current counter values
+func (s *Stats) Snapshot() StatsSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	endpoints := make(map[string]int64, len(s.endpoints))
+	for path, counter := range s.endpoints {
+		endpoints[path] = counter.Load()
+	}
+
+	return StatsSnapshot{
+		Endpoints:      endpoints,
+		CacheHits:      s.cacheHits.Load(),
+		CacheMisses:    s.cacheMisses.Load(),
+		UpstreamErrors: s.upstreamErrors.Load(),
+	}
+}
+
+// Reset zeroes every counter in one step and returns their values just before it
+func (s *Stats) Reset() StatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoints := make(map[string]int64, len(s.endpoints))
+	for path, counter := range s.endpoints {
+		endpoints[path] = counter.Load()
+	}
+	previous := StatsSnapshot{
+		Endpoints:      endpoints,
+		CacheHits:      s.cacheHits.Swap(0),
+		CacheMisses:    s.cacheMisses.Swap(0),
+		UpstreamErrors: s.upstreamErrors.Swap(0),
+	}
+	s.endpoints = make(map[string]*atomic.Int64)
+	return previous
+}
+
+// StatsMiddleware counts requests per matched route
+func StatsMiddleware(stats *Stats) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if path := c.FullPath(); path != "" {
+			stats.RecordEndpoint(path)
+		}
+		c.Next()
+	}
+}
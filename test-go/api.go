@@ -1,7 +1,13 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"sort"
 	"strconv"
@@ -14,6 +20,9 @@ import (
 // QuotaService handles quota-related operations
 type QuotaService struct {
 	client *CloudCodeClient
+
+	// Background refresher state reported by /healthz
+	refresher RefresherHealth
 }
 
 // NewQuotaService creates a new quota service
@@ -21,108 +30,367 @@ func NewQuotaService(client *CloudCodeClient) *QuotaService {
 	return &QuotaService{client: client}
 }
 
-// setupRoutes configures all API routes
-func setupRoutes(r *gin.Engine) {
+// setupRoutes configures all API routes and returns the service backing them
+func setupRoutes(r *gin.Engine) *QuotaService {
 	config := LoadConfig()
 	client := NewCloudCodeClient(config)
 	service := NewQuotaService(client)
 
-	quota := r.Group("/quota")
+	r.Use(RequestID())
+	r.Use(StatsMiddleware(client.stats))
+	if config.GzipEnabled {
+		r.Use(Compression(config.GzipMinSize))
+	}
+	// Innermost, so the error body passes through compression and is counted in stats
+	r.Use(Recovery())
+
+	// All routes mount under BASE_PATH, which is empty unless behind a proxy subpath
+	root := r.Group(config.BasePath)
+
+	root.GET("/healthz", service.GetHealthz)
+	root.GET("/readyz", service.GetReadyz)
+	root.GET("/stats", service.GetStats)
+	// Clearing the counters is only offered behind an API key
+	if config.APIKey != "" {
+		root.POST("/stats/reset", APIKeyAuth(config.APIKey), service.PostStatsReset)
+	}
+	root.GET("/version", service.GetVersion)
+	root.GET("/openapi.json", service.GetOpenAPISpec)
+
+	limiter := NewRateLimiter(config.RateLimitRPS, config.RateLimitBurst)
+	// OpenMetrics sits at the root like other scrape targets, guarded as /quota is
+	root.GET("/openmetrics", limiter.Middleware(), APIKeyAuth(config.APIKey), MaxAge(), service.GetOpenMetrics)
+
+	quota := root.Group("/quota", limiter.Middleware(), APIKeyAuth(config.APIKey), MaxAge(), service.CacheHeaders())
 	{
 		quota.GET("", service.GetQuotaEndpoints)
 		quota.GET("/usage", service.GetQuotaEndpoints)
 		quota.GET("/overview", service.GetQuotaOverview)
+		quota.GET("/overview.txt", service.GetQuotaOverviewText)
 		quota.GET("/status", service.GetQuotaStatus)
+		quota.GET("/status.txt", service.GetQuotaStatusText)
+		quota.GET("/tmux", service.GetQuotaTmux)
+		quota.GET("/table", service.GetQuotaTable)
 		quota.GET("/all", service.GetAllQuota)
+		quota.GET("/worst", service.GetWorstQuota)
+		quota.GET("/next-reset", service.GetNextReset)
+		quota.GET("/summary", service.GetQuotaSummary)
+		quota.GET("/models", service.GetModelNames)
+		quota.GET("/model/:name/percentage", service.GetModelPercentage)
+		quota.GET("/waybar", service.GetWaybarQuota)
+		quota.GET("/score", service.GetQuotaScore)
+		quota.GET("/compare", service.GetQuotaCompare)
+		quota.GET("/grafana", service.GetQuotaGrafana)
+		quota.GET("/badge", service.GetQuotaBadge)
+		quota.GET("/prometheus-textfile", service.GetPrometheusTextfile)
 		quota.GET("/pro", service.GetGemini3Pro)
 		quota.GET("/flash", service.GetGemini3Flash)
 		quota.GET("/claude", service.GetClaude45)
+		quota.GET("/glm", service.GetGLMQuota)
+		quota.GET("/status-zai", service.GetQuotaStatusZAI)
+		quota.POST("/refresh", service.PostQuotaRefresh)
+		// Fetching for arbitrary posted accounts is only offered behind an API key
+		if config.APIKey != "" {
+			quota.POST("/query", service.PostQuotaQuery)
+		}
+
+		if config.DebugEndpoints {
+			quota.GET("/raw", service.GetRawQuota)
+		}
+	}
+
+	if config.DebugEndpoints {
+		debug := root.Group("/debug", APIKeyAuth(config.APIKey))
+		debug.GET("/token", service.GetDebugToken)
+		debug.GET("/account", service.GetDebugAccount)
+		debug.GET("/cache", service.GetDebugCache)
+		debug.GET("/project", service.GetDebugProject)
 	}
+
+	return service
 }
 
-// GetQuotaEndpoints returns available endpoints
+// GetQuotaEndpoints returns available endpoints, prefixed with BASE_PATH
 func (s *QuotaService) GetQuotaEndpoints(c *gin.Context) {
+	endpoints := gin.H{
+		"/quota":          "This endpoint - lists all available endpoints",
+		"/quota/overview": "Quick summary (e.g., 'Pro 95% | Flash 90% | Claude 80%')",
+		"/quota/overview.txt": "Quick summary as plain text",
+		"/quota/status":   "Terminal status with nerdfont icons and colors",
+		"/quota/status.txt": "Terminal status as plain text (ANSI colors preserved)",
+		"/quota/tmux":     "Status as plain text with tmux #[fg=...] color directives for status-right",
+		"/quota/table":    "All models as an aligned box-drawn text table (?color=true for ANSI colors)",
+		"/quota/status-zai": "GLM quota status with nerdfont icon and colors (e.g., 'Z 99%')",
+		"/quota/all":      "All models with percentage and relative reset time (?sort=name|reset|percentage, ?limit=N&offset=M, ?group=provider)",
+		"/quota/worst":    "The single most-depleted model with its relative reset time",
+		"/quota/next-reset": "The soonest upcoming reset across all models, absolute and relative",
+		"/quota/summary":  "Overview, worst model, next reset, and all models from a single fetch",
+		"/quota/models":   "Sorted names of every model upstream returns, before any filtering",
+		"/quota/model/:name/percentage": "Plain-text percentage of the one model matching :name (case-insensitive substring)",
+		"/quota/waybar":   "Waybar custom module JSON (text, tooltip, class, percentage)",
+		"/quota/score":    "Single 0-100 health score: average percentage weighted by WEIGHTS",
+		"/quota/compare":  "Per-model change since the previous fetch with estimated time to zero",
+		"/quota/grafana":  "Flat [{time, model, percentage}] samples for Grafana JSON and Infinity data sources",
+		"/quota/badge":    "SVG status badge for ?model=<name>, or the worst tracked model",
+		"/quota/prometheus-textfile": "Prometheus text format metrics for the node_exporter textfile collector",
+		"/quota/pro":      "Gemini 3 Pro models (high, image, low)",
+		"/quota/flash":    "Gemini 3 Flash model",
+		"/quota/claude":   "Claude 4.5 models (opus, sonnet, thinking)",
+		"/quota/glm":      "GLM (Z.ai/ZHIPU) quota usage and limits",
+		"/quota/refresh":  "POST - discard the cached quota and fetch fresh data",
+		"/quota/query":    "POST - quota for the account JSON in the request body, never saved (requires API_KEY)",
+		"/openmetrics":    "OpenMetrics remaining fractions labelled with reset times",
+	}
+
+	prefixed := make(gin.H, len(endpoints))
+	for path, description := range endpoints {
+		prefixed[s.client.config.BasePath+path] = description
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Welcome to the Antigravity Quota API",
-		"endpoints": gin.H{
-			"/quota":          "This endpoint - lists all available endpoints",
-			"/quota/overview": "Quick summary (e.g., 'Pro 95% | Flash 90% | Claude 80%')",
-			"/quota/status":   "Terminal status with nerdfont icons and colors",
-			"/quota/all":      "All models with percentage and relative reset time",
-			"/quota/pro":      "Gemini 3 Pro models (high, image, low)",
-			"/quota/flash":    "Gemini 3 Flash model",
-			"/quota/claude":   "Claude 4.5 models (opus, sonnet, thinking)",
-		},
+		"message":   "Welcome to the Antigravity Quota API",
+		"endpoints": prefixed,
 	})
 }
 
-// getQuotaData helper function to load account and fetch quota
-func (s *QuotaService) getQuotaData() (*QuotaResponse, error) {
+// GetHealthz reports liveness; it only confirms the process is serving requests.
+// Once the background refresher has started it also reports its last success
+// and restart count, so monitoring can detect a refresher that stopped working.
+func (s *QuotaService) GetHealthz(c *gin.Context) {
+	body := gin.H{"status": "ok"}
+	if s.refresher.Started() {
+		refresher := gin.H{"restarts": s.refresher.Restarts()}
+		if lastSuccess := s.refresher.LastSuccess(); !lastSuccess.IsZero() {
+			refresher["last_success"] = lastSuccess.UTC().Format(time.RFC3339)
+			refresher["last_success_unix"] = lastSuccess.Unix()
+		}
+		body["refresher"] = refresher
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// GetReadyz reports readiness by checking the account and token without fetching quota
+func (s *QuotaService) GetReadyz(c *gin.Context) {
+	account, err := s.client.LoadAccount()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "reason": err.Error()})
+		return
+	}
+
+	accessToken, err := s.client.EnsureFreshToken(account)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "reason": err.Error()})
+		return
+	}
+
+	if accessToken == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "reason": "empty access token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// GetStats returns request, cache, and upstream error counters
+func (s *QuotaService) GetStats(c *gin.Context) {
+	c.JSON(http.StatusOK, s.client.stats.Snapshot())
+}
+
+// PostStatsReset zeroes the counters to start a new measurement window and
+// returns the counters of the window it closed
+func (s *QuotaService) PostStatsReset(c *gin.Context) {
+	c.JSON(http.StatusOK, s.client.stats.Reset())
+}
+
+// getQuotaData helper function to load account and fetch quota. A non-empty
+// project overrides the account's project ID and skips its resolution.
+// maxAge is a ?max_age override of the debounce window, or DebounceMaxAge.
+func (s *QuotaService) getQuotaData(ctx context.Context, project string, maxAge time.Duration) (*QuotaResponse, error) {
 	account, err := s.client.LoadAccount()
 	if err != nil {
 		return nil, err
 	}
+	if err := s.client.ValidateAccount(account); err != nil {
+		return nil, err
+	}
 
 	accessToken, err := s.client.EnsureFreshToken(account)
 	if err != nil {
 		return nil, err
 	}
 
+	identity := s.client.accountIdentity(account)
+	if project != "" {
+		quota, err := s.client.GetProjectQuota(ctx, identity, accessToken, project, maxAge, s.client.tokenRefresher(account, true))
+		s.recordServedQuota(ctx, quota, maxAge)
+		return quota, s.client.rejectedTokenError(account, err)
+	}
+
 	_, _, _, projectID := s.client.NormalizeAccount(account)
-	if projectID == "" {
-		projectID, _ = s.client.GetProjectID(accessToken)
+	resolved := projectID == ""
+	if resolved {
+		projectID = s.client.ResolveProjectID(accessToken)
 	}
 
-	return s.client.GetQuota(accessToken, projectID)
+	quota, err := s.client.GetAccountQuota(ctx, identity, accessToken, projectID, maxAge, s.client.tokenRefresher(account, true))
+	s.recordServedQuota(ctx, quota, maxAge)
+	if err != nil && resolved && quotaErrorStatus(err) == http.StatusForbidden {
+		// The cached project ID may have become wrong; look it up again next time
+		s.client.ClearProjectID()
+	}
+	return quota, s.client.rejectedTokenError(account, err)
 }
 
-// formatTimeRemaining calculates time remaining until reset
-func formatTimeRemaining(resetTime string) string {
+// quotaErrorStatus returns the HTTP status an error is reported with, for the
+// plain text endpoints
+func quotaErrorStatus(err error) int {
+	return toAppError(err).Status
+}
+
+// isForbidden reports whether err means upstream refused the account
+func isForbidden(err error) bool {
+	return err != nil && quotaErrorStatus(err) == http.StatusForbidden
+}
+
+// respondDisplayError writes an error response for a failed overview or status,
+// including the rendered forbidden indicator when there is one
+func respondDisplayError(c *gin.Context, err error, display string) {
+	status, body := errorResponse(c, err)
+	if display != "" {
+		body["overview"] = display
+	}
+	c.JSON(status, body)
+}
+
+// respondDisplayErrorText is respondDisplayError for the plain text endpoints
+func respondDisplayErrorText(c *gin.Context, err error, display string) {
+	if display == "" {
+		display = "error: " + err.Error()
+	}
+	c.String(quotaErrorStatus(err), "%s", display)
+}
+
+// forbiddenQuota is the quota shown for an account upstream refused: no models
+func forbiddenQuota() *QuotaResponse {
+	return &QuotaResponse{Models: map[string]ModelInfo{}, Forbidden: true}
+}
+
+// parseResetTime parses a reset time given as RFC3339, a Z-suffixed timestamp,
+// or integer epoch seconds (millisecond epochs are detected and converted)
+func parseResetTime(resetTime string) (time.Time, bool) {
 	if resetTime == "" {
-		return ""
+		return time.Time{}, false
 	}
+	if t, err := time.Parse(time.RFC3339, resetTime); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse("2006-01-02T15:04:05Z", resetTime); err == nil {
+		return t, true
+	}
+	if epoch, err := strconv.ParseInt(resetTime, 10, 64); err == nil && epoch > 0 {
+		return time.Unix(normalizeEpochSeconds(epoch), 0).UTC(), true
+	}
+	return time.Time{}, false
+}
 
-	resetDt, err := time.Parse(time.RFC3339, resetTime)
-	if err != nil {
-		// Try parsing with Z suffix
-		resetDt, err = time.Parse("2006-01-02T15:04:05Z", resetTime)
-		if err != nil {
-			return ""
-		}
+// now is the current time for relative reset times and timestamps; replaced in tests
+var now = time.Now
+
+// formatTimeRemaining calculates time remaining until reset
+func formatTimeRemaining(resetTime string) string {
+	resetDt, ok := parseResetTime(resetTime)
+	if !ok {
+		return ""
 	}
 
-	now := time.Now().UTC()
-	delta := resetDt.Sub(now)
+	delta := resetDt.Sub(now())
 
 	if delta <= 0 {
 		return "Reset due"
 	}
 
+	return formatDurationRemaining(delta)
+}
+
+// formatDurationRemaining formats a positive duration, switching to days past 24 hours
+func formatDurationRemaining(delta time.Duration) string {
 	hours := int(delta.Hours())
 	minutes := int(delta.Minutes()) % 60
+
+	if delta > 24*time.Hour {
+		return fmt.Sprintf("%dd %dh", hours/24, hours%24)
+	}
 	return fmt.Sprintf("%dh %dm", hours, minutes)
 }
 
-// formatQuota formats quota data to match Python implementation
-func formatQuota(quotaData *QuotaResponse, showRelative bool) *FormattedQuota {
-	var models []FormattedModel
+// modelFilter reports whether formatQuota keeps the model with the given name
+type modelFilter func(name string) bool
+
+// knownProviderModel keeps Gemini and Claude models, dropping anything else upstream returns
+func knownProviderModel(name string) bool {
+	nameLower := strings.ToLower(name)
+	return strings.Contains(nameLower, "gemini") || strings.Contains(nameLower, "claude")
+}
+
+// anyModel keeps every model
+func anyModel(string) bool {
+	return true
+}
+
+// includedModels returns the formatQuota filter for INCLUDE_ALL_MODELS
+func includedModels(config *Config) modelFilter {
+	if config.IncludeAllModels {
+		return anyModel
+	}
+	return knownProviderModel
+}
+
+// quotaFormat is how formatQuota reads the upstream response
+type quotaFormat struct {
+	// include keeps the models to format
+	include modelFilter
+
+	// fractionIsPercent reads every remainingFraction as a 0-100 percentage
+	fractionIsPercent bool
+}
+
+// defaultQuotaFormat keeps Gemini and Claude models and detects percentages by value
+var defaultQuotaFormat = quotaFormat{include: knownProviderModel}
+
+// quotaFormatFor returns the formatQuota settings for INCLUDE_ALL_MODELS and FRACTION_IS_PERCENT
+func quotaFormatFor(config *Config) quotaFormat {
+	return quotaFormat{include: includedModels(config), fractionIsPercent: config.FractionIsPercent}
+}
+
+// quotaFormat returns the formatQuota settings for the service's config
+func (s *QuotaService) quotaFormat() quotaFormat {
+	return quotaFormatFor(s.client.config)
+}
+
+// formatQuota formats quota data to match Python implementation, keeping the
+// models format.include accepts. The upstream response is only read, never
+// changed, so /quota/raw still shows it as sent.
+func formatQuota(quotaData *QuotaResponse, showRelative bool, format quotaFormat) *FormattedQuota {
+	models := []FormattedModel{}
 
 	for name, info := range quotaData.Models {
-		remainingFraction := info.QuotaInfo.RemainingFraction
-		resetTime := info.QuotaInfo.ResetTime
+		if !format.include(name) {
+			continue
+		}
 
-		nameLower := strings.ToLower(name)
-		if strings.Contains(nameLower, "gemini") || strings.Contains(nameLower, "claude") {
-			model := FormattedModel{
-				Name:       name,
-				Percentage: int(remainingFraction * 100),
-				ResetTime:  resetTime,
-			}
-			if showRelative && resetTime != "" {
-				model.ResetTimeRelative = formatTimeRemaining(resetTime)
-			}
-			models = append(models, model)
+		resetTime := info.QuotaInfo.ResetTime
+		model := FormattedModel{
+			Name:       name,
+			Percentage: int(info.QuotaInfo.Fraction(format.fractionIsPercent) * 100),
+			ResetTime:  resetTime,
+		}
+		if resetAt, ok := parseResetTime(resetTime); ok {
+			model.ResetTimeUnix = resetAt.Unix()
 		}
+		if showRelative && resetTime != "" {
+			model.ResetTimeRelative = formatTimeRemaining(resetTime)
+		}
+		models = append(models, model)
 	}
 
 	// Sort models by name
@@ -132,8 +400,84 @@ func formatQuota(quotaData *QuotaResponse, showRelative bool) *FormattedQuota {
 
 	return &FormattedQuota{
 		Models:      models,
-		LastUpdated: time.Now().Unix(),
-		IsForbidden: false,
+		LastUpdated: now().Unix(),
+		IsForbidden: quotaData.Forbidden,
+		IsStale:     quotaData.Stale,
+	}
+}
+
+// formatDisplayQuota formats quota with relative reset times and, when a
+// TIMEZONE is configured, reset times in that location
+func (s *QuotaService) formatDisplayQuota(quotaRaw *QuotaResponse) *FormattedQuota {
+	quota := formatQuota(quotaRaw, true, s.quotaFormat())
+	if s.client.config.DecimalPercent {
+		applyPrecisePercentages(quota.Models, quotaRaw, s.client.config.FractionIsPercent)
+	}
+	localizeResetTimes(quota.Models, s.client.config.Location)
+	applyDisplayNames(quota.Models, s.client.config.DisplayNames)
+	applyAlerts(quota, s.displayOptions().thresholds().Critical, s.client.config.AlertThresholds)
+	return quota
+}
+
+// applyAlerts flags the models below their alert threshold: the one of the longest
+// ALERT_THRESHOLDS pattern contained in the name, or critical when none matches
+func applyAlerts(quota *FormattedQuota, critical int, thresholds map[string]int) {
+	quota.Alerts = []string{}
+	for _, model := range quota.Models {
+		if model.Percentage < alertThreshold(model.Name, thresholds, critical) {
+			quota.Alerts = append(quota.Alerts, model.Name)
+		}
+	}
+	quota.Alert = len(quota.Alerts) > 0
+}
+
+// percentageDecimals is the precision of percentage_precise
+const percentageDecimals = 1
+
+// roundPercentage converts a remaining fraction to a percentage rounded half-up to
+// the given number of decimal places. Float noise is rounded off first so that
+// e.g. 0.945 doesn't land just under the half and round down.
+func roundPercentage(fraction float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	scaled := math.Round(fraction*100*scale*1e6) / 1e6
+	return math.Floor(scaled+0.5) / scale
+}
+
+// applyPrecisePercentages rounds each model's percentage half-up instead of
+// truncating it and sets percentage_precise from the raw remaining fraction
+func applyPrecisePercentages(models []FormattedModel, quotaRaw *QuotaResponse, fractionIsPercent bool) {
+	for i := range models {
+		info, ok := quotaRaw.Models[models[i].Name]
+		if !ok {
+			continue
+		}
+		fraction := info.QuotaInfo.Fraction(fractionIsPercent)
+		precise := roundPercentage(fraction, percentageDecimals)
+		models[i].Percentage = int(roundPercentage(fraction, 0))
+		models[i].PercentagePrecise = &precise
+	}
+}
+
+// applyDisplayNames sets DisplayName on each model from its raw name's alias, or
+// to the raw name when it has none. Filtering keeps matching on the raw name.
+func applyDisplayNames(models []FormattedModel, names map[string]string) {
+	if names == nil {
+		names = DefaultModelDisplayNames
+	}
+	for i := range models {
+		models[i].DisplayName = firstNonEmpty(names[strings.ToLower(models[i].Name)], models[i].Name)
+	}
+}
+
+// localizeResetTimes sets ResetTimeLocal on each model with a parseable reset time
+func localizeResetTimes(models []FormattedModel, loc *time.Location) {
+	if loc == nil {
+		return
+	}
+	for i := range models {
+		if resetDt, ok := parseResetTime(models[i].ResetTime); ok {
+			models[i].ResetTimeLocal = resetDt.In(loc).Format(time.RFC3339)
+		}
 	}
 }
 
@@ -151,230 +495,1419 @@ func filterModels(quota *FormattedQuota, patterns []string) *FormattedQuota {
 		}
 	}
 
+	// Keep only the alerts for models that survived the filter
+	alerts := []string{}
+	for _, name := range quota.Alerts {
+		for _, model := range filtered {
+			if model.Name == name {
+				alerts = append(alerts, name)
+				break
+			}
+		}
+	}
+
 	return &FormattedQuota{
 		Models:      filtered,
 		LastUpdated: quota.LastUpdated,
 		IsForbidden: quota.IsForbidden,
+		IsStale:     quota.IsStale,
+		Alert:       len(alerts) > 0,
+		Alerts:      alerts,
 	}
 }
 
-// GetQuotaOverview returns quick quota summary
+// GetQuotaOverview returns quick quota summary, as plain text when the Accept
+// header prefers text/plain
 func (s *QuotaService) GetQuotaOverview(c *gin.Context) {
-	quotaRaw, err := s.getQuotaData()
+	if wantsPlainText(c) {
+		s.GetQuotaOverviewText(c)
+		return
+	}
+
+	overview, err := s.getOverview(c.Request.Context(), c.Query("project"), requestMaxAge(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondDisplayError(c, err, overview)
 		return
 	}
 
-	quotaFormatted := formatQuota(quotaRaw, false)
+	c.JSON(http.StatusOK, gin.H{"overview": overview})
+}
 
-	// Get Pro average (gemini-3-pro-high)
-	proPct := 0
-	for _, model := range quotaFormatted.Models {
-		if strings.Contains(strings.ToLower(model.Name), "gemini-3-pro-high") {
-			proPct = model.Percentage
-			break
-		}
+// GetQuotaOverviewText returns the quick quota summary as plain text
+func (s *QuotaService) GetQuotaOverviewText(c *gin.Context) {
+	overview, err := s.getOverview(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondDisplayErrorText(c, err, overview)
+		return
 	}
 
-	// Get Flash (gemini-3-flash)
-	flashPct := 0
-	for _, model := range quotaFormatted.Models {
-		if strings.Contains(strings.ToLower(model.Name), "gemini-3-flash") {
-			flashPct = model.Percentage
-			break
-		}
-	}
+	c.String(http.StatusOK, "%s", overview)
+}
 
-	// Get Claude (claude-sonnet-4-5, non-thinking)
-	claudePct := 0
-	for _, model := range quotaFormatted.Models {
-		if strings.ToLower(model.Name) == "claude-sonnet-4-5" {
-			claudePct = model.Percentage
-			break
-		}
+// wantsPlainText reports whether the Accept header prefers text/plain to JSON.
+// A missing header or */* keeps JSON. Either way the response varies on Accept.
+func wantsPlainText(c *gin.Context) bool {
+	c.Writer.Header().Add("Vary", "Accept")
+	return c.NegotiateFormat(gin.MIMEJSON, gin.MIMEPlain) == gin.MIMEPlain
+}
+
+// getOverview fetches quota and builds the quick summary string. A forbidden
+// account still gets its indicator rendered alongside the error.
+func (s *QuotaService) getOverview(ctx context.Context, project string, maxAge time.Duration) (string, error) {
+	quotaRaw, err := s.getQuotaData(ctx, project, maxAge)
+	if isForbidden(err) {
+		return buildOverview(formatQuota(forbiddenQuota(), false, defaultQuotaFormat), s.displayOptions()), err
+	}
+	if err != nil {
+		return "", err
 	}
 
-	overview := fmt.Sprintf("Pro %d%% | Flash %d%% | Claude %d%%", proPct, flashPct, claudePct)
-	c.JSON(http.StatusOK, gin.H{"overview": overview})
+	return buildOverview(formatQuota(quotaRaw, false, s.quotaFormat()), s.displayOptions()), nil
 }
 
-// formatPercentageWithColor formats percentage with ANSI colors
-func formatPercentageWithColor(pct int) string {
-	const (
-		Green = "\033[32m"
-		Yellow = "\033[33m"
-		Red = "\033[31m"
-		Reset = "\033[0m"
-	)
+// DisplayOptions controls how overview and status strings are rendered
+type DisplayOptions struct {
+	ShowDays      bool
+	Labels        map[string]ModelLabel
+	Thresholds    QuotaThresholds
+	TrackedModels []string
+	ProAverage    bool
+	HideResetTime bool
+	ShowNextReset bool
+}
 
-	if pct == QuotaFull {
-		return Green + "●" + Reset
-	} else if pct >= QuotaGood {
-		return Green + strconv.Itoa(pct) + "%" + Reset
-	} else if pct >= QuotaWarning {
-		return Yellow + strconv.Itoa(pct) + "%" + Reset
-	} else if pct >= QuotaCritical {
-		return Red + strconv.Itoa(pct) + "%" + Reset
-	} else {
-		return Red + "●" + Reset
+// displayOptions builds display options from the service config
+func (s *QuotaService) displayOptions() DisplayOptions {
+	return DisplayOptions{
+		ShowDays:      s.client.config.CompactShowDays,
+		Labels:        s.client.config.ModelLabels,
+		Thresholds:    s.client.config.Thresholds,
+		TrackedModels: s.client.config.TrackedModels,
+		ProAverage:    s.client.config.ProAverage,
+		ShowNextReset: s.client.config.OverviewShowReset,
 	}
 }
 
-// formatTimeCompact formats time in compact format
-func formatTimeCompact(resetTime string) string {
-	if resetTime == "" {
-		return ""
+// thresholds returns the configured color thresholds, or the defaults when unset
+func (o DisplayOptions) thresholds() QuotaThresholds {
+	if o.Thresholds == (QuotaThresholds{}) {
+		return DefaultQuotaThresholds
 	}
+	return o.Thresholds
+}
 
-	resetDt, err := time.Parse(time.RFC3339, resetTime)
-	if err != nil {
-		resetDt, err = time.Parse("2006-01-02T15:04:05Z", resetTime)
-		if err != nil {
-			return ""
-		}
+// trackedModels returns the configured overview slots, or the defaults when unset
+func (o DisplayOptions) trackedModels() []string {
+	if len(o.TrackedModels) == 0 {
+		return DefaultTrackedModels
 	}
+	return o.TrackedModels
+}
 
-	now := time.Now().UTC()
-	delta := resetDt.Sub(now)
+// labelFor returns the configured label for a model, filling unset fields from the default.
+// The longest configured substring contained in the model name wins.
+func (o DisplayOptions) labelFor(name string, fallback ModelLabel) ModelLabel {
+	nameLower := strings.ToLower(name)
+	match := ""
+	for pattern := range o.Labels {
+		if strings.Contains(nameLower, pattern) && len(pattern) > len(match) {
+			match = pattern
+		}
+	}
+	if match == "" {
+		return fallback
+	}
 
-	if delta <= 0 {
-		return ""
+	label := o.Labels[match]
+	if label.Label == "" {
+		label.Label = fallback.Label
+	}
+	if label.Icon == "" {
+		label.Icon = fallback.Icon
 	}
+	return label
+}
 
-	hours := int(delta.Hours())
-	minutes := int(delta.Minutes()) % 60
+// findModel returns the first model whose name contains pattern
+func findModel(models []FormattedModel, pattern string) (FormattedModel, bool) {
+	for _, model := range models {
+		if strings.Contains(strings.ToLower(model.Name), pattern) {
+			return model, true
+		}
+	}
+	return FormattedModel{}, false
+}
 
-	if hours == 0 && minutes == 0 {
-		return ""
-	} else if hours == 0 {
-		return fmt.Sprintf("%dm", minutes)
-	} else if minutes == 0 {
-		return fmt.Sprintf("%dh", hours)
-	} else {
-		return fmt.Sprintf("%dh%dm", hours, minutes)
+// pickClaudeModel returns claude-sonnet-4-5 when present, otherwise the
+// highest-versioned non-thinking claude-sonnet model
+func pickClaudeModel(models []FormattedModel) (FormattedModel, bool) {
+	var best FormattedModel
+	found := false
+	for _, model := range models {
+		name := strings.ToLower(model.Name)
+		if name == "claude-sonnet-4-5" {
+			return model, true
+		}
+		if !strings.HasPrefix(name, "claude-sonnet") || strings.Contains(name, "thinking") {
+			continue
+		}
+		if !found || compareModelNames(name, strings.ToLower(best.Name)) > 0 {
+			best = model
+			found = true
+		}
 	}
+	return best, found
 }
 
-// GetQuotaStatus returns terminal-friendly status
-func (s *QuotaService) GetQuotaStatus(c *gin.Context) {
-	quotaRaw, err := s.getQuotaData()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+// compareModelNames compares dash-separated model names, treating numeric segments as numbers
+func compareModelNames(a, b string) int {
+	aParts := strings.Split(a, "-")
+	bParts := strings.Split(b, "-")
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNum, aErr := strconv.Atoi(aParts[i])
+		bNum, bErr := strconv.Atoi(bParts[i])
+		if aErr == nil && bErr == nil {
+			if aNum != bNum {
+				return aNum - bNum
+			}
+			continue
+		}
+		if cmp := strings.Compare(aParts[i], bParts[i]); cmp != 0 {
+			return cmp
+		}
 	}
+	return len(aParts) - len(bParts)
+}
 
-	quotaFormatted := formatQuota(quotaRaw, true)
+// Default labels and icons for the overview and status slots
+var (
+	defaultProLabel    = ModelLabel{Label: "Pro", Icon: "G"}
+	defaultFlashLabel  = ModelLabel{Label: "Flash", Icon: "F"}
+	defaultClaudeLabel = ModelLabel{Label: "Claude", Icon: "󰛄"}
+)
 
-	const (
-		Green = "\033[32m"
-		Red = "\033[31m"
-		Reset = "\033[0m"
-		GeminiIcon = "G"
-		FlashIcon = "F"
-		ClaudeIcon = "󰛄"
-	)
+// overviewSlot is a tracked model along with its display label
+type overviewSlot struct {
+	model FormattedModel
+	label ModelLabel
+}
 
-	formatModelStatus := func(icon string, pct int, resetTime string) string {
-		if pct == QuotaFull {
-			return Green + icon + Reset
-		} else if pct == 0 {
-			return Red + icon + Reset
-		} else {
-			pctStr := formatPercentageWithColor(pct)
-			timeStr := formatTimeCompact(resetTime)
-			if timeStr != "" {
-				return fmt.Sprintf("%s %s %s", icon, pctStr, timeStr)
+// defaultSlotLabels are the built-in labels for the default tracked models
+var defaultSlotLabels = map[string]ModelLabel{
+	"gemini-3-pro-high": defaultProLabel,
+	"gemini-3-flash":    defaultFlashLabel,
+	"claude-sonnet-4-5": defaultClaudeLabel,
+}
+
+// overviewSlots picks one model per tracked pattern and resolves its label.
+// Patterns without a built-in label use the pattern itself as label and icon.
+func overviewSlots(quota *FormattedQuota, opts DisplayOptions) []overviewSlot {
+	var slots []overviewSlot
+	for _, pattern := range opts.trackedModels() {
+		model, _ := findTrackedModel(quota.Models, pattern)
+		if opts.ProAverage && pattern == "gemini-3-pro-high" {
+			if pct, ok := averagePercentage(quota.Models, geminiProTiers); ok {
+				model.Percentage = pct
 			}
-			return fmt.Sprintf("%s %s", icon, pctStr)
 		}
+		fallback, ok := defaultSlotLabels[pattern]
+		if !ok {
+			fallback = ModelLabel{Label: pattern, Icon: pattern}
+		}
+		slots = append(slots, overviewSlot{model, opts.labelFor(firstNonEmpty(model.Name, pattern), fallback)})
 	}
+	return slots
+}
 
-	// Get Pro (gemini-3-pro-high)
-	proPct, proReset := 0, ""
-	for _, model := range quotaFormatted.Models {
-		if strings.Contains(strings.ToLower(model.Name), "gemini-3-pro-high") {
-			proPct = model.Percentage
-			proReset = model.ResetTime
-			break
-		}
+// findTrackedModel finds the model for a tracked pattern; claude-sonnet-4-5
+// falls back to the newest non-thinking claude-sonnet model
+func findTrackedModel(models []FormattedModel, pattern string) (FormattedModel, bool) {
+	if pattern == "claude-sonnet-4-5" {
+		return pickClaudeModel(models)
 	}
+	return findModel(models, pattern)
+}
 
-	// Get Flash (gemini-3-flash)
-	flashPct, flashReset := 0, ""
-	for _, model := range quotaFormatted.Models {
-		if strings.Contains(strings.ToLower(model.Name), "gemini-3-flash") {
-			flashPct = model.Percentage
-			flashReset = model.ResetTime
-			break
+// geminiProTiers are the Gemini 3 Pro model variants
+var geminiProTiers = []string{"gemini-3-pro-high", "gemini-3-pro-image", "gemini-3-pro-low"}
+
+// averagePercentage returns the rounded average percentage of the models
+// matching patterns, skipping patterns with no matching model
+func averagePercentage(models []FormattedModel, patterns []string) (int, bool) {
+	sum, count := 0, 0
+	for _, pattern := range patterns {
+		if model, ok := findModel(models, pattern); ok {
+			sum += model.Percentage
+			count++
 		}
 	}
+	if count == 0 {
+		return 0, false
+	}
+	return (sum + count/2) / count, true
+}
 
-	// Get Claude (claude-sonnet-4-5)
-	claudePct, claudeReset := 0, ""
-	for _, model := range quotaFormatted.Models {
-		if strings.ToLower(model.Name) == "claude-sonnet-4-5" {
-			claudePct = model.Percentage
-			claudeReset = model.ResetTime
-			break
+// firstNonEmpty returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
 		}
 	}
+	return ""
+}
 
-	proStr := formatModelStatus(GeminiIcon, proPct, proReset)
-	flashStr := formatModelStatus(FlashIcon, flashPct, flashReset)
-	claudeStr := formatModelStatus(ClaudeIcon, claudePct, claudeReset)
+// ForbiddenIndicator replaces the overview and status when upstream refuses the account
+const ForbiddenIndicator = "forbidden"
 
-	overview := fmt.Sprintf("%s | %s | %s", proStr, flashStr, claudeStr)
-	c.JSON(http.StatusOK, gin.H{"overview": overview})
-}
+// buildOverview builds the quick summary string from formatted quota
+func buildOverview(quota *FormattedQuota, opts DisplayOptions) string {
+	if quota.IsForbidden {
+		return ForbiddenIndicator
+	}
 
-// GetAllQuota returns all models with relative reset time
-func (s *QuotaService) GetAllQuota(c *gin.Context) {
-	quotaRaw, err := s.getQuotaData()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	slots := overviewSlots(quota, opts)
+	var parts []string
+	for _, slot := range slots {
+		parts = append(parts, fmt.Sprintf("%s %d%%", slot.label.Label, slot.model.Percentage))
 	}
+	overview := strings.Join(parts, " | ")
 
-	quotaFormatted := formatQuota(quotaRaw, true)
-	c.JSON(http.StatusOK, gin.H{"quota": quotaFormatted})
+	if opts.ShowNextReset {
+		if resetAt, ok := nextSlotReset(slots); ok {
+			overview += fmt.Sprintf(" (next reset %s)", formatDurationRemaining(resetAt.Sub(now())))
+		}
+	}
+	return overview
 }
 
-// GetGemini3Pro returns Gemini 3 Pro models
-func (s *QuotaService) GetGemini3Pro(c *gin.Context) {
-	quotaRaw, err := s.getQuotaData()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+// nextSlotReset returns the soonest upcoming reset among the tracked slots
+func nextSlotReset(slots []overviewSlot) (time.Time, bool) {
+	var soonest time.Time
+	for _, slot := range slots {
+		resetAt, ok := parseResetTime(slot.model.ResetTime)
+		if !ok || !resetAt.After(now()) {
+			continue
+		}
+		if soonest.IsZero() || resetAt.Before(soonest) {
+			soonest = resetAt
+		}
 	}
+	return soonest, !soonest.IsZero()
+}
 
-	quotaFormatted := formatQuota(quotaRaw, true)
-	filtered := filterModels(quotaFormatted, []string{"gemini-3-pro-high", "gemini-3-pro-image", "gemini-3-pro-low"})
-	c.JSON(http.StatusOK, gin.H{"quota": filtered})
+// ColorTheme holds the color codes used to render status strings
+type ColorTheme struct {
+	Green  string
+	Yellow string
+	Red    string
+	Reset  string
 }
 
-// GetGemini3Flash returns Gemini 3 Flash model
-func (s *QuotaService) GetGemini3Flash(c *gin.Context) {
-	quotaRaw, err := s.getQuotaData()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
+// ANSITheme renders colors with ANSI escape codes
+var ANSITheme = ColorTheme{
+	Green:  "\033[32m",
+	Yellow: "\033[33m",
+	Red:    "\033[31m",
+	Reset:  "\033[0m",
+}
 
-	quotaFormatted := formatQuota(quotaRaw, true)
-	filtered := filterModels(quotaFormatted, []string{"gemini-3-flash"})
-	c.JSON(http.StatusOK, gin.H{"quota": filtered})
+// TmuxTheme renders colors with tmux style directives for use in status-right
+var TmuxTheme = ColorTheme{
+	Green:  "#[fg=green]",
+	Yellow: "#[fg=yellow]",
+	Red:    "#[fg=red]",
+	Reset:  "#[default]",
 }
 
-// GetClaude45 returns Claude 4.5 models
-func (s *QuotaService) GetClaude45(c *gin.Context) {
-	quotaRaw, err := s.getQuotaData()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
+// formatPercentageWithColor formats percentage with ANSI colors
+func formatPercentageWithColor(pct int, thresholds QuotaThresholds) string {
+	return formatPercentageWithTheme(pct, ANSITheme, thresholds)
+}
 
-	quotaFormatted := formatQuota(quotaRaw, true)
-	filtered := filterModels(quotaFormatted, []string{"claude-opus-4-5-thinking", "claude-sonnet-4-5", "claude-sonnet-4-5-thinking"})
+// formatPercentageWithTheme formats percentage with the given theme's colors
+func formatPercentageWithTheme(pct int, theme ColorTheme, thresholds QuotaThresholds) string {
+	if pct == QuotaFull {
+		return theme.Green + "●" + theme.Reset
+	} else if pct >= thresholds.Good {
+		return theme.Green + strconv.Itoa(pct) + "%" + theme.Reset
+	} else if pct >= thresholds.Warning {
+		return theme.Yellow + strconv.Itoa(pct) + "%" + theme.Reset
+	} else if pct >= thresholds.Critical {
+		return theme.Red + strconv.Itoa(pct) + "%" + theme.Reset
+	} else {
+		return theme.Red + "●" + theme.Reset
+	}
+}
+
+// formatTimeCompact formats time in compact format
+func formatTimeCompact(resetTime string, showDays bool) string {
+	resetDt, ok := parseResetTime(resetTime)
+	if !ok {
+		return ""
+	}
+
+	delta := resetDt.Sub(now())
+
+	if delta <= 0 {
+		return ""
+	}
+
+	return formatDurationCompact(delta, showDays)
+}
+
+// formatDurationCompact formats a positive duration compactly, optionally using days past 24 hours
+func formatDurationCompact(delta time.Duration, showDays bool) string {
+	hours := int(delta.Hours())
+	minutes := int(delta.Minutes()) % 60
+
+	if showDays && delta > 24*time.Hour {
+		days, hours := hours/24, hours%24
+		if hours == 0 {
+			return fmt.Sprintf("%dd", days)
+		}
+		return fmt.Sprintf("%dd%dh", days, hours)
+	}
+
+	if hours == 0 && minutes == 0 {
+		return ""
+	} else if hours == 0 {
+		return fmt.Sprintf("%dm", minutes)
+	} else if minutes == 0 {
+		return fmt.Sprintf("%dh", hours)
+	} else {
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	}
+}
+
+// GetQuotaStatus returns terminal-friendly status, as plain text when the Accept
+// header prefers text/plain
+func (s *QuotaService) GetQuotaStatus(c *gin.Context) {
+	if wantsPlainText(c) {
+		s.GetQuotaStatusText(c)
+		return
+	}
+
+	status, err := s.getStatus(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondDisplayError(c, err, status)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"overview": status})
+}
+
+// GetQuotaStatusText returns the terminal-friendly status as plain text
+func (s *QuotaService) GetQuotaStatusText(c *gin.Context) {
+	status, err := s.getStatus(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondDisplayErrorText(c, err, status)
+		return
+	}
+
+	c.String(http.StatusOK, "%s", status)
+}
+
+// getStatus fetches quota and builds the terminal status string. A forbidden
+// account still gets its indicator rendered alongside the error.
+func (s *QuotaService) getStatus(ctx context.Context, project string, maxAge time.Duration) (string, error) {
+	quotaRaw, err := s.getQuotaData(ctx, project, maxAge)
+	if isForbidden(err) {
+		return buildStatus(formatQuota(forbiddenQuota(), false, defaultQuotaFormat), ANSITheme, s.displayOptions()), err
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return buildStatus(s.formatDisplayQuota(quotaRaw), ANSITheme, s.displayOptions()), nil
+}
+
+// GetQuotaTmux returns the status as plain text colored with tmux directives,
+// for use as #(curl ...) in status-right
+func (s *QuotaService) GetQuotaTmux(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if isForbidden(err) {
+		respondDisplayErrorText(c, err, buildStatus(formatQuota(forbiddenQuota(), false, defaultQuotaFormat), TmuxTheme, s.displayOptions()))
+		return
+	}
+	if err != nil {
+		c.String(quotaErrorStatus(err), "error: %s", err.Error())
+		return
+	}
+
+	opts := s.displayOptions()
+	opts.HideResetTime = !s.client.config.TmuxShowReset
+	c.String(http.StatusOK, "%s", buildStatus(s.formatDisplayQuota(quotaRaw), TmuxTheme, opts))
+}
+
+// buildStatus builds the terminal status string with icons, colors, and reset times
+func buildStatus(quota *FormattedQuota, theme ColorTheme, opts DisplayOptions) string {
+	if quota.IsForbidden {
+		return theme.Red + ForbiddenIndicator + theme.Reset
+	}
+
+	formatModelStatus := func(icon string, model FormattedModel) string {
+		if model.Percentage == QuotaFull {
+			return theme.Green + icon + theme.Reset
+		} else if model.Percentage == 0 {
+			return theme.Red + icon + theme.Reset
+		} else {
+			pctStr := formatPercentageWithTheme(model.Percentage, theme, opts.thresholds())
+			timeStr := formatTimeCompact(model.ResetTime, opts.ShowDays)
+			if timeStr != "" && !opts.HideResetTime {
+				return fmt.Sprintf("%s %s %s", icon, pctStr, timeStr)
+			}
+			return fmt.Sprintf("%s %s", icon, pctStr)
+		}
+	}
+
+	var parts []string
+	for _, slot := range overviewSlots(quota, opts) {
+		parts = append(parts, formatModelStatus(slot.label.Icon, slot.model))
+	}
+	return strings.Join(parts, " | ")
+}
+
+// PostQuotaRefresh clears the quota cache and returns freshly fetched quota
+func (s *QuotaService) PostQuotaRefresh(c *gin.Context) {
+	s.client.ClearCache()
+
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"quota": s.formatDisplayQuota(quotaRaw)})
+}
+
+// PostQuotaQuery fetches quota for the account in the request body instead of the
+// configured one. The account is only held in memory: a refreshed token is never
+// written to ACCOUNT_FILE and its fetches do not feed the compare history.
+func (s *QuotaService) PostQuotaQuery(c *gin.Context) {
+	var account Account
+	if err := c.ShouldBindJSON(&account); err != nil {
+		respondError(c, newAppError(http.StatusBadRequest, CodeBadRequest, "invalid account JSON: "+err.Error()))
+		return
+	}
+
+	quotaRaw, err := s.getPostedQuotaData(c.Request.Context(), &account, c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"quota": s.formatDisplayQuota(quotaRaw)})
+}
+
+// getPostedQuotaData is getQuotaData for an account from a request. Its project ID
+// is looked up without touching the configured account's cached one.
+func (s *QuotaService) getPostedQuotaData(ctx context.Context, account *Account, project string, maxAge time.Duration) (*QuotaResponse, error) {
+	if err := s.client.ValidateAccount(account); err != nil {
+		return nil, newAppError(http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+
+	accessToken, err := s.client.EnsureFreshTokenInMemory(account)
+	if err != nil {
+		return nil, err
+	}
+
+	if project == "" {
+		_, _, _, project = s.client.NormalizeAccount(account)
+	}
+	if project == "" {
+		project = s.client.lookupProjectID(accessToken)
+	}
+
+	quota, err := s.client.GetProjectQuota(ctx, s.client.accountIdentity(account), accessToken, project, maxAge, s.client.tokenRefresher(account, false))
+	return quota, s.client.rejectedTokenError(account, err)
+}
+
+// CacheHeaders sets Cache-Control to how long the served quota stays fresh and an
+// ETag from the response body on successful quota GETs, sending 304 Not Modified
+// instead of the body when the client's If-None-Match matches
+func (s *QuotaService) CacheHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+
+		served := &servedQuota{}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), servedQuotaKey{}, served))
+
+		original := c.Writer
+		buffered := &bufferedWriter{ResponseWriter: original}
+		c.Writer = buffered
+		// Restored even if a handler panics, so Recovery's error reaches the client
+		defer func() { c.Writer = original }()
+		c.Next()
+
+		body := buffered.body.Bytes()
+		if buffered.Status() == http.StatusOK {
+			etag, stale := responseETag(body)
+			maxAge := s.client.CacheTTL()
+			if !served.expiresAt.IsZero() {
+				maxAge = max(served.expiresAt.Sub(s.client.now()), 0)
+			}
+			if stale {
+				maxAge = 0
+			}
+
+			header := original.Header()
+			header.Set("Cache-Control", fmt.Sprintf("max-age=%d", int(maxAge.Seconds())))
+			header.Set("ETag", etag)
+
+			if etagMatches(c.GetHeader("If-None-Match"), etag) {
+				header.Del("Content-Type")
+				original.WriteHeader(http.StatusNotModified)
+				original.WriteHeaderNow()
+				return
+			}
+		}
+
+		if buffered.status != 0 {
+			original.WriteHeader(buffered.status)
+		}
+		original.Write(body)
+	}
+}
+
+// servedQuotaKey is the request context key CacheHeaders stores its servedQuota under
+type servedQuotaKey struct{}
+
+// servedQuota is when the quota served for a request stops being fresh. It stays
+// zero when the request fetched no quota.
+type servedQuota struct {
+	expiresAt time.Time
+}
+
+// recordServedQuota notes on ctx's servedQuota when quota stops being fresh: maxAge
+// after it was fetched, or QUERY_DEBOUNCE for DebounceMaxAge. The earliest expiry
+// wins when a request serves several.
+func (s *QuotaService) recordServedQuota(ctx context.Context, quota *QuotaResponse, maxAge time.Duration) {
+	served, ok := ctx.Value(servedQuotaKey{}).(*servedQuota)
+	if !ok || quota == nil || quota.FetchedAt.IsZero() {
+		return
+	}
+
+	if maxAge == DebounceMaxAge {
+		maxAge = time.Duration(s.client.config.QueryDebounce) * time.Minute
+	}
+	expiresAt := quota.FetchedAt.Add(maxAge)
+	if served.expiresAt.IsZero() || expiresAt.Before(served.expiresAt) {
+		served.expiresAt = expiresAt
+	}
+}
+
+// responseETag hashes a response body and reports whether it carries stale quota.
+// JSON bodies are hashed without their per-request last_updated timestamps.
+func responseETag(body []byte) (string, bool) {
+	stale := false
+	var payload any
+	if err := json.Unmarshal(body, &payload); err == nil {
+		payload = stripLastUpdated(payload, &stale)
+		if normalized, err := json.Marshal(payload); err == nil {
+			body = normalized
+		}
+	}
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, stale
+}
+
+// stripLastUpdated removes last_updated keys from a decoded JSON value, setting
+// stale when any object has "is_stale": true
+func stripLastUpdated(value any, stale *bool) any {
+	switch v := value.(type) {
+	case map[string]any:
+		delete(v, "last_updated")
+		if isStale, ok := v["is_stale"].(bool); ok && isStale {
+			*stale = true
+		}
+		for key, child := range v {
+			v[key] = stripLastUpdated(child, stale)
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = stripLastUpdated(child, stale)
+		}
+	}
+	return value
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using weak comparison
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDebugToken reports whether the access token is fresh and whether a refresh
+// would succeed, without saving any refreshed token
+func (s *QuotaService) GetDebugToken(c *gin.Context) {
+	account, err := s.client.LoadAccount()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if err := s.client.ValidateAccount(account); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, s.client.DiagnoseToken(account))
+}
+
+// GetDebugAccount loads and validates the account and reports how it was parsed,
+// with tokens redacted. An invalid account is still summarized.
+func (s *QuotaService) GetDebugAccount(c *gin.Context) {
+	account, err := s.client.LoadAccount()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, s.client.DescribeAccount(account))
+}
+
+// GetDebugCache returns the age and validity of the account's cached quota
+func (s *QuotaService) GetDebugCache(c *gin.Context) {
+	c.JSON(http.StatusOK, s.client.CacheState())
+}
+
+// GetDebugProject reports the project ID quota requests use and whether it came
+// from the account, the cache, or a live lookup
+func (s *QuotaService) GetDebugProject(c *gin.Context) {
+	account, err := s.client.LoadAccount()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if err := s.client.ValidateAccount(account); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	resolution, err := s.client.DiagnoseProject(account)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resolution)
+}
+
+// GetAllQuota returns all models with relative reset time
+func (s *QuotaService) GetAllQuota(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	quotaFormatted := s.formatDisplayQuota(quotaRaw)
+	if err := sortModels(quotaFormatted.Models, c.DefaultQuery("sort", "name")); err != nil {
+		respondError(c, newAppError(http.StatusBadRequest, CodeBadRequest, err.Error()))
+		return
+	}
+
+	limit, err := nonNegativeQuery(c, "limit", -1)
+	if err != nil {
+		respondError(c, newAppError(http.StatusBadRequest, CodeBadRequest, err.Error()))
+		return
+	}
+	offset, err := nonNegativeQuery(c, "offset", 0)
+	if err != nil {
+		respondError(c, newAppError(http.StatusBadRequest, CodeBadRequest, err.Error()))
+		return
+	}
+
+	group := c.Query("group")
+	if group != "" && group != "provider" {
+		respondError(c, newAppError(http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("invalid group %q: must be provider", group)))
+		return
+	}
+
+	total := len(quotaFormatted.Models)
+	quotaFormatted.Models = paginateModels(quotaFormatted.Models, limit, offset)
+
+	if group == "provider" {
+		c.JSON(http.StatusOK, gin.H{"quota": groupByProvider(quotaFormatted), "total": total})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"quota": quotaFormatted, "total": total})
+}
+
+// GroupedQuota is formatted quota with its models bucketed by provider
+type GroupedQuota struct {
+	*FormattedQuota
+	Models map[string][]FormattedModel `json:"models"`
+}
+
+// groupByProvider buckets models by modelProvider, keeping their order within
+// each bucket; providers with no models are left out
+func groupByProvider(quota *FormattedQuota) GroupedQuota {
+	groups := make(map[string][]FormattedModel)
+	for _, model := range quota.Models {
+		provider := modelProvider(model.Name)
+		groups[provider] = append(groups[provider], model)
+	}
+	return GroupedQuota{FormattedQuota: quota, Models: groups}
+}
+
+// modelProvider infers a model's provider from its name: gemini, claude, or other
+func modelProvider(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.Contains(lower, "gemini"):
+		return "gemini"
+	case strings.Contains(lower, "claude"):
+		return "claude"
+	default:
+		return "other"
+	}
+}
+
+// nonNegativeQuery parses an optional non-negative integer query param
+func nonNegativeQuery(c *gin.Context, name string, defaultValue int) (int, error) {
+	raw, ok := c.GetQuery(name)
+	if !ok {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative integer", name, raw)
+	}
+	return value, nil
+}
+
+// paginateModels returns up to limit models starting at offset; a negative
+// limit means no limit, and an offset past the end yields an empty page
+func paginateModels(models []FormattedModel, limit, offset int) []FormattedModel {
+	if offset >= len(models) {
+		return []FormattedModel{}
+	}
+	models = models[offset:]
+	if limit >= 0 && limit < len(models) {
+		models = models[:limit]
+	}
+	return models
+}
+
+// sortModels orders models by name, soonest reset, or lowest percentage.
+// Models are assumed to be name-sorted already, so ties keep name order.
+func sortModels(models []FormattedModel, by string) error {
+	switch by {
+	case "name":
+		sort.SliceStable(models, func(i, j int) bool {
+			return models[i].Name < models[j].Name
+		})
+	case "reset":
+		sort.SliceStable(models, func(i, j int) bool {
+			return resetsBefore(models[i].ResetTime, models[j].ResetTime)
+		})
+	case "percentage":
+		sort.SliceStable(models, func(i, j int) bool {
+			return models[i].Percentage < models[j].Percentage
+		})
+	default:
+		return fmt.Errorf("invalid sort %q (expected name, reset, or percentage)", by)
+	}
+	return nil
+}
+
+// GetModelNames returns the sorted names of every model in the upstream response,
+// including ones the formatted endpoints leave out
+func (s *QuotaService) GetModelNames(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	names := make([]string, 0, len(quotaRaw.Models))
+	for name := range quotaRaw.Models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.JSON(http.StatusOK, names)
+}
+
+// GetRawQuota returns the unmodified upstream quota response
+func (s *QuotaService) GetRawQuota(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, quotaRaw)
+}
+
+// GetWorstQuota returns the model closest to exhaustion
+func (s *QuotaService) GetWorstQuota(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	worst, ok := worstModel(s.formatDisplayQuota(quotaRaw).Models)
+	if !ok {
+		respondError(c, newAppError(http.StatusNotFound, CodeNotFound, "no models available"))
+		return
+	}
+
+	c.JSON(http.StatusOK, worst)
+}
+
+// WorstModel is the most-depleted model, as returned by /quota/worst
+type WorstModel struct {
+	Model   FormattedModel `json:"model"`
+	AllFull bool           `json:"all_full"`
+}
+
+// worstModel returns the most-depleted model and whether every model is full,
+// or false when there are no models
+func worstModel(models []FormattedModel) (WorstModel, bool) {
+	worst, ok := findWorstModel(models)
+	if !ok {
+		return WorstModel{}, false
+	}
+	return WorstModel{Model: worst, AllFull: worst.Percentage == QuotaFull}, true
+}
+
+// Errors returned by matchModel
+var (
+	errModelNotFound  = errors.New("no model matches")
+	errAmbiguousModel = errors.New("pattern matches more than one model")
+)
+
+// GetNextReset returns the soonest upcoming reset across all models
+func (s *QuotaService) GetNextReset(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	next, ok := nextReset(s.formatDisplayQuota(quotaRaw).Models, now())
+	if !ok {
+		respondError(c, newAppError(http.StatusNotFound, CodeNotFound, "no upcoming resets"))
+		return
+	}
+
+	c.JSON(http.StatusOK, next)
+}
+
+// NextReset is the soonest upcoming reset, as returned by /quota/next-reset
+type NextReset struct {
+	Model             string `json:"model"`
+	ResetTime         string `json:"reset_time"`
+	ResetTimeRelative string `json:"reset_time_relative"`
+}
+
+// nextReset returns the soonest reset after now, or false when no model has one
+func nextReset(models []FormattedModel, now time.Time) (NextReset, bool) {
+	model, resetAt, ok := findNextReset(models, now)
+	if !ok {
+		return NextReset{}, false
+	}
+	return NextReset{
+		Model:             model.Name,
+		ResetTime:         resetAt.UTC().Format(time.RFC3339),
+		ResetTimeRelative: formatDurationRemaining(resetAt.Sub(now)),
+	}, true
+}
+
+// QuotaSummary combines the overview, worst model, and soonest reset with the
+// full quota, for widgets that want everything from one request
+type QuotaSummary struct {
+	Overview string `json:"overview"`
+
+	// Nil when there are no models, or no upcoming reset
+	Worst     *WorstModel `json:"worst"`
+	NextReset *NextReset  `json:"next_reset"`
+
+	Quota *FormattedQuota `json:"quota"`
+}
+
+// GetQuotaSummary returns the overview, worst model, next reset, and all models,
+// computed from a single fetch
+func (s *QuotaService) GetQuotaSummary(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	quota := s.formatDisplayQuota(quotaRaw)
+	summary := QuotaSummary{
+		Overview: buildOverview(formatQuota(quotaRaw, false, s.quotaFormat()), s.displayOptions()),
+		Quota:    quota,
+	}
+	if worst, ok := worstModel(quota.Models); ok {
+		summary.Worst = &worst
+	}
+	if next, ok := nextReset(quota.Models, now()); ok {
+		summary.NextReset = &next
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// findNextReset returns the model with the earliest reset after now, skipping
+// models whose reset is unknown or already past
+func findNextReset(models []FormattedModel, now time.Time) (FormattedModel, time.Time, bool) {
+	var next FormattedModel
+	var nextAt time.Time
+	found := false
+	for _, model := range models {
+		resetAt, ok := parseResetTime(model.ResetTime)
+		if !ok || !resetAt.After(now) {
+			continue
+		}
+		if !found || resetAt.Before(nextAt) {
+			next, nextAt, found = model, resetAt, true
+		}
+	}
+	return next, nextAt, found
+}
+
+// GetModelPercentage returns the percentage of a single model as plain text
+func (s *QuotaService) GetModelPercentage(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		c.String(quotaErrorStatus(err), "error: %s", err.Error())
+		return
+	}
+
+	model, err := matchModel(formatQuota(quotaRaw, false, s.quotaFormat()).Models, c.Param("name"))
+	if err != nil {
+		c.String(quotaErrorStatus(err), "error: %s", err.Error())
+		return
+	}
+
+	c.String(http.StatusOK, "%d", model.Percentage)
+}
+
+// matchModel finds the one model whose name contains pattern, case-insensitively.
+// An exact name match wins over other substring matches.
+func matchModel(models []FormattedModel, pattern string) (FormattedModel, error) {
+	pattern = strings.ToLower(pattern)
+
+	var matches []FormattedModel
+	for _, model := range models {
+		name := strings.ToLower(model.Name)
+		if name == pattern {
+			return model, nil
+		}
+		if strings.Contains(name, pattern) {
+			matches = append(matches, model)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return FormattedModel{}, fmt.Errorf("%w %q", errModelNotFound, pattern)
+	case 1:
+		return matches[0], nil
+	default:
+		names := make([]string, len(matches))
+		for i, model := range matches {
+			names[i] = model.Name
+		}
+		return FormattedModel{}, fmt.Errorf("%w: %q matches %s", errAmbiguousModel, pattern, strings.Join(names, ", "))
+	}
+}
+
+// findWorstModel returns the model with the lowest percentage, breaking ties by soonest reset
+func findWorstModel(models []FormattedModel) (FormattedModel, bool) {
+	if len(models) == 0 {
+		return FormattedModel{}, false
+	}
+
+	worst := models[0]
+	for _, model := range models[1:] {
+		if model.Percentage < worst.Percentage ||
+			(model.Percentage == worst.Percentage && resetsBefore(model.ResetTime, worst.ResetTime)) {
+			worst = model
+		}
+	}
+
+	return worst, true
+}
+
+// resetsBefore reports whether reset time a is earlier than b; unparseable times sort last
+func resetsBefore(a, b string) bool {
+	aTime, aOK := parseResetTime(a)
+	bTime, bOK := parseResetTime(b)
+	if !aOK {
+		return false
+	}
+	if !bOK {
+		return true
+	}
+	return aTime.Before(bTime)
+}
+
+// WaybarOutput is the JSON shape expected by Waybar custom modules
+type WaybarOutput struct {
+	Text       string `json:"text"`
+	Tooltip    string `json:"tooltip"`
+	Class      string `json:"class"`
+	Percentage int    `json:"percentage"`
+}
+
+// GetQuotaScore returns the weighted average percentage of all models as a single health score
+func (s *QuotaService) GetQuotaScore(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	config := s.client.config
+	score, ok := weightedScore(s.formatDisplayQuota(quotaRaw).Models, config.Weights, config.ExcludeUnweighted)
+	if !ok {
+		respondError(c, newAppError(http.StatusNotFound, CodeNotFound, "no models with a non-zero weight"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"score": score})
+}
+
+// modelWeight returns the weight of the longest pattern contained in the model name,
+// or fallback when none matches
+func modelWeight(name string, weights map[string]float64, fallback float64) float64 {
+	nameLower := strings.ToLower(name)
+	match := ""
+	for pattern := range weights {
+		if strings.Contains(nameLower, pattern) && len(pattern) > len(match) {
+			match = pattern
+		}
+	}
+	if match == "" {
+		return fallback
+	}
+	return weights[match]
+}
+
+// alertThreshold returns the threshold of the longest pattern contained in the model
+// name, or fallback when none matches
+func alertThreshold(name string, thresholds map[string]int, fallback int) int {
+	nameLower := strings.ToLower(name)
+	match := ""
+	for pattern := range thresholds {
+		if strings.Contains(nameLower, pattern) && len(pattern) > len(match) {
+			match = pattern
+		}
+	}
+	if match == "" {
+		return fallback
+	}
+	return thresholds[match]
+}
+
+// weightedScore returns the rounded weighted average percentage of the models.
+// Unmatched models weigh 1.0 unless excludeUnweighted is set; it reports false
+// when the total weight is zero.
+func weightedScore(models []FormattedModel, weights map[string]float64, excludeUnweighted bool) (int, bool) {
+	fallback := 1.0
+	if excludeUnweighted {
+		fallback = 0
+	}
+
+	var sum, total float64
+	for _, model := range models {
+		weight := modelWeight(model.Name, weights, fallback)
+		sum += weight * float64(model.Percentage)
+		total += weight
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return int(math.Round(sum / total)), true
+}
+
+// GetWaybarQuota returns quota as a Waybar custom module object
+func (s *QuotaService) GetWaybarQuota(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, buildWaybar(s.formatDisplayQuota(quotaRaw), s.displayOptions()))
+}
+
+// buildWaybar maps formatted quota to Waybar's text, tooltip, class, and percentage
+func buildWaybar(quota *FormattedQuota, opts DisplayOptions) WaybarOutput {
+	var lines []string
+	for _, model := range quota.Models {
+		line := fmt.Sprintf("%s: %d%%", model.Name, model.Percentage)
+		if model.ResetTimeRelative != "" {
+			line += fmt.Sprintf(" (reset %s)", model.ResetTimeRelative)
+		}
+		lines = append(lines, line)
+	}
+
+	output := WaybarOutput{
+		Text:    buildOverview(quota, opts),
+		Tooltip: strings.Join(lines, "\n"),
+		Class:   classifyPercentage(0, opts.thresholds()),
+	}
+	if worst, ok := findWorstModel(quota.Models); ok {
+		output.Class = classifyPercentage(worst.Percentage, opts.thresholds())
+		output.Percentage = worst.Percentage
+	}
+	return output
+}
+
+// classifyPercentage maps a percentage to a good, warning, or critical class
+func classifyPercentage(pct int, thresholds QuotaThresholds) string {
+	if pct >= thresholds.Good {
+		return "good"
+	} else if pct >= thresholds.Warning {
+		return "warning"
+	}
+	return "critical"
+}
+
+// ModelComparison is a model's current quota alongside the previous fetch
+type ModelComparison struct {
+	Name               string  `json:"name"`
+	Percentage         int     `json:"percentage"`
+	PreviousPercentage *int    `json:"previous_percentage"`
+	Delta              *int    `json:"delta"`
+	TimeToZero         *string `json:"time_to_zero"`
+	TimeToZeroSeconds  *int64  `json:"time_to_zero_seconds"`
+
+	// Smoothed burn rate in percent per hour and the time to zero it implies
+	BurnRateEMA                 *float64 `json:"burn_rate_ema"`
+	EstimatedResetToZero        *string  `json:"estimated_reset_to_zero"`
+	EstimatedResetToZeroSeconds *int64   `json:"estimated_reset_to_zero_seconds"`
+}
+
+// GetQuotaCompare returns each model's change since the previous fetch
+func (s *QuotaService) GetQuotaCompare(c *gin.Context) {
+	// History is only kept for the account's own project
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), "", requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	current := formatQuota(quotaRaw, false, s.quotaFormat())
+	latest, previous := s.client.QuotaHistory()
+
+	var previousQuota *FormattedQuota
+	var elapsed time.Duration
+	var previousUpdated *int64
+	if latest != nil && previous != nil {
+		previousQuota = formatQuota(previous.Quota, false, s.quotaFormat())
+		elapsed = latest.FetchedAt.Sub(previous.FetchedAt)
+		fetchedAt := previous.FetchedAt.Unix()
+		previousUpdated = &fetchedAt
+	}
+
+	comparisons := buildComparison(current, previousQuota, elapsed)
+	applyBurnRates(comparisons, s.client.BurnRates())
+
+	c.JSON(http.StatusOK, gin.H{
+		"models":           comparisons,
+		"last_updated":     current.LastUpdated,
+		"previous_updated": previousUpdated,
+	})
+}
+
+// GrafanaPoint is one model sample in the flat row shape Grafana's JSON and
+// Infinity data sources read, with time in Unix milliseconds
+type GrafanaPoint struct {
+	Time       int64  `json:"time"`
+	Model      string `json:"model"`
+	Percentage int    `json:"percentage"`
+}
+
+// GetQuotaGrafana returns the latest quota as Grafana time-series rows, preceded by
+// the previous fetch's rows when one is kept for the account's own project
+func (s *QuotaService) GetQuotaGrafana(c *gin.Context) {
+	project := c.Query("project")
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), project, requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	points := []GrafanaPoint{}
+	fetchedAt := now()
+	if project == "" {
+		if latest, previous := s.client.QuotaHistory(); latest != nil {
+			fetchedAt = latest.FetchedAt
+			if previous != nil {
+				points = appendGrafanaPoints(points, formatQuota(previous.Quota, false, s.quotaFormat()), previous.FetchedAt)
+			}
+		}
+	}
+	points = appendGrafanaPoints(points, formatQuota(quotaRaw, false, s.quotaFormat()), fetchedAt)
+
+	c.JSON(http.StatusOK, points)
+}
+
+// appendGrafanaPoints adds one row per model, all stamped with fetchedAt
+func appendGrafanaPoints(points []GrafanaPoint, quota *FormattedQuota, fetchedAt time.Time) []GrafanaPoint {
+	for _, model := range quota.Models {
+		points = append(points, GrafanaPoint{
+			Time:       fetchedAt.UnixMilli(),
+			Model:      model.Name,
+			Percentage: model.Percentage,
+		})
+	}
+	return points
+}
+
+// buildComparison pairs current models with the previous sample, estimating time
+// to zero from the burn rate over elapsed. A nil previous leaves deltas null.
+func buildComparison(current, previous *FormattedQuota, elapsed time.Duration) []ModelComparison {
+	previousByName := make(map[string]int)
+	if previous != nil {
+		for _, model := range previous.Models {
+			previousByName[model.Name] = model.Percentage
+		}
+	}
+
+	comparisons := make([]ModelComparison, 0, len(current.Models))
+	for _, model := range current.Models {
+		comparison := ModelComparison{Name: model.Name, Percentage: model.Percentage}
+
+		if prevPct, ok := previousByName[model.Name]; ok {
+			delta := model.Percentage - prevPct
+			comparison.PreviousPercentage = &prevPct
+			comparison.Delta = &delta
+
+			if delta < 0 && elapsed > 0 {
+				remaining := time.Duration(float64(elapsed) * float64(model.Percentage) / float64(-delta))
+				seconds := int64(remaining.Seconds())
+				formatted := formatDurationRemaining(remaining)
+				comparison.TimeToZeroSeconds = &seconds
+				comparison.TimeToZero = &formatted
+			}
+		}
+
+		comparisons = append(comparisons, comparison)
+	}
+
+	return comparisons
+}
+
+// applyBurnRates sets each comparison's smoothed burn rate and, while the model
+// is burning, the estimated time to zero at that rate
+func applyBurnRates(comparisons []ModelComparison, rates map[string]float64) {
+	for i := range comparisons {
+		rate, ok := rates[comparisons[i].Name]
+		if !ok {
+			continue
+		}
+		comparisons[i].BurnRateEMA = &rate
+
+		if rate > 0 {
+			remaining := time.Duration(float64(comparisons[i].Percentage) / rate * float64(time.Hour))
+			seconds := int64(remaining.Seconds())
+			formatted := formatDurationRemaining(remaining)
+			comparisons[i].EstimatedResetToZeroSeconds = &seconds
+			comparisons[i].EstimatedResetToZero = &formatted
+		}
+	}
+}
+
+// GetGemini3Pro returns Gemini 3 Pro models
+func (s *QuotaService) GetGemini3Pro(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	quotaFormatted := s.formatDisplayQuota(quotaRaw)
+	filtered := filterModels(quotaFormatted, geminiProTiers)
+	c.JSON(http.StatusOK, gin.H{"quota": filtered})
+}
+
+// GetGemini3Flash returns Gemini 3 Flash model
+func (s *QuotaService) GetGemini3Flash(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	quotaFormatted := s.formatDisplayQuota(quotaRaw)
+	filtered := filterModels(quotaFormatted, []string{"gemini-3-flash"})
+	c.JSON(http.StatusOK, gin.H{"quota": filtered})
+}
+
+// GetClaude45 returns Claude 4.5 models
+func (s *QuotaService) GetClaude45(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	quotaFormatted := s.formatDisplayQuota(quotaRaw)
+	filtered := filterModels(quotaFormatted, []string{"claude-opus-4-5-thinking", "claude-sonnet-4-5", "claude-sonnet-4-5-thinking"})
 	c.JSON(http.StatusOK, gin.H{"quota": filtered})
 }
+
+// GetGLMQuota returns GLM (Z.ai/ZHIPU) quota usage and limits
+func (s *QuotaService) GetGLMQuota(c *gin.Context) {
+	quotaFormatted, err := GetGLMQuota(c.Request.Context())
+	if err != nil {
+		respondError(c, newAppError(http.StatusBadRequest, CodeBadRequest, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"quota": quotaFormatted})
+}
+
+// GetQuotaStatusZAI returns terminal-friendly GLM quota status
+func (s *QuotaService) GetQuotaStatusZAI(c *gin.Context) {
+	quotaFormatted, err := GetGLMQuota(c.Request.Context())
+	if err != nil {
+		respondError(c, newAppError(http.StatusBadRequest, CodeBadRequest, err.Error()))
+		return
+	}
+
+	// Get GLM token quota
+	glmPct := 0
+	for _, model := range quotaFormatted.Models {
+		if model.Name == "glm" {
+			glmPct = model.Percentage
+			break
+		}
+	}
+
+	const (
+		Green = "\033[32m"
+		Red = "\033[31m"
+		Reset = "\033[0m"
+		ZAIIcon = "Z"
+	)
+
+	var status string
+	if glmPct == QuotaFull {
+		status = Green + ZAIIcon + Reset
+	} else if glmPct == 0 {
+		status = Red + ZAIIcon + Reset
+	} else {
+		pctStr := formatPercentageWithColor(glmPct, s.client.config.Thresholds)
+		status = fmt.Sprintf("%s %s", ZAIIcon, pctStr)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"overview": status})
+}
@@ -2,12 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"errors"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -186,7 +197,7 @@ func TestQuotaServiceWithMockServer(t *testing.T) {
 	}
 	
 	// Test formatting
-	formatted := formatQuota(quotaResp, true)
+	formatted := formatQuota(quotaResp, true, defaultQuotaFormat)
 	if len(formatted.Models) != 3 {
 		t.Errorf("Expected 3 formatted models, got %d", len(formatted.Models))
 	}
@@ -219,7 +230,7 @@ func TestFormatTimeCompact(t *testing.T) {
 	
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := formatTimeCompact(tt.input)
+			result := formatTimeCompact(tt.input, true)
 			if tt.name == "empty" || tt.name == "invalid" {
 				if result != tt.expected {
 					t.Errorf("Expected %s, got %s", tt.expected, result)
@@ -244,10 +255,3172 @@ func TestFormatPercentageWithColor(t *testing.T) {
 	
 	for _, tt := range tests {
 		t.Run("", func(t *testing.T) {
-			result := formatPercentageWithColor(tt.percentage)
+			result := formatPercentageWithColor(tt.percentage, DefaultQuotaThresholds)
 			if !bytes.Contains([]byte(result), []byte(tt.contains)) {
 				t.Errorf("Expected result to contain %s, got %s", tt.contains, result)
 			}
 		})
 	}
 }
+
+func TestFormatPercentageWithCustomThresholds(t *testing.T) {
+	thresholds := QuotaThresholds{Good: 80, Warning: 40, Critical: 10}
+	tests := []struct {
+		percentage int
+		color      string
+		class      string
+	}{
+		{90, ANSITheme.Green, "good"},
+		{75, ANSITheme.Yellow, "warning"},
+		{30, ANSITheme.Red, "critical"},
+	}
+
+	for _, tt := range tests {
+		result := formatPercentageWithColor(tt.percentage, thresholds)
+		if !strings.HasPrefix(result, tt.color) {
+			t.Errorf("%d%%: expected color %q, got %q", tt.percentage, tt.color, result)
+		}
+		if class := classifyPercentage(tt.percentage, thresholds); class != tt.class {
+			t.Errorf("%d%%: expected class %s, got %s", tt.percentage, tt.class, class)
+		}
+	}
+}
+
+func TestGetHealthz(t *testing.T) {
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	if response["status"] != "ok" {
+		t.Errorf("Expected status ok, got %v", response["status"])
+	}
+}
+
+func TestGetHealthzRefresher(t *testing.T) {
+	service := NewQuotaService(NewCloudCodeClient(&Config{}))
+	router := gin.New()
+	router.GET("/healthz", service.GetHealthz)
+
+	get := func() map[string]any {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/healthz", nil)
+		router.ServeHTTP(w, req)
+
+		var response struct {
+			Refresher map[string]any `json:"refresher"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		return response.Refresher
+	}
+
+	if refresher := get(); refresher != nil {
+		t.Errorf("Expected no refresher before it is started, got %v", refresher)
+	}
+
+	at := time.Date(2025, 12, 26, 9, 0, 0, 0, time.UTC)
+	ctx, cancel := context.WithCancel(context.Background())
+	superviseRefresher(ctx, newFakeClock(at), time.Millisecond, time.Millisecond, func() error {
+		cancel()
+		return nil
+	}, &service.refresher)
+
+	if refresher := get(); refresher["last_success"] != "2025-12-26T09:00:00Z" || refresher["restarts"] != float64(0) {
+		t.Errorf("Expected last_success of the refresh, got %v", refresher)
+	}
+}
+
+func TestGetVersion(t *testing.T) {
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/version", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	expected := map[string]string{"version": "dev", "commit": "unknown", "build_time": "unknown"}
+	for key, value := range expected {
+		if response[key] != value {
+			t.Errorf("Expected %s %q, got %q", key, value, response[key])
+		}
+	}
+}
+
+func TestGetReadyz(t *testing.T) {
+	tmpDir := t.TempDir()
+	accountFile := filepath.Join(tmpDir, "fresh-account.json")
+
+	expiry := time.Now().Unix() + 3600
+	account := Account{
+		Token: &TokenData{
+			AccessToken:     "test-access-token",
+			RefreshToken:    "test-refresh-token",
+			ExpiryTimestamp: &expiry,
+		},
+	}
+	data, _ := json.MarshalIndent(account, "", "  ")
+	if err := os.WriteFile(accountFile, data, 0600); err != nil {
+		t.Fatalf("Failed to create test account file: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		accountFile string
+		status      int
+	}{
+		{"ready", accountFile, http.StatusOK},
+		{"missing account", filepath.Join(tmpDir, "missing.json"), http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewQuotaService(NewCloudCodeClient(&Config{AccountFile: tt.accountFile}))
+			router := gin.New()
+			router.GET("/readyz", service.GetReadyz)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/readyz", nil)
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestUpstreamForbiddenPropagated(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":{"code":403,"message":"forbidden"}}`))
+	}))
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		UserAgent:     "test-agent",
+		QueryDebounce: 1,
+	}
+	client := NewCloudCodeClient(config)
+
+	_, err := client.GetQuota("test-access-token", "test-project-id")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected *APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", apiErr.StatusCode)
+	}
+
+	router := gin.New()
+	router.GET("/quota/all", func(c *gin.Context) {
+		respondError(c, err)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/all", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+
+	var response struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+		Quota FormattedQuota `json:"quota"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if !response.Quota.IsForbidden {
+		t.Errorf("Expected is_forbidden to be true")
+	}
+	if response.Error.Code != CodeForbidden {
+		t.Errorf("Expected code %q, got %q", CodeForbidden, response.Error.Code)
+	}
+}
+
+func TestForbiddenOverviewAndStatus(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "new-access-token", ExpiresIn: 3600})
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":{"code":403,"status":"PERMISSION_DENIED"}}`))
+	}))
+	defer mockServer.Close()
+
+	service := NewQuotaService(NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL,
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}))
+	router := gin.New()
+	router.GET("/quota/overview", service.GetQuotaOverview)
+	router.GET("/quota/status.txt", service.GetQuotaStatusText)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/overview?project=blocked-project", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403, got %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Overview string         `json:"overview"`
+		Quota    FormattedQuota `json:"quota"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response.Overview != ForbiddenIndicator {
+		t.Errorf("Expected overview %q, got %q", ForbiddenIndicator, response.Overview)
+	}
+	if !response.Quota.IsForbidden || response.Quota.Models == nil {
+		t.Errorf("Expected an empty forbidden quota, got %+v", response.Quota)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/quota/status.txt?project=blocked-project", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+	if want := ANSITheme.Red + ForbiddenIndicator + ANSITheme.Reset; w.Body.String() != want {
+		t.Errorf("Expected status text %q, got %q", want, w.Body.String())
+	}
+}
+
+func TestForbiddenMarkers(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"models":{},"error":{"reason":"ACCOUNT_SUSPENDED"}}`))
+	}))
+	defer mockServer.Close()
+
+	tests := []struct {
+		name      string
+		markers   []string
+		forbidden bool
+	}{
+		{"matching marker", []string{"account_suspended"}, true},
+		{"default markers", DefaultForbiddenMarkers, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewCloudCodeClient(&Config{
+				APIURL:           mockServer.URL,
+				QueryDebounce:    1,
+				ForbiddenMarkers: tt.markers,
+			})
+			_, err := client.GetQuota("test-access-token", "test-project-id")
+			if got := isForbidden(err); got != tt.forbidden {
+				t.Errorf("Expected forbidden=%v, got err %v", tt.forbidden, err)
+			}
+		})
+	}
+}
+
+func TestForbiddenMarkerOnServerError(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":{"code":503,"message":"backend PERMISSION_DENIED check unavailable"}}`))
+	}))
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:           mockServer.URL,
+		QueryDebounce:    1,
+		ForbiddenMarkers: DefaultForbiddenMarkers,
+	})
+	_, err := client.GetQuota("test-access-token", "test-project-id")
+	if status := quotaErrorStatus(err); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected the 503 to keep its status despite the marker, got %d (%v)", status, err)
+	}
+	if !isTransientError(err) {
+		t.Errorf("Expected the 503 to stay transient so stale quota can be served")
+	}
+}
+
+func TestQuotaErrorStatus(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{&APIError{StatusCode: http.StatusTooManyRequests}, http.StatusTooManyRequests},
+		{&APIError{StatusCode: http.StatusServiceUnavailable}, http.StatusServiceUnavailable},
+		{errors.New("account file not found"), http.StatusInternalServerError},
+		{&TokenRefreshError{StatusCode: http.StatusBadRequest, Code: "invalid_grant"}, http.StatusUnauthorized},
+		{&TokenRefreshError{StatusCode: http.StatusBadRequest, Code: "invalid_client"}, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if status := quotaErrorStatus(tt.err); status != tt.expected {
+			t.Errorf("Expected status %d for %v, got %d", tt.expected, tt.err, status)
+		}
+	}
+}
+
+func TestInvalidGrantRefresh(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_grant", "error_description": "Token has been expired or revoked."}`))
+			return
+		}
+		t.Errorf("Unexpected upstream request to %s", r.URL.Path)
+	}))
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}
+	client := NewCloudCodeClient(config)
+
+	_, err := client.RefreshAccessToken("revoked-refresh-token")
+	var refreshErr *TokenRefreshError
+	if !errors.As(err, &refreshErr) || !refreshErr.InvalidGrant() {
+		t.Fatalf("Expected an invalid_grant TokenRefreshError, got %v", err)
+	}
+	if refreshErr.Description != "Token has been expired or revoked." {
+		t.Errorf("Expected the error description to be kept, got %q", refreshErr.Description)
+	}
+
+	router := gin.New()
+	router.GET("/quota/all", NewQuotaService(client).GetAllQuota)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/all", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401, got %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response.Error.Code != CodeReauthRequired || !strings.Contains(response.Error.Message, "re-authenticate") {
+		t.Errorf("Expected a re-authenticate error, got %+v", response.Error)
+	}
+}
+
+func TestBuildOverview(t *testing.T) {
+	tests := []struct {
+		name     string
+		models   []FormattedModel
+		expected string
+	}{
+		{
+			"full",
+			[]FormattedModel{
+				{Name: "gemini-3-pro-high", Percentage: 100},
+				{Name: "gemini-3-flash", Percentage: 100},
+				{Name: "claude-sonnet-4-5", Percentage: 100},
+			},
+			"Pro 100% | Flash 100% | Claude 100%",
+		},
+		{
+			"zero",
+			[]FormattedModel{
+				{Name: "gemini-3-pro-high", Percentage: 0},
+				{Name: "gemini-3-flash", Percentage: 0},
+				{Name: "claude-sonnet-4-5", Percentage: 0},
+			},
+			"Pro 0% | Flash 0% | Claude 0%",
+		},
+		{
+			"missing models",
+			[]FormattedModel{{Name: "gemini-3-flash", Percentage: 42}},
+			"Pro 0% | Flash 42% | Claude 0%",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := buildOverview(&FormattedQuota{Models: tt.models}, DisplayOptions{})
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestTrackedModels(t *testing.T) {
+	models := []FormattedModel{
+		{Name: "gemini-2.5-pro", Percentage: 80},
+		{Name: "gemini-2.5-flash", Percentage: 60},
+		{Name: "gemini-3-pro-high", Percentage: 40},
+		{Name: "claude-opus-4-5-thinking", Percentage: 20},
+	}
+	quota := &FormattedQuota{Models: models}
+
+	// Two slots, one with a configured label
+	opts := DisplayOptions{
+		TrackedModels: []string{"gemini-2.5-pro", "claude-opus"},
+		Labels:        map[string]ModelLabel{"claude-opus": {Label: "Opus", Icon: "O"}},
+	}
+	if got := buildOverview(quota, opts); got != "gemini-2.5-pro 80% | Opus 20%" {
+		t.Errorf("Unexpected two-model overview: %q", got)
+	}
+	if got := buildStatus(quota, ColorTheme{}, opts); got != "gemini-2.5-pro 80% | O 20%" {
+		t.Errorf("Unexpected two-model status: %q", got)
+	}
+
+	// Four slots in configured order, including a built-in and a missing model
+	opts = DisplayOptions{TrackedModels: []string{"gemini-3-pro-high", "gemini-2.5-flash", "gemini-2.5-pro", "missing"}}
+	if got := buildOverview(quota, opts); got != "Pro 40% | gemini-2.5-flash 60% | gemini-2.5-pro 80% | missing 0%" {
+		t.Errorf("Unexpected four-model overview: %q", got)
+	}
+	if got := len(overviewSlots(quota, opts)); got != 4 {
+		t.Errorf("Expected 4 slots, got %d", got)
+	}
+}
+
+func TestLocalizeResetTimes(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("tzdata unavailable: %v", err)
+	}
+
+	models := []FormattedModel{
+		{Name: "a", ResetTime: "2025-11-20T16:00:00Z"},
+		{Name: "b", ResetTime: ""},
+	}
+	localizeResetTimes(models, nil)
+	if models[0].ResetTimeLocal != "" {
+		t.Errorf("Expected no local time without a location, got %q", models[0].ResetTimeLocal)
+	}
+
+	localizeResetTimes(models, newYork)
+	if got := models[0].ResetTimeLocal; got != "2025-11-20T11:00:00-05:00" {
+		t.Errorf("Unexpected local reset time: %q", got)
+	}
+	if got := models[1].ResetTimeLocal; got != "" {
+		t.Errorf("Expected empty local time for unknown reset, got %q", got)
+	}
+
+	// An invalid zone falls back to UTC
+	localizeResetTimes(models, loadLocation("Invalid/Zone"))
+	if got := models[0].ResetTimeLocal; got != "2025-11-20T16:00:00Z" {
+		t.Errorf("Expected UTC local reset time, got %q", got)
+	}
+}
+
+func TestDecimalPercent(t *testing.T) {
+	quotaRaw := &QuotaResponse{Models: map[string]ModelInfo{
+		"gemini-3-pro-high": {QuotaInfo: QuotaInfo{RemainingFraction: 0.949}},
+	}}
+
+	truncated := NewQuotaService(NewCloudCodeClient(&Config{})).formatDisplayQuota(quotaRaw)
+	if got := truncated.Models[0].Percentage; got != 94 {
+		t.Errorf("Expected 94.9%% truncated to 94 by default, got %d", got)
+	}
+	if truncated.Models[0].PercentagePrecise != nil {
+		t.Errorf("Expected no percentage_precise by default")
+	}
+
+	rounded := NewQuotaService(NewCloudCodeClient(&Config{DecimalPercent: true})).formatDisplayQuota(quotaRaw)
+	if got := rounded.Models[0].Percentage; got != 95 {
+		t.Errorf("Expected 94.9%% rounded to 95 with DECIMAL_PERCENT, got %d", got)
+	}
+	if got := rounded.Models[0].PercentagePrecise; got == nil || *got != 94.9 {
+		t.Errorf("Expected percentage_precise 94.9, got %v", got)
+	}
+}
+
+func TestRoundPercentage(t *testing.T) {
+	tests := []struct {
+		fraction float64
+		decimals int
+		expected float64
+	}{
+		{0.949, 1, 94.9},
+		{0.949, 0, 95},
+		{0.945, 1, 94.5},
+		{0.9445, 1, 94.5},
+		{0.9444, 1, 94.4},
+		{0.995, 0, 100},
+		{0.0049, 0, 0},
+		{1, 1, 100},
+	}
+
+	for _, tt := range tests {
+		if got := roundPercentage(tt.fraction, tt.decimals); got != tt.expected {
+			t.Errorf("roundPercentage(%v, %d) = %v, expected %v", tt.fraction, tt.decimals, got, tt.expected)
+		}
+	}
+}
+
+func TestOverviewShowReset(t *testing.T) {
+	setNow(t, time.Date(2025, 12, 26, 10, 0, 0, 0, time.UTC))
+	quota := &FormattedQuota{Models: []FormattedModel{
+		{Name: "gemini-3-pro-high", Percentage: 95, ResetTime: "2025-12-26T13:00:00Z"},
+		{Name: "gemini-3-flash", Percentage: 90, ResetTime: "2025-12-26T11:20:00Z"},
+		{Name: "claude-sonnet-4-5", Percentage: 80, ResetTime: "2025-12-26T09:00:00Z"},
+		// Not a tracked model, so its sooner reset is ignored
+		{Name: "gemini-2.5-pro", Percentage: 50, ResetTime: "2025-12-26T10:05:00Z"},
+	}}
+
+	if got := buildOverview(quota, DisplayOptions{}); got != "Pro 95% | Flash 90% | Claude 80%" {
+		t.Errorf("Expected no reset suffix by default, got %q", got)
+	}
+	// Claude's reset has passed, so Flash is the soonest upcoming one
+	if got := buildOverview(quota, DisplayOptions{ShowNextReset: true}); got != "Pro 95% | Flash 90% | Claude 80% (next reset 1h 20m)" {
+		t.Errorf("Unexpected overview with reset: %q", got)
+	}
+
+	noResets := &FormattedQuota{Models: []FormattedModel{{Name: "gemini-3-flash", Percentage: 90}}}
+	if got := buildOverview(noResets, DisplayOptions{ShowNextReset: true}); strings.Contains(got, "next reset") {
+		t.Errorf("Expected no suffix without reset times, got %q", got)
+	}
+}
+
+func TestProAverage(t *testing.T) {
+	models := []FormattedModel{
+		{Name: "gemini-3-pro-high", Percentage: 90},
+		{Name: "gemini-3-pro-image", Percentage: 40},
+		{Name: "gemini-3-pro-low", Percentage: 21},
+		{Name: "gemini-3-flash", Percentage: 100},
+		{Name: "claude-sonnet-4-5", Percentage: 100},
+	}
+	quota := &FormattedQuota{Models: models}
+
+	if got := buildOverview(quota, DisplayOptions{}); got != "Pro 90% | Flash 100% | Claude 100%" {
+		t.Errorf("Unexpected high-only overview: %q", got)
+	}
+	if got := buildOverview(quota, DisplayOptions{ProAverage: true}); got != "Pro 50% | Flash 100% | Claude 100%" {
+		t.Errorf("Unexpected average overview: %q", got)
+	}
+	if got := buildStatus(quota, ColorTheme{}, DisplayOptions{ProAverage: true}); got != "G 50% | F | 󰛄" {
+		t.Errorf("Unexpected average status: %q", got)
+	}
+
+	// Absent tiers are skipped
+	tests := []struct {
+		name     string
+		models   []FormattedModel
+		expected int
+		ok       bool
+	}{
+		{"all tiers", models, 50, true},
+		{"high missing", models[1:], 31, true},
+		{"single tier", models[2:3], 21, true},
+		{"no tiers", models[3:], 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := averagePercentage(tt.models, geminiProTiers)
+			if got != tt.expected || ok != tt.ok {
+				t.Errorf("Expected (%d, %v), got (%d, %v)", tt.expected, tt.ok, got, ok)
+			}
+		})
+	}
+
+	if got := buildOverview(&FormattedQuota{Models: models[1:]}, DisplayOptions{ProAverage: true}); got != "Pro 31% | Flash 100% | Claude 100%" {
+		t.Errorf("Unexpected overview without pro-high: %q", got)
+	}
+}
+
+func TestApplyAlerts(t *testing.T) {
+	quota := &FormattedQuota{Models: []FormattedModel{
+		{Name: "claude-sonnet-4-5", Percentage: 4},
+		{Name: "gemini-3-flash", Percentage: 5},
+		{Name: "gemini-3-pro-high", Percentage: 80},
+	}}
+
+	// All models at or above the threshold
+	applyAlerts(quota, 4, nil)
+	if quota.Alert || len(quota.Alerts) != 0 {
+		t.Errorf("Expected no alerts at threshold 4, got %v", quota.Alerts)
+	}
+
+	// One model below the threshold
+	applyAlerts(quota, 5, nil)
+	if !quota.Alert || !reflect.DeepEqual(quota.Alerts, []string{"claude-sonnet-4-5"}) {
+		t.Errorf("Expected alert for claude-sonnet-4-5, got %v %v", quota.Alert, quota.Alerts)
+	}
+
+	// Filtering keeps only the alerts for the remaining models
+	if filtered := filterModels(quota, []string{"gemini"}); filtered.Alert || len(filtered.Alerts) != 0 {
+		t.Errorf("Expected no alerts after filtering out claude, got %v", filtered.Alerts)
+	}
+	if filtered := filterModels(quota, []string{"claude"}); !filtered.Alert || len(filtered.Alerts) != 1 {
+		t.Errorf("Expected claude alert to survive filtering, got %v", filtered.Alerts)
+	}
+
+	// The JSON field is an empty array rather than null when nothing alerts
+	applyAlerts(quota, 0, nil)
+	data, _ := json.Marshal(quota)
+	if !strings.Contains(string(data), `"alert":false,"alerts":[]`) {
+		t.Errorf("Unexpected alert JSON: %s", data)
+	}
+}
+
+func TestApplyAlertsPerModel(t *testing.T) {
+	quota := &FormattedQuota{Models: []FormattedModel{
+		{Name: "gemini-3-flash", Percentage: 10},
+		{Name: "gemini-3-pro-high", Percentage: 15},
+		{Name: "gemini-3-pro-low", Percentage: 15},
+	}}
+	thresholds := parseAlertThresholds(`{"Gemini-3-Pro": 20, "flash": 5, "gemini-3-pro-low": 10}`)
+
+	// Pro alerts at 15% below its 20% threshold while Flash at 10% stays above 5%;
+	// pro-low's longer pattern overrides the pro one
+	applyAlerts(quota, 1, thresholds)
+	if !reflect.DeepEqual(quota.Alerts, []string{"gemini-3-pro-high"}) {
+		t.Errorf("Expected only gemini-3-pro-high to alert, got %v", quota.Alerts)
+	}
+
+	// Flipped thresholds alert on Flash only
+	applyAlerts(quota, 1, map[string]int{"gemini-3-pro": 5, "flash": 20})
+	if !reflect.DeepEqual(quota.Alerts, []string{"gemini-3-flash"}) {
+		t.Errorf("Expected only gemini-3-flash to alert, got %v", quota.Alerts)
+	}
+
+	// Unmatched models fall back to the global threshold
+	applyAlerts(quota, 20, map[string]int{"flash": 5})
+	if !reflect.DeepEqual(quota.Alerts, []string{"gemini-3-pro-high", "gemini-3-pro-low"}) {
+		t.Errorf("Expected the pro models to alert at the global threshold, got %v", quota.Alerts)
+	}
+}
+
+func TestParseAlertThresholds(t *testing.T) {
+	if thresholds := parseAlertThresholds(`{"pro": `); thresholds != nil {
+		t.Errorf("Expected nil thresholds for malformed JSON, got %v", thresholds)
+	}
+	if thresholds := parseAlertThresholds(`{"pro": 120, "flash": -1, "claude": 10}`); !reflect.DeepEqual(thresholds, map[string]int{"claude": 10}) {
+		t.Errorf("Expected out-of-range entries dropped, got %v", thresholds)
+	}
+}
+
+func TestBuildStatus(t *testing.T) {
+	plain := ColorTheme{}
+
+	tests := []struct {
+		name     string
+		models   []FormattedModel
+		expected string
+	}{
+		{
+			"full",
+			[]FormattedModel{
+				{Name: "gemini-3-pro-high", Percentage: 100},
+				{Name: "gemini-3-flash", Percentage: 100},
+				{Name: "claude-sonnet-4-5", Percentage: 100},
+			},
+			"G | F | 󰛄",
+		},
+		{
+			"zero",
+			[]FormattedModel{
+				{Name: "gemini-3-pro-high", Percentage: 0},
+				{Name: "gemini-3-flash", Percentage: 0},
+				{Name: "claude-sonnet-4-5", Percentage: 0},
+			},
+			"G | F | 󰛄",
+		},
+		{
+			"partial",
+			[]FormattedModel{
+				{Name: "gemini-3-pro-high", Percentage: 75},
+				{Name: "gemini-3-flash", Percentage: 100},
+				{Name: "claude-sonnet-4-5", Percentage: 10},
+			},
+			"G 75% | F | 󰛄 10%",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := buildStatus(&FormattedQuota{Models: tt.models}, plain, DisplayOptions{ShowDays: true})
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	// Full and empty models are distinguished by color
+	full := buildStatus(&FormattedQuota{Models: []FormattedModel{{Name: "gemini-3-pro-high", Percentage: 100}}}, ANSITheme, DisplayOptions{ShowDays: true})
+	if !strings.HasPrefix(full, ANSITheme.Green+"G") {
+		t.Errorf("Expected full Pro to be green, got %q", full)
+	}
+	empty := buildStatus(&FormattedQuota{Models: []FormattedModel{{Name: "gemini-3-pro-high", Percentage: 0}}}, ANSITheme, DisplayOptions{ShowDays: true})
+	if !strings.HasPrefix(empty, ANSITheme.Red+"G") {
+		t.Errorf("Expected empty Pro to be red, got %q", empty)
+	}
+}
+
+func TestFindWorstModel(t *testing.T) {
+	models := []FormattedModel{
+		{Name: "gemini-3-pro-high", Percentage: 40, ResetTime: "2025-12-26T12:00:00Z"},
+		{Name: "gemini-3-flash", Percentage: 20, ResetTime: "2025-12-26T11:00:00Z"},
+		{Name: "claude-sonnet-4-5", Percentage: 20, ResetTime: "2025-12-26T10:00:00Z"},
+	}
+
+	worst, ok := findWorstModel(models)
+	if !ok {
+		t.Fatal("Expected a worst model")
+	}
+	if worst.Name != "claude-sonnet-4-5" {
+		t.Errorf("Expected claude-sonnet-4-5 (tie broken by soonest reset), got %s", worst.Name)
+	}
+
+	if _, ok := findWorstModel(nil); ok {
+		t.Errorf("Expected no worst model for empty list")
+	}
+}
+
+func TestGetWorstQuota(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}
+	service := NewQuotaService(NewCloudCodeClient(config))
+
+	router := gin.New()
+	router.GET("/quota/worst", service.GetWorstQuota)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/worst", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response struct {
+		Model   FormattedModel `json:"model"`
+		AllFull bool           `json:"all_full"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	if response.Model.Name != "claude-sonnet-4-5" || response.Model.Percentage != 80 {
+		t.Errorf("Expected claude-sonnet-4-5 at 80%%, got %s at %d%%", response.Model.Name, response.Model.Percentage)
+	}
+	if response.AllFull {
+		t.Errorf("Expected all_full to be false")
+	}
+}
+
+func TestPlainTextEndpoints(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}
+	service := NewQuotaService(NewCloudCodeClient(config))
+
+	router := gin.New()
+	router.GET("/quota/overview.txt", service.GetQuotaOverviewText)
+	router.GET("/quota/status.txt", service.GetQuotaStatusText)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/overview.txt", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("Expected text/plain content type, got %s", contentType)
+	}
+	if body := w.Body.String(); body != "Pro 95% | Flash 90% | Claude 80%" {
+		t.Errorf("Unexpected overview body: %q", body)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/quota/status.txt", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "\033[") {
+		t.Errorf("Expected ANSI codes in status body, got %q", body)
+	}
+}
+
+func TestCustomModelLabels(t *testing.T) {
+	labels := parseModelLabels(`{"Gemini-3-Pro": {"label": "P3", "icon": "P"}, "claude": {"icon": "C"}}`)
+	if len(labels) != 2 {
+		t.Fatalf("Expected 2 labels, got %d", len(labels))
+	}
+
+	quota := &FormattedQuota{
+		Models: []FormattedModel{
+			{Name: "gemini-3-pro-high", Percentage: 95},
+			{Name: "gemini-3-flash", Percentage: 90},
+			{Name: "claude-sonnet-4-5", Percentage: 80},
+		},
+	}
+	opts := DisplayOptions{Labels: labels}
+
+	if result := buildOverview(quota, opts); result != "P3 95% | Flash 90% | Claude 80%" {
+		t.Errorf("Unexpected overview with custom labels: %q", result)
+	}
+
+	if result := buildStatus(quota, ColorTheme{}, opts); result != "P 95% | F 90% | C 80%" {
+		t.Errorf("Unexpected status with custom labels: %q", result)
+	}
+}
+
+func TestParseModelLabelsMalformed(t *testing.T) {
+	if labels := parseModelLabels(`{"gemini": `); labels != nil {
+		t.Errorf("Expected nil labels for malformed JSON, got %v", labels)
+	}
+}
+
+func TestModelDisplayNames(t *testing.T) {
+	models := []FormattedModel{{Name: "gemini-3-pro-high"}, {Name: "chat_20706"}}
+	applyDisplayNames(models, nil)
+
+	if models[0].DisplayName != "Gemini 3 Pro (High)" {
+		t.Errorf("Expected built-in alias for gemini-3-pro-high, got %q", models[0].DisplayName)
+	}
+	if models[1].DisplayName != "chat_20706" {
+		t.Errorf("Expected a model without an alias to keep its name, got %q", models[1].DisplayName)
+	}
+
+	names := loadDisplayNames(`{"Gemini-3-Pro-High": "Flagship"}`)
+	if names["gemini-3-pro-high"] != "Flagship" || names["gemini-3-flash"] != "Gemini 3 Flash" {
+		t.Errorf("Expected the override merged over the built-in aliases, got %v", names)
+	}
+	if names := loadDisplayNames(`{"gemini": `); names["gemini-3-flash"] != "Gemini 3 Flash" {
+		t.Errorf("Expected built-in aliases for malformed JSON, got %v", names)
+	}
+}
+
+func TestModelDisplayNamesFilter(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		DisplayNames:  loadDisplayNames(`{"gemini-3-pro-high": "Flagship"}`),
+	}
+	service := NewQuotaService(NewCloudCodeClient(config))
+
+	router := gin.New()
+	router.GET("/quota/pro", service.GetGemini3Pro)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/pro", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var body struct {
+		Quota FormattedQuota `json:"quota"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	models := body.Quota.Models
+	if len(models) != 1 || models[0].Name != "gemini-3-pro-high" || models[0].DisplayName != "Flagship" {
+		t.Errorf("Expected the filter to match the raw name and keep the alias, got %+v", models)
+	}
+}
+
+func TestGetRawQuota(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(QuotaResponse{
+			Models: map[string]ModelInfo{
+				"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.9}},
+				"chat_20706":     {QuotaInfo: QuotaInfo{RemainingFraction: 0.5}},
+			},
+		})
+	}))
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL,
+		ProjectAPIURL: mockServer.URL,
+		TokenURL:      mockServer.URL,
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}
+	client := NewCloudCodeClient(config)
+	client.GetQuota("test-access-token", "test-project-id")
+	service := NewQuotaService(client)
+
+	router := gin.New()
+	router.GET("/quota/raw", service.GetRawQuota)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/raw", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response QuotaResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	if _, ok := response.Models["chat_20706"]; !ok {
+		t.Errorf("Expected model dropped by formatQuota to appear in raw output")
+	}
+	if len(formatQuota(&response, false, defaultQuotaFormat).Models) != 1 {
+		t.Errorf("Expected formatQuota to drop the non-gemini/claude model")
+	}
+}
+
+func TestGetRawQuotaKeepsPercentages(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "test-access-token", ExpiresIn: 3600})
+			return
+		}
+		w.Write([]byte(`{"models":{"gemini-3-flash":{"quotaInfo":{"remainingFraction":95}}}}`))
+	}))
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL,
+		ProjectAPIURL: mockServer.URL,
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	})
+	service := NewQuotaService(client)
+	router := gin.New()
+	router.GET("/quota/raw", service.GetRawQuota)
+	router.GET("/quota/all", service.GetAllQuota)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/raw", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"remainingFraction":95`) {
+		t.Errorf("Expected the percentage exactly as upstream sent it, got %s", w.Body.String())
+	}
+
+	// The cached response is shared, so formatting must not have changed it either
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/quota/all", nil)
+	router.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"percentage":95`) {
+		t.Errorf("Expected /quota/all to read 95 as 95%%, got %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/quota/raw", nil)
+	router.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"remainingFraction":95`) {
+		t.Errorf("Expected the raw percentage unchanged after formatting, got %s", w.Body.String())
+	}
+}
+
+func TestGetModelNames(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(QuotaResponse{
+			Models: map[string]ModelInfo{
+				"gemini-3-flash":    {QuotaInfo: QuotaInfo{RemainingFraction: 0.9}},
+				"chat_20706":        {QuotaInfo: QuotaInfo{RemainingFraction: 0.5}},
+				"claude-sonnet-4-5": {QuotaInfo: QuotaInfo{RemainingFraction: 0.8}},
+			},
+		})
+	}))
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL,
+		ProjectAPIURL: mockServer.URL,
+		TokenURL:      mockServer.URL,
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}
+	client := NewCloudCodeClient(config)
+	client.GetQuota("test-access-token", "test-project-id")
+	service := NewQuotaService(client)
+
+	router := gin.New()
+	router.GET("/quota/models", service.GetModelNames)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/models", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var names []string
+	if err := json.Unmarshal(w.Body.Bytes(), &names); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	expected := []string{"chat_20706", "claude-sonnet-4-5", "gemini-3-flash"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+}
+
+func TestRawQuotaRequiresDebugEndpoints(t *testing.T) {
+	os.Unsetenv("DEBUG_ENDPOINTS")
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/raw", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected /quota/raw to be hidden by default, got %d", w.Code)
+	}
+}
+
+func TestPickClaudeModel(t *testing.T) {
+	tests := []struct {
+		name     string
+		models   []FormattedModel
+		expected string
+	}{
+		{
+			"exact match preferred",
+			[]FormattedModel{
+				{Name: "claude-sonnet-4-6", Percentage: 50},
+				{Name: "claude-sonnet-4-5", Percentage: 80},
+			},
+			"claude-sonnet-4-5",
+		},
+		{
+			"fallback to newer sonnet",
+			[]FormattedModel{
+				{Name: "claude-opus-4-5-thinking", Percentage: 10},
+				{Name: "claude-sonnet-4-6-thinking", Percentage: 20},
+				{Name: "claude-sonnet-4-6", Percentage: 70},
+			},
+			"claude-sonnet-4-6",
+		},
+		{
+			"numeric version ordering",
+			[]FormattedModel{
+				{Name: "claude-sonnet-4-10", Percentage: 60},
+				{Name: "claude-sonnet-4-9", Percentage: 40},
+			},
+			"claude-sonnet-4-10",
+		},
+		{
+			"no sonnet",
+			[]FormattedModel{{Name: "claude-opus-4-5-thinking", Percentage: 10}},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, _ := pickClaudeModel(tt.models)
+			if model.Name != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, model.Name)
+			}
+		})
+	}
+
+	// The overview and status follow the fallback
+	quota := &FormattedQuota{Models: []FormattedModel{{Name: "claude-sonnet-4-6", Percentage: 70}}}
+	if overview := buildOverview(quota, DisplayOptions{}); !strings.HasSuffix(overview, "Claude 70%") {
+		t.Errorf("Expected overview to use claude-sonnet-4-6, got %q", overview)
+	}
+	if status := buildStatus(quota, ColorTheme{}, DisplayOptions{}); !strings.HasSuffix(status, "󰛄 70%") {
+		t.Errorf("Expected status to use claude-sonnet-4-6, got %q", status)
+	}
+}
+
+func TestWeightedScore(t *testing.T) {
+	models := []FormattedModel{
+		{Name: "gemini-3-pro-high", Percentage: 95},
+		{Name: "gemini-3-flash", Percentage: 90},
+		{Name: "claude-sonnet-4-5", Percentage: 80},
+	}
+
+	tests := []struct {
+		name     string
+		weights  map[string]float64
+		exclude  bool
+		expected int
+		ok       bool
+	}{
+		{"unweighted average", nil, false, 88, true},
+		{"weighted", map[string]float64{"gemini-3-pro": 2, "claude": 0.5}, false, 91, true},
+		{"zero excludes", map[string]float64{"flash": 0}, false, 88, true},
+		{"longest match wins", map[string]float64{"gemini": 0, "gemini-3-pro": 1}, false, 88, true},
+		{"exclude unlisted", map[string]float64{"claude": 3}, true, 80, true},
+		{"nothing weighted", map[string]float64{"claude": 0}, true, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, ok := weightedScore(models, tt.weights, tt.exclude)
+			if ok != tt.ok || score != tt.expected {
+				t.Errorf("Expected (%d, %v), got (%d, %v)", tt.expected, tt.ok, score, ok)
+			}
+		})
+	}
+}
+
+func TestGetQuotaScore(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+		Weights:       parseWeights(`{"Gemini-3-Pro": 2, "flash": 0}`),
+	}
+	service := NewQuotaService(NewCloudCodeClient(config))
+
+	router := gin.New()
+	router.GET("/quota/score", service.GetQuotaScore)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/score", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var response struct {
+		Score int `json:"score"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	// (2*95 + 0*90 + 1*80) / 3 = 90
+	if response.Score != 90 {
+		t.Errorf("Expected score 90, got %d", response.Score)
+	}
+}
+
+func TestParseWeights(t *testing.T) {
+	weights := parseWeights(`{"Pro": 2, "flash": -1}`)
+	if len(weights) != 1 || weights["pro"] != 2 {
+		t.Errorf("Expected only the lowercased non-negative weight, got %v", weights)
+	}
+	if parseWeights("not json") != nil {
+		t.Errorf("Expected malformed WEIGHTS to be ignored")
+	}
+}
+
+func TestBuildWaybar(t *testing.T) {
+	tests := []struct {
+		worstPct int
+		class    string
+	}{
+		{95, "good"},
+		{50, "good"},
+		{35, "warning"},
+		{20, "warning"},
+		{5, "critical"},
+		{0, "critical"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.class, func(t *testing.T) {
+			quota := &FormattedQuota{
+				Models: []FormattedModel{
+					{Name: "claude-sonnet-4-5", Percentage: tt.worstPct, ResetTimeRelative: "1h 0m"},
+					{Name: "gemini-3-flash", Percentage: 100},
+					{Name: "gemini-3-pro-high", Percentage: 100},
+				},
+			}
+
+			output := buildWaybar(quota, DisplayOptions{})
+			if output.Class != tt.class {
+				t.Errorf("Expected class %s for %d%%, got %s", tt.class, tt.worstPct, output.Class)
+			}
+			if output.Percentage != tt.worstPct {
+				t.Errorf("Expected percentage %d, got %d", tt.worstPct, output.Percentage)
+			}
+			if !strings.HasPrefix(output.Text, "Pro 100% | Flash 100%") {
+				t.Errorf("Unexpected text: %q", output.Text)
+			}
+			if lines := strings.Split(output.Tooltip, "\n"); len(lines) != 3 || !strings.Contains(lines[0], "(reset 1h 0m)") {
+				t.Errorf("Unexpected tooltip: %q", output.Tooltip)
+			}
+		})
+	}
+}
+
+func TestGetQuotaCompare(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}
+	client := NewCloudCodeClient(config)
+	service := NewQuotaService(client)
+
+	router := gin.New()
+	router.GET("/quota/compare", service.GetQuotaCompare)
+
+	fetch := func() (models []ModelComparison, previousUpdated *int64) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/quota/compare", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+
+		var response struct {
+			Models          []ModelComparison `json:"models"`
+			PreviousUpdated *int64            `json:"previous_updated"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		return response.Models, response.PreviousUpdated
+	}
+
+	// First call has no prior sample
+	models, previousUpdated := fetch()
+	if len(models) != 3 {
+		t.Fatalf("Expected 3 models, got %d", len(models))
+	}
+	if previousUpdated != nil {
+		t.Errorf("Expected null previous_updated on first call")
+	}
+	for _, model := range models {
+		if model.PreviousPercentage != nil || model.Delta != nil || model.TimeToZero != nil {
+			t.Errorf("Expected null deltas for %s on first call", model.Name)
+		}
+	}
+
+	// Expire the cache so the second call fetches a new sample
+	client.ClearCache()
+
+	models, previousUpdated = fetch()
+	if previousUpdated == nil {
+		t.Errorf("Expected previous_updated on second call")
+	}
+	for _, model := range models {
+		if model.PreviousPercentage == nil || model.Delta == nil {
+			t.Fatalf("Expected deltas for %s on second call", model.Name)
+		}
+		if *model.PreviousPercentage != model.Percentage || *model.Delta != 0 {
+			t.Errorf("Expected unchanged %s, got previous %d delta %d", model.Name, *model.PreviousPercentage, *model.Delta)
+		}
+		if model.TimeToZero != nil {
+			t.Errorf("Expected no time to zero for %s without burn", model.Name)
+		}
+	}
+}
+
+func TestBuildComparison(t *testing.T) {
+	current := &FormattedQuota{Models: []FormattedModel{
+		{Name: "claude-sonnet-4-5", Percentage: 60},
+		{Name: "gemini-3-flash", Percentage: 90},
+		{Name: "gemini-3-pro-high", Percentage: 95},
+	}}
+	previous := &FormattedQuota{Models: []FormattedModel{
+		{Name: "claude-sonnet-4-5", Percentage: 80},
+		{Name: "gemini-3-flash", Percentage: 90},
+	}}
+
+	comparisons := buildComparison(current, previous, time.Hour)
+
+	claude := comparisons[0]
+	if claude.Delta == nil || *claude.Delta != -20 {
+		t.Fatalf("Expected claude delta -20, got %v", claude.Delta)
+	}
+	if claude.TimeToZeroSeconds == nil || *claude.TimeToZeroSeconds != 3*3600 {
+		t.Errorf("Expected 3h to zero at 20%%/h with 60%% left, got %v", claude.TimeToZeroSeconds)
+	}
+	if claude.TimeToZero == nil || *claude.TimeToZero != "3h 0m" {
+		t.Errorf("Expected formatted time to zero 3h 0m, got %v", claude.TimeToZero)
+	}
+
+	if flash := comparisons[1]; flash.Delta == nil || *flash.Delta != 0 || flash.TimeToZero != nil {
+		t.Errorf("Expected unchanged flash with no time to zero, got %+v", flash)
+	}
+
+	if pro := comparisons[2]; pro.PreviousPercentage != nil || pro.Delta != nil {
+		t.Errorf("Expected null deltas for a model missing from the previous sample")
+	}
+}
+
+func TestUpdateBurnRates(t *testing.T) {
+	snapshot := func(fractions map[string]float64) *QuotaResponse {
+		quota := &QuotaResponse{Models: make(map[string]ModelInfo)}
+		for name, fraction := range fractions {
+			quota.Models[name] = ModelInfo{QuotaInfo: QuotaInfo{RemainingFraction: fraction}}
+		}
+		return quota
+	}
+
+	// Hourly snapshots: steady 10%/h, a 30%/h spike, then a reset
+	snapshots := []*QuotaResponse{
+		snapshot(map[string]float64{"a": 1.0, "b": 0.5}),
+		snapshot(map[string]float64{"a": 0.9, "b": 0.5}),
+		snapshot(map[string]float64{"a": 0.8, "b": 0.5}),
+		snapshot(map[string]float64{"a": 0.5, "b": 0.5}),
+		snapshot(map[string]float64{"a": 1.0, "b": 0.4}),
+	}
+	want := []map[string]float64{
+		{"a": 10, "b": 0},
+		{"a": 10, "b": 0},
+		{"a": 16, "b": 0},
+		{"b": 3},
+	}
+
+	rates := make(map[string]float64)
+	for i := 1; i < len(snapshots); i++ {
+		updateBurnRates(rates, snapshots[i-1], snapshots[i], time.Hour, 0.3, false)
+		if len(rates) != len(want[i-1]) {
+			t.Fatalf("Step %d: expected rates %v, got %v", i, want[i-1], rates)
+		}
+		for name, expected := range want[i-1] {
+			if math.Abs(rates[name]-expected) > 1e-9 {
+				t.Errorf("Step %d: expected %s rate %v, got %v", i, name, expected, rates[name])
+			}
+		}
+	}
+
+	comparisons := []ModelComparison{{Name: "a", Percentage: 100}, {Name: "b", Percentage: 40}}
+	applyBurnRates(comparisons, rates)
+	if comparisons[0].BurnRateEMA != nil || comparisons[0].EstimatedResetToZero != nil {
+		t.Errorf("Expected no smoothed rate for a model that just reset")
+	}
+	b := comparisons[1]
+	if b.BurnRateEMA == nil || *b.BurnRateEMA != rates["b"] {
+		t.Fatalf("Expected smoothed rate for b, got %v", b.BurnRateEMA)
+	}
+	if b.EstimatedResetToZeroSeconds == nil || math.Abs(float64(*b.EstimatedResetToZeroSeconds)-40.0/3*3600) > 1 {
+		t.Errorf("Expected ~13h20m to zero at 3%%/h with 40%% left, got %v", b.EstimatedResetToZeroSeconds)
+	}
+	if b.EstimatedResetToZero == nil || *b.EstimatedResetToZero != "13h 20m" {
+		t.Errorf("Expected formatted estimate 13h 20m, got %v", b.EstimatedResetToZero)
+	}
+}
+
+func TestFindNextReset(t *testing.T) {
+	now := time.Date(2025, 12, 26, 10, 0, 0, 0, time.UTC)
+	models := []FormattedModel{
+		{Name: "gemini-3-pro-high", ResetTime: "2025-12-26T14:00:00Z"},
+		{Name: "gemini-3-flash", ResetTime: "2025-12-26T09:00:00Z"},
+		{Name: "claude-sonnet-4-5", ResetTime: "2025-12-26T11:30:00Z"},
+		{Name: "claude-opus-4-5", ResetTime: ""},
+		{Name: "gemini-3-pro-low", ResetTime: "2025-12-26T12:00:00Z"},
+	}
+
+	model, resetAt, ok := findNextReset(models, now)
+	if !ok || model.Name != "claude-sonnet-4-5" {
+		t.Fatalf("Expected claude-sonnet-4-5 as the soonest upcoming reset, got %q (ok=%v)", model.Name, ok)
+	}
+	if !resetAt.Equal(time.Date(2025, 12, 26, 11, 30, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected reset time %v", resetAt)
+	}
+
+	if _, _, ok := findNextReset(models[1:2], now); ok {
+		t.Errorf("Expected a past reset to be ignored")
+	}
+}
+
+func TestGetNextReset(t *testing.T) {
+	soon := time.Now().Add(90 * time.Minute).UTC().Truncate(time.Second)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+			"gemini-3-pro-high": {QuotaInfo: QuotaInfo{RemainingFraction: 0.5, ResetTime: soon.Add(time.Hour).Format(time.RFC3339)}},
+			"gemini-3-flash":    {QuotaInfo: QuotaInfo{RemainingFraction: 0.5, ResetTime: soon.Format(time.RFC3339)}},
+			"claude-sonnet-4-5": {QuotaInfo: QuotaInfo{RemainingFraction: 0.5, ResetTime: time.Now().Add(-time.Hour).Format(time.RFC3339)}},
+		}})
+	}))
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL,
+		ProjectAPIURL: mockServer.URL,
+		TokenURL:      mockServer.URL,
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	})
+	router := gin.New()
+	router.GET("/quota/next-reset", NewQuotaService(client).GetNextReset)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/next-reset", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response["model"] != "gemini-3-flash" {
+		t.Errorf("Expected gemini-3-flash, got %q", response["model"])
+	}
+	if response["reset_time"] != soon.Format(time.RFC3339) {
+		t.Errorf("Expected reset_time %s, got %q", soon.Format(time.RFC3339), response["reset_time"])
+	}
+	if !strings.HasPrefix(response["reset_time_relative"], "1h") {
+		t.Errorf("Expected a relative time around 1h 30m, got %q", response["reset_time_relative"])
+	}
+}
+
+func TestGetQuotaSummary(t *testing.T) {
+	soon := time.Now().Add(90 * time.Minute).UTC().Truncate(time.Second)
+	var fetches atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "new-access-token", ExpiresIn: 3600})
+			return
+		}
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+			"gemini-3-pro-high": {QuotaInfo: QuotaInfo{RemainingFraction: 0.95, ResetTime: soon.Add(time.Hour).Format(time.RFC3339)}},
+			"gemini-3-flash":    {QuotaInfo: QuotaInfo{RemainingFraction: 0.9, ResetTime: soon.Format(time.RFC3339)}},
+			"claude-sonnet-4-5": {QuotaInfo: QuotaInfo{RemainingFraction: 0.3, ResetTime: soon.Add(2 * time.Hour).Format(time.RFC3339)}},
+		}})
+	}))
+	defer mockServer.Close()
+
+	// No debounce, so every quota request reaches upstream
+	service := NewQuotaService(NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL,
+		ProjectAPIURL: mockServer.URL,
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+	}))
+	router := gin.New()
+	router.GET("/quota/summary", service.GetQuotaSummary)
+	router.GET("/quota/overview", service.GetQuotaOverview)
+	router.GET("/quota/worst", service.GetWorstQuota)
+	router.GET("/quota/next-reset", service.GetNextReset)
+	router.GET("/quota/all", service.GetAllQuota)
+
+	get := func(path string, v any) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", path, w.Code, w.Body.String())
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s: failed to parse response: %v", path, err)
+		}
+	}
+
+	var summary QuotaSummary
+	get("/quota/summary", &summary)
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("Expected the summary to make 1 upstream fetch, got %d", got)
+	}
+	if summary.Worst == nil || summary.NextReset == nil || summary.Quota == nil || summary.Overview == "" {
+		t.Fatalf("Expected every summary field to be set, got %+v", summary)
+	}
+
+	var overview struct {
+		Overview string `json:"overview"`
+	}
+	get("/quota/overview", &overview)
+	if summary.Overview != overview.Overview {
+		t.Errorf("Expected overview %q, got %q", overview.Overview, summary.Overview)
+	}
+
+	var worst WorstModel
+	get("/quota/worst", &worst)
+	if summary.Worst.Model.Name != worst.Model.Name || summary.Worst.Model.Name != "claude-sonnet-4-5" || summary.Worst.AllFull != worst.AllFull {
+		t.Errorf("Expected worst %+v, got %+v", worst, *summary.Worst)
+	}
+
+	var next NextReset
+	get("/quota/next-reset", &next)
+	if summary.NextReset.Model != next.Model || summary.NextReset.ResetTime != next.ResetTime || summary.NextReset.Model != "gemini-3-flash" {
+		t.Errorf("Expected next reset %+v, got %+v", next, *summary.NextReset)
+	}
+
+	var all struct {
+		Quota FormattedQuota `json:"quota"`
+	}
+	get("/quota/all", &all)
+	if len(summary.Quota.Models) != len(all.Quota.Models) {
+		t.Fatalf("Expected %d models, got %d", len(all.Quota.Models), len(summary.Quota.Models))
+	}
+	for i, model := range all.Quota.Models {
+		if summary.Quota.Models[i].Name != model.Name || summary.Quota.Models[i].Percentage != model.Percentage {
+			t.Errorf("Model %d: expected %s %d%%, got %s %d%%", i, model.Name, model.Percentage, summary.Quota.Models[i].Name, summary.Quota.Models[i].Percentage)
+		}
+	}
+}
+
+func TestGetModelPercentage(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}
+	service := NewQuotaService(NewCloudCodeClient(config))
+
+	router := gin.New()
+	router.GET("/quota/model/:name/percentage", service.GetModelPercentage)
+
+	tests := []struct {
+		name   string
+		model  string
+		status int
+		body   string
+	}{
+		{"exact match", "gemini-3-flash", http.StatusOK, "90"},
+		{"case-insensitive substring", "FLASH", http.StatusOK, "90"},
+		{"no match", "gpt-5", http.StatusNotFound, "error: no model matches"},
+		{"ambiguous", "gemini", http.StatusBadRequest, "error: pattern matches more than one model"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/quota/model/"+tt.model+"/percentage", nil)
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if !strings.HasPrefix(w.Body.String(), tt.body) {
+				t.Errorf("Expected body starting with %q, got %q", tt.body, w.Body.String())
+			}
+		})
+	}
+
+	// An exact name beats longer names containing it
+	models := []FormattedModel{{Name: "claude-sonnet-4-5-thinking"}, {Name: "claude-sonnet-4-5", Percentage: 42}}
+	if model, err := matchModel(models, "Claude-Sonnet-4-5"); err != nil || model.Percentage != 42 {
+		t.Errorf("Expected exact match to win, got %+v (%v)", model, err)
+	}
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	t.Setenv("DEBUG_ENDPOINTS", "true")
+	t.Setenv("API_KEY", "secret") // registers /quota/query
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var spec struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("Spec is not valid JSON: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.0") {
+		t.Errorf("Expected OpenAPI 3.0, got %q", spec.OpenAPI)
+	}
+
+	// Every registered route must be documented, and vice versa
+	// Gin's :param segments are {param} in OpenAPI
+	pathParam := regexp.MustCompile(`:(\w+)`)
+	registered := make(map[string]bool)
+	for _, route := range router.Routes() {
+		path := pathParam.ReplaceAllString(route.Path, "{$1}")
+		registered[path] = true
+		operations, ok := spec.Paths[path]
+		if !ok {
+			t.Errorf("Route %s is missing from the spec", path)
+			continue
+		}
+		if _, ok := operations[strings.ToLower(route.Method)]; !ok {
+			t.Errorf("Route %s %s is missing from the spec", route.Method, path)
+		}
+	}
+	for path := range spec.Paths {
+		if !registered[path] {
+			t.Errorf("Spec documents %s, which is not a registered route", path)
+		}
+	}
+}
+
+func TestSortModels(t *testing.T) {
+	soon := time.Now().Add(time.Hour).Format(time.RFC3339)
+	later := time.Now().Add(3 * time.Hour).Format(time.RFC3339)
+	base := []FormattedModel{
+		{Name: "claude-sonnet-4-5", Percentage: 80, ResetTime: later},
+		{Name: "gemini-3-flash", Percentage: 90, ResetTime: ""},
+		{Name: "gemini-3-pro-high", Percentage: 40, ResetTime: soon},
+	}
+
+	tests := []struct {
+		by       string
+		expected []string
+	}{
+		{"name", []string{"claude-sonnet-4-5", "gemini-3-flash", "gemini-3-pro-high"}},
+		{"reset", []string{"gemini-3-pro-high", "claude-sonnet-4-5", "gemini-3-flash"}},
+		{"percentage", []string{"gemini-3-pro-high", "claude-sonnet-4-5", "gemini-3-flash"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.by, func(t *testing.T) {
+			models := append([]FormattedModel(nil), base...)
+			if err := sortModels(models, tt.by); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for i, name := range tt.expected {
+				if models[i].Name != name {
+					t.Errorf("Position %d: expected %s, got %s", i, name, models[i].Name)
+				}
+			}
+		})
+	}
+
+	if err := sortModels(base, "bogus"); err == nil {
+		t.Errorf("Expected an error for an unknown sort")
+	}
+}
+
+func TestGetAllQuotaSort(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}
+	service := NewQuotaService(NewCloudCodeClient(config))
+
+	router := gin.New()
+	router.GET("/quota/all", service.GetAllQuota)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/all?sort=percentage", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response struct {
+		Quota FormattedQuota `json:"quota"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(response.Quota.Models) == 0 || response.Quota.Models[0].Name != "claude-sonnet-4-5" {
+		t.Errorf("Expected claude-sonnet-4-5 first by percentage, got %+v", response.Quota.Models)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/quota/all?sort=bogus", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid sort, got %d", w.Code)
+	}
+}
+
+func TestGetAllQuotaPagination(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}
+	service := NewQuotaService(NewCloudCodeClient(config))
+
+	router := gin.New()
+	router.GET("/quota/all", service.GetAllQuota)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		models []string
+	}{
+		{"no params", "", http.StatusOK, []string{"claude-sonnet-4-5", "gemini-3-flash", "gemini-3-pro-high"}},
+		{"limit", "?limit=2", http.StatusOK, []string{"claude-sonnet-4-5", "gemini-3-flash"}},
+		{"offset", "?offset=1", http.StatusOK, []string{"gemini-3-flash", "gemini-3-pro-high"}},
+		{"limit and offset", "?limit=1&offset=2", http.StatusOK, []string{"gemini-3-pro-high"}},
+		{"limit past the end", "?limit=10&offset=1", http.StatusOK, []string{"gemini-3-flash", "gemini-3-pro-high"}},
+		{"offset past the end", "?offset=5", http.StatusOK, []string{}},
+		{"zero limit", "?limit=0", http.StatusOK, []string{}},
+		{"negative limit", "?limit=-1", http.StatusBadRequest, nil},
+		{"negative offset", "?offset=-2", http.StatusBadRequest, nil},
+		{"non-numeric limit", "?limit=ten", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/quota/all"+tt.query, nil)
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Quota FormattedQuota `json:"quota"`
+				Total int            `json:"total"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			if response.Total != 3 {
+				t.Errorf("Expected total 3, got %d", response.Total)
+			}
+			if response.Quota.Models == nil {
+				t.Fatalf("Expected models array, got null")
+			}
+			names := make([]string, len(response.Quota.Models))
+			for i, model := range response.Quota.Models {
+				names[i] = model.Name
+			}
+			if !reflect.DeepEqual(names, tt.models) {
+				t.Errorf("Expected %v, got %v", tt.models, names)
+			}
+		})
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	t.Setenv("BASE_PATH", "/antigravity/")
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/antigravity/healthz", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected /antigravity/healthz to respond 200, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/antigravity/quota", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected /antigravity/quota to respond 200, got %d", w.Code)
+	}
+
+	var response struct {
+		Endpoints map[string]string `json:"endpoints"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if _, ok := response.Endpoints["/antigravity/quota/overview"]; !ok {
+		t.Errorf("Expected prefixed endpoints, got %v", response.Endpoints)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/quota", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected unprefixed /quota to be 404, got %d", w.Code)
+	}
+}
+
+func TestNormalizeBasePath(t *testing.T) {
+	tests := map[string]string{
+		"":              "",
+		"/":             "",
+		"antigravity":   "/antigravity",
+		"/antigravity/": "/antigravity",
+		"/a/b":          "/a/b",
+	}
+	for input, expected := range tests {
+		if got := normalizeBasePath(input); got != expected {
+			t.Errorf("normalizeBasePath(%q) = %q, want %q", input, got, expected)
+		}
+	}
+}
+
+func TestProjectIDCached(t *testing.T) {
+	var projectHits atomic.Int32
+	var forbidden atomic.Bool
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1internal:loadCodeAssist":
+			projectHits.Add(1)
+			json.NewEncoder(w).Encode(ProjectResponse{CloudAICompanionProject: "resolved-project"})
+		case "/v1internal:fetchAvailableModels":
+			if forbidden.Load() {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+				"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.9}},
+			}})
+		case "/token":
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "new-access-token", ExpiresIn: 3600})
+		}
+	}))
+	defer mockServer.Close()
+
+	accountFile := filepath.Join(t.TempDir(), "account.json")
+	data, _ := json.Marshal(Account{AccessToken: "access", RefreshToken: "refresh"})
+	if err := os.WriteFile(accountFile, data, 0600); err != nil {
+		t.Fatalf("Failed to write account: %v", err)
+	}
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   accountFile,
+		QueryDebounce: 1,
+	})
+	service := NewQuotaService(client)
+
+	for i := 0; i < 3; i++ {
+		client.ClearCache()
+		if _, err := service.getQuotaData(context.Background(), "", DebounceMaxAge); err != nil {
+			t.Fatalf("Call %d failed: %v", i, err)
+		}
+	}
+	if got := projectHits.Load(); got != 1 {
+		t.Errorf("Expected 1 loadCodeAssist call, got %d", got)
+	}
+
+	// A 403 invalidates the cached project ID
+	forbidden.Store(true)
+	client.ClearCache()
+	if _, err := service.getQuotaData(context.Background(), "", DebounceMaxAge); err == nil {
+		t.Fatalf("Expected 403 error")
+	}
+	forbidden.Store(false)
+	client.ClearCache()
+	if _, err := service.getQuotaData(context.Background(), "", DebounceMaxAge); err != nil {
+		t.Fatalf("Expected recovery after 403, got %v", err)
+	}
+	if got := projectHits.Load(); got != 2 {
+		t.Errorf("Expected project ID lookup again after 403, got %d calls", got)
+	}
+}
+
+func TestProjectQueryParam(t *testing.T) {
+	var projectHits atomic.Int32
+	var mu sync.Mutex
+	fetches := map[string]int{}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1internal:loadCodeAssist":
+			projectHits.Add(1)
+			json.NewEncoder(w).Encode(ProjectResponse{CloudAICompanionProject: "resolved-project"})
+		case "/v1internal:fetchAvailableModels":
+			var payload map[string]string
+			json.NewDecoder(r.Body).Decode(&payload)
+			mu.Lock()
+			fetches[payload["project"]]++
+			mu.Unlock()
+			fraction := 0.9
+			if payload["project"] == "project-b" {
+				fraction = 0.4
+			}
+			json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+				"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: fraction}},
+			}})
+		case "/token":
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "new-access-token", ExpiresIn: 3600})
+		}
+	}))
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	})
+	router := gin.New()
+	router.GET("/quota/flash", NewQuotaService(client).GetGemini3Flash)
+
+	get := func(query string) FormattedQuota {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/quota/flash"+query, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %q, got %d: %s", query, w.Code, w.Body.String())
+		}
+		var response struct {
+			Quota FormattedQuota `json:"quota"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		return response.Quota
+	}
+
+	// Alternating between projects hits each upstream project once
+	for i := 0; i < 2; i++ {
+		if got := get("?project=project-a").Models[0].Percentage; got != 90 {
+			t.Errorf("Expected project-a at 90%%, got %d", got)
+		}
+		if got := get("?project=project-b").Models[0].Percentage; got != 40 {
+			t.Errorf("Expected project-b at 40%%, got %d", got)
+		}
+	}
+	get("")
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, project := range []string{"project-a", "project-b", "test-project-id"} {
+		if fetches[project] != 1 {
+			t.Errorf("Expected 1 fetch for %s, got %d", project, fetches[project])
+		}
+	}
+	if got := projectHits.Load(); got != 0 {
+		t.Errorf("Expected ?project to skip project ID lookup, got %d calls", got)
+	}
+}
+
+func TestAccountSwapFetchesFreshQuota(t *testing.T) {
+	var fetches atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		fetches.Add(1)
+		fraction := 0.9
+		if payload["project"] == "project-b" {
+			fraction = 0.3
+		}
+		json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+			"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: fraction}},
+		}})
+	}))
+	defer mockServer.Close()
+
+	accountFile := filepath.Join(t.TempDir(), "account.json")
+	expiry := time.Now().Add(time.Hour).Unix()
+	writeAccount := func(refreshToken, projectID string) {
+		t.Helper()
+		data, _ := json.Marshal(Account{Token: &TokenData{
+			AccessToken:     "access-" + refreshToken,
+			RefreshToken:    refreshToken,
+			ExpiryTimestamp: &expiry,
+			ProjectID:       projectID,
+		}})
+		if err := os.WriteFile(accountFile, data, 0600); err != nil {
+			t.Fatalf("Failed to write account: %v", err)
+		}
+	}
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL,
+		AccountFile:   accountFile,
+		QueryDebounce: 10,
+	})
+	service := NewQuotaService(client)
+
+	fraction := func() float64 {
+		t.Helper()
+		quota, err := service.getQuotaData(context.Background(), "", DebounceMaxAge)
+		if err != nil {
+			t.Fatalf("getQuotaData failed: %v", err)
+		}
+		return quota.Models["gemini-3-flash"].QuotaInfo.RemainingFraction
+	}
+
+	writeAccount("refresh-a", "project-a")
+	if got := fraction(); got != 0.9 {
+		t.Errorf("Expected project-a quota, got %v", got)
+	}
+
+	// Swapping the account file within the debounce window must not serve the first account's quota
+	writeAccount("refresh-b", "project-b")
+	if got := fraction(); got != 0.3 {
+		t.Errorf("Expected fresh project-b quota after the swap, got %v", got)
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("Expected 2 upstream fetches, got %d", got)
+	}
+
+	// Each account keeps its own cache entry
+	writeAccount("refresh-a", "project-a")
+	if got := fraction(); got != 0.9 {
+		t.Errorf("Expected cached project-a quota, got %v", got)
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("Expected swapping back to be served from cache, got %d fetches", got)
+	}
+}
+
+func TestQuotaCacheKey(t *testing.T) {
+	key := quotaCacheKey("secret-refresh-token", "project-a")
+	if strings.Contains(key, "secret") || strings.Contains(key, "project-a") {
+		t.Errorf("Expected a hashed cache key, got %q", key)
+	}
+	if key != quotaCacheKey("secret-refresh-token", "project-a") {
+		t.Errorf("Expected a stable cache key")
+	}
+	if key == quotaCacheKey("other-refresh-token", "project-a") || key == quotaCacheKey("secret-refresh-token", "project-b") {
+		t.Errorf("Expected different accounts and projects to get different keys")
+	}
+}
+
+func TestPostQuotaRefresh(t *testing.T) {
+	var fetches atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "new-access-token", ExpiresIn: 3600})
+			return
+		}
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+			"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.9}},
+		}})
+	}))
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL,
+		AccountFile:   createTestAccount(t),
+		TokenURL:      mockServer.URL + "/token",
+		QueryDebounce: 10,
+	}
+	client := NewCloudCodeClient(config)
+	service := NewQuotaService(client)
+
+	router := gin.New()
+	router.GET("/quota/all", service.GetAllQuota)
+	router.POST("/quota/refresh", service.PostQuotaRefresh)
+
+	for _, request := range []struct{ method, path string }{
+		{"GET", "/quota/all"},
+		{"GET", "/quota/all"},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(request.method, request.path, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+	}
+	before := fetches.Load()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quota/refresh", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if before != 1 {
+		t.Errorf("Expected the second GET to be served from cache, got %d fetches", before)
+	}
+	if got := fetches.Load(); got != before+1 {
+		t.Errorf("Expected refresh to hit upstream again, fetches went from %d to %d", before, got)
+	}
+
+	var response struct {
+		Quota FormattedQuota `json:"quota"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(response.Quota.Models) != 1 {
+		t.Errorf("Expected 1 model, got %d", len(response.Quota.Models))
+	}
+}
+
+func TestMinUpstreamInterval(t *testing.T) {
+	var fetches atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "new-access-token", ExpiresIn: 3600})
+			return
+		}
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+			"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.9}},
+		}})
+	}))
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:                     mockServer.URL,
+		AccountFile:                createTestAccount(t),
+		TokenURL:                   mockServer.URL + "/token",
+		QueryDebounce:              10,
+		MinUpstreamIntervalSeconds: 60,
+	}
+	client := NewCloudCodeClient(config)
+	service := NewQuotaService(client)
+
+	router := gin.New()
+	router.POST("/quota/refresh", service.PostQuotaRefresh)
+
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/quota/refresh", nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Refresh %d: expected status 200, got %d", i, w.Code)
+		}
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("Expected forced refreshes within the interval to make 1 upstream call, got %d", got)
+	}
+
+	// With the floor disabled, a forced refresh reaches upstream again
+	config.MinUpstreamIntervalSeconds = 0
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quota/refresh", nil)
+	router.ServeHTTP(w, req)
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("Expected a refresh after the interval to hit upstream, got %d calls", got)
+	}
+}
+
+func TestPostQuotaRefreshRequiresAPIKey(t *testing.T) {
+	t.Setenv("API_KEY", "secret")
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quota/refresh", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without API key, got %d", w.Code)
+	}
+}
+
+func TestPostQuotaQuery(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	accountFile := filepath.Join(t.TempDir(), "account.json")
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   accountFile,
+	}
+	service := NewQuotaService(NewCloudCodeClient(config))
+
+	router := gin.New()
+	router.POST("/quota/query", service.PostQuotaQuery)
+
+	// No expiry, so the token is refreshed before fetching
+	body := `{"token": {"access_token": "posted-access-token", "refresh_token": "posted-refresh-token", "project_id": "posted-project"}}`
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quota/query", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Quota FormattedQuota `json:"quota"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Quota.Models) != 3 {
+		t.Errorf("Expected 3 models for the posted account, got %d", len(response.Quota.Models))
+	}
+	if _, err := os.Stat(accountFile); !os.IsNotExist(err) {
+		t.Errorf("Expected the posted account not to be written to ACCOUNT_FILE, stat err: %v", err)
+	}
+
+	for _, invalid := range []string{`{"token": `, `{"project_id": "posted-project"}`} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/quota/query", strings.NewReader(invalid))
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Body %s: expected status 400, got %d", invalid, w.Code)
+		}
+		var errBody errorEnvelope
+		json.Unmarshal(w.Body.Bytes(), &errBody)
+		if errBody.Error.Code != CodeBadRequest {
+			t.Errorf("Body %s: expected code %q, got %q", invalid, CodeBadRequest, errBody.Error.Code)
+		}
+	}
+}
+
+func TestPostQuotaQueryRequiresAPIKey(t *testing.T) {
+	t.Setenv("API_KEY", "")
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quota/query", strings.NewReader(`{}`))
+	setupTestRouter().ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected /quota/query to be unavailable without API_KEY, got %d", w.Code)
+	}
+
+	t.Setenv("API_KEY", "secret")
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/quota/query", strings.NewReader(`{}`))
+	setupTestRouter().ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without the API key, got %d", w.Code)
+	}
+}
+
+func TestQuotaCacheHeaders(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 5,
+	}
+	service := NewQuotaService(NewCloudCodeClient(config))
+
+	router := gin.New()
+	router.Use(service.CacheHeaders())
+	router.GET("/quota/all", service.GetAllQuota)
+	router.GET("/quota/pro", service.GetGemini3Pro)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/all", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("Expected an ETag header")
+	}
+
+	var maxAge int
+	if _, err := fmt.Sscanf(w.Header().Get("Cache-Control"), "max-age=%d", &maxAge); err != nil {
+		t.Fatalf("Unexpected Cache-Control %q", w.Header().Get("Cache-Control"))
+	}
+	if maxAge < 295 || maxAge > 300 {
+		t.Errorf("Expected max-age close to the 5 minute debounce, got %d", maxAge)
+	}
+
+	// A matching If-None-Match gets 304 with no body, even when weakened by gzip
+	for _, ifNoneMatch := range []string{etag, "W/" + etag, `"other", ` + etag} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/quota/all", nil)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotModified {
+			t.Errorf("If-None-Match %s: expected 304, got %d", ifNoneMatch, w.Code)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("Expected empty 304 body, got %q", w.Body.String())
+		}
+	}
+
+	// Different content has a different ETag
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/quota/pro", nil)
+	req.Header.Set("If-None-Match", etag)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a filtered response with a different ETag, got %d", w.Code)
+	}
+}
+
+func TestQuotaCacheHeadersOtherEndpoints(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 5,
+	}
+	service := NewQuotaService(NewCloudCodeClient(config))
+
+	router := gin.New()
+	router.Use(service.CacheHeaders())
+	router.GET("/quota/worst", service.GetWorstQuota)
+	router.GET("/quota/status.txt", service.GetQuotaStatusText)
+
+	for _, path := range []string{"/quota/worst", "/quota/status.txt"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", path, w.Code)
+		}
+		etag := w.Header().Get("ETag")
+		if etag == "" {
+			t.Fatalf("%s: expected an ETag header", path)
+		}
+		if !strings.HasPrefix(w.Header().Get("Cache-Control"), "max-age=") {
+			t.Errorf("%s: unexpected Cache-Control %q", path, w.Header().Get("Cache-Control"))
+		}
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", path, nil)
+		req.Header.Set("If-None-Match", etag)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotModified {
+			t.Errorf("%s: expected 304, got %d", path, w.Code)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("%s: expected empty 304 body, got %q", path, w.Body.String())
+		}
+	}
+}
+
+func TestQuotaCacheHeadersServedEntry(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 5,
+	})
+	clock := newFakeClock(time.Date(2025, 12, 26, 10, 0, 0, 0, time.UTC))
+	client.SetClock(clock)
+	service := NewQuotaService(client)
+
+	router := gin.New()
+	router.Use(MaxAge(), service.CacheHeaders())
+	router.GET("/quota/all", service.GetAllQuota)
+
+	maxAge := func(path string) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", path, w.Code)
+		}
+		var seconds int
+		if _, err := fmt.Sscanf(w.Header().Get("Cache-Control"), "max-age=%d", &seconds); err != nil {
+			t.Fatalf("%s: unexpected Cache-Control %q", path, w.Header().Get("Cache-Control"))
+		}
+		return seconds
+	}
+
+	if got := maxAge("/quota/all"); got != 300 {
+		t.Errorf("Expected the full debounce window for a fresh fetch, got %d", got)
+	}
+	clock.Advance(4 * time.Minute)
+
+	// A project fetched just now has its own full window, not what is left of the account's
+	if got := maxAge("/quota/all?project=other-project"); got != 300 {
+		t.Errorf("Expected max-age from the project's own fetch, got %d", got)
+	}
+	if got := maxAge("/quota/all"); got != 60 {
+		t.Errorf("Expected what is left of the account's window, got %d", got)
+	}
+
+	// ?max_age serves the 4 minute old entry for up to 10 minutes after its fetch
+	if got := maxAge("/quota/all?max_age=600"); got != 360 {
+		t.Errorf("Expected max-age from the max_age window, got %d", got)
+	}
+	if got := maxAge("/quota/all?max_age=30"); got != 30 {
+		t.Errorf("Expected a refetch to get the whole max_age window, got %d", got)
+	}
+}
+
+func TestResponseETag(t *testing.T) {
+	etag, stale := responseETag([]byte(`{"quota":{"models":[{"name":"gemini-3-flash","percentage":90}],"last_updated":1,"is_stale":false}}`))
+	later, _ := responseETag([]byte(`{"quota":{"models":[{"name":"gemini-3-flash","percentage":90}],"last_updated":2,"is_stale":false}}`))
+	if etag != later {
+		t.Errorf("Expected ETag to ignore last_updated")
+	}
+	if stale {
+		t.Errorf("Expected a fresh response not to be stale")
+	}
+
+	changed, _ := responseETag([]byte(`{"quota":{"models":[{"name":"gemini-3-flash","percentage":80}],"last_updated":1,"is_stale":false}}`))
+	if etag == changed {
+		t.Errorf("Expected ETag to change with the percentage")
+	}
+
+	if _, stale := responseETag([]byte(`{"quota":{"models":[],"is_stale":true}}`)); !stale {
+		t.Errorf("Expected is_stale to be reported")
+	}
+
+	text, _ := responseETag([]byte("Flash 90%"))
+	otherText, _ := responseETag([]byte("Flash 80%"))
+	if text == otherText {
+		t.Errorf("Expected plain-text bodies to be hashed as-is")
+	}
+}
+
+func TestGetDebugToken(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["refresh_token"] == "revoked" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "new-access-token", ExpiresIn: 3600})
+	}))
+	defer mockServer.Close()
+
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Hour).Unix()
+
+	tests := []struct {
+		name      string
+		account   Account
+		fresh     bool
+		attempted bool
+		succeeded bool
+	}{
+		{"fresh", Account{Token: &TokenData{AccessToken: "a", RefreshToken: "r", ExpiryTimestamp: &future}}, true, false, false},
+		{"expired refreshable", Account{Token: &TokenData{AccessToken: "a", RefreshToken: "r", ExpiryTimestamp: &past}}, false, true, true},
+		{"expired unrefreshable", Account{Token: &TokenData{AccessToken: "a", RefreshToken: "revoked", ExpiryTimestamp: &past}}, false, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accountFile := filepath.Join(t.TempDir(), "account.json")
+			original, _ := json.MarshalIndent(tt.account, "", "  ")
+			if err := os.WriteFile(accountFile, original, 0600); err != nil {
+				t.Fatalf("Failed to write account: %v", err)
+			}
+
+			service := NewQuotaService(NewCloudCodeClient(&Config{TokenURL: mockServer.URL, AccountFile: accountFile}))
+			router := gin.New()
+			router.GET("/debug/token", service.GetDebugToken)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/debug/token", nil)
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+
+			var diagnosis TokenDiagnosis
+			if err := json.Unmarshal(w.Body.Bytes(), &diagnosis); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			if diagnosis.Fresh != tt.fresh || diagnosis.RefreshAttempted != tt.attempted || diagnosis.RefreshSucceeded != tt.succeeded {
+				t.Errorf("Unexpected diagnosis: %+v", diagnosis)
+			}
+			if diagnosis.ExpiresAt == "" {
+				t.Errorf("Expected expires_at to be reported")
+			}
+			if tt.succeeded && diagnosis.NewExpiresAt == "" {
+				t.Errorf("Expected new_expires_at after a successful refresh")
+			}
+			if tt.attempted && !tt.succeeded && diagnosis.RefreshError == "" {
+				t.Errorf("Expected refresh_error after a failed refresh")
+			}
+			if strings.Contains(w.Body.String(), "new-access-token") {
+				t.Errorf("Expected the refreshed token not to be exposed")
+			}
+
+			// The dry run never writes the account file
+			saved, _ := os.ReadFile(accountFile)
+			if !bytes.Equal(saved, original) {
+				t.Errorf("Expected the account file to be unchanged")
+			}
+		})
+	}
+}
+
+func TestGetDebugAccount(t *testing.T) {
+	accessToken := "ya29.a0AfB_byC-secret-access-token-value"
+	refreshToken := "1//0gSecretRefreshTokenValue"
+	expiry := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name    string
+		account Account
+		format  string
+		valid   bool
+		project bool
+	}{
+		{"nested", Account{Token: &TokenData{AccessToken: accessToken, RefreshToken: refreshToken, ExpiryTimestamp: &expiry, ProjectID: "my-project"}}, "nested", true, true},
+		{"top-level", Account{AccessToken: accessToken, RefreshToken: refreshToken}, "top-level", true, false},
+		{"mixed", Account{Token: &TokenData{AccessToken: accessToken}, RefreshToken: refreshToken}, "mixed", true, false},
+		{"invalid", Account{Token: &TokenData{AccessToken: accessToken, ExpiryTimestamp: &expiry}}, "mixed", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accountFile := filepath.Join(t.TempDir(), "account.json")
+			data, _ := json.Marshal(tt.account)
+			if err := os.WriteFile(accountFile, data, 0600); err != nil {
+				t.Fatalf("Failed to write account: %v", err)
+			}
+
+			service := NewQuotaService(NewCloudCodeClient(&Config{AccountFile: accountFile}))
+			router := gin.New()
+			router.GET("/debug/account", service.GetDebugAccount)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/debug/account", nil)
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+			if strings.Contains(w.Body.String(), accessToken) || strings.Contains(w.Body.String(), refreshToken) {
+				t.Fatalf("Expected tokens to be redacted, got %s", w.Body.String())
+			}
+
+			var summary AccountSummary
+			if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			if summary.Format != tt.format {
+				t.Errorf("Expected format %q, got %q", tt.format, summary.Format)
+			}
+			if summary.Valid != tt.valid {
+				t.Errorf("Expected valid=%v, got %v (%s)", tt.valid, summary.Valid, summary.ValidationError)
+			}
+			if summary.HasProjectID != tt.project {
+				t.Errorf("Expected has_project_id=%v, got %v", tt.project, summary.HasProjectID)
+			}
+			if summary.Source != accountFile {
+				t.Errorf("Expected source %q, got %q", accountFile, summary.Source)
+			}
+			if summary.AccessToken != "ya29.a..." {
+				t.Errorf("Expected redacted access token prefix, got %q", summary.AccessToken)
+			}
+		})
+	}
+}
+
+func TestGetDebugProject(t *testing.T) {
+	var lookups atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ProjectResponse{CloudAICompanionProject: "looked-up-project"})
+	}))
+	defer mockServer.Close()
+
+	expiry := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name    string
+		account Account
+		project string
+		sources []string
+		lookups int32
+	}{
+		{"from account", Account{Token: &TokenData{AccessToken: "access", RefreshToken: "refresh", ExpiryTimestamp: &expiry, ProjectID: "my-project"}}, "my-project", []string{ProjectSourceAccount, ProjectSourceAccount}, 0},
+		{"from api then cache", Account{Token: &TokenData{AccessToken: "access", RefreshToken: "refresh", ExpiryTimestamp: &expiry}}, "looked-up-project", []string{ProjectSourceAPI, ProjectSourceCache}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookups.Store(0)
+			accountFile := filepath.Join(t.TempDir(), "account.json")
+			data, _ := json.Marshal(tt.account)
+			if err := os.WriteFile(accountFile, data, 0600); err != nil {
+				t.Fatalf("Failed to write account: %v", err)
+			}
+
+			service := NewQuotaService(NewCloudCodeClient(&Config{
+				AccountFile:   accountFile,
+				ProjectAPIURL: mockServer.URL,
+			}))
+			router := gin.New()
+			router.GET("/debug/project", service.GetDebugProject)
+
+			for i, source := range tt.sources {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/debug/project", nil)
+				router.ServeHTTP(w, req)
+
+				if w.Code != http.StatusOK {
+					t.Fatalf("Request %d: expected status 200, got %d: %s", i, w.Code, w.Body.String())
+				}
+				var resolution ProjectResolution
+				if err := json.Unmarshal(w.Body.Bytes(), &resolution); err != nil {
+					t.Fatalf("Failed to parse response: %v", err)
+				}
+				if resolution.Source != source {
+					t.Errorf("Request %d: expected source %q, got %q", i, source, resolution.Source)
+				}
+				if resolution.ProjectID != tt.project {
+					t.Errorf("Request %d: expected project %q, got %q", i, tt.project, resolution.ProjectID)
+				}
+			}
+			if got := lookups.Load(); got != tt.lookups {
+				t.Errorf("Expected %d loadCodeAssist calls, got %d", tt.lookups, got)
+			}
+		})
+	}
+}
+
+func TestDebugTokenRequiresDebugEndpoints(t *testing.T) {
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/debug/token", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without DEBUG_ENDPOINTS, got %d", w.Code)
+	}
+}
+
+func TestParseResetTime(t *testing.T) {
+	expected := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		input string
+		ok    bool
+	}{
+		{"rfc3339", "2030-01-02T03:04:05Z", true},
+		{"rfc3339 offset", "2030-01-02T05:04:05+02:00", true},
+		{"epoch seconds", strconv.FormatInt(expected.Unix(), 10), true},
+		{"epoch milliseconds", strconv.FormatInt(expected.UnixMilli(), 10), true},
+		{"empty", "", false},
+		{"garbage", "tomorrow", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseResetTime(tt.input)
+			if ok != tt.ok {
+				t.Fatalf("Expected ok=%v, got %v", tt.ok, ok)
+			}
+			if ok && !got.Equal(expected) {
+				t.Errorf("Expected %v, got %v", expected, got)
+			}
+		})
+	}
+
+	// Both formatters accept epoch reset times
+	epoch := strconv.FormatInt(time.Now().Add(2*time.Hour+30*time.Minute).Unix(), 10)
+	if got := formatTimeRemaining(epoch); !strings.HasPrefix(got, "2h") {
+		t.Errorf("Expected formatTimeRemaining to handle epochs, got %q", got)
+	}
+	if got := formatTimeCompact(epoch, true); got == "" {
+		t.Errorf("Expected formatTimeCompact to handle epochs")
+	}
+}
+
+func TestQuotaInfoNumericResetTime(t *testing.T) {
+	var quota QuotaResponse
+	data := `{"models":{"a":{"quotaInfo":{"remainingFraction":0.5,"resetTime":1893553445}},"b":{"quotaInfo":{"resetTime":"2030-01-02T03:04:05Z"}},"c":{"quotaInfo":{}}}}`
+	if err := json.Unmarshal([]byte(data), &quota); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	if got := quota.Models["a"].QuotaInfo; got.ResetTime != "1893553445" || got.RemainingFraction != 0.5 {
+		t.Errorf("Unexpected numeric reset time parse: %+v", got)
+	}
+	if got := quota.Models["b"].QuotaInfo.ResetTime; got != "2030-01-02T03:04:05Z" {
+		t.Errorf("Unexpected string reset time: %q", got)
+	}
+	if got := quota.Models["c"].QuotaInfo.ResetTime; got != "" {
+		t.Errorf("Expected empty reset time, got %q", got)
+	}
+}
+
+func TestFractionIsPercent(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"models":{"gemini-3-flash":{"quotaInfo":{"remainingFraction":0.95}},"gemini-3-pro-high":{"quotaInfo":{"remainingFraction":95}}}}`))
+	}))
+	defer mockServer.Close()
+
+	tests := []struct {
+		name              string
+		fractionIsPercent bool
+		expected          map[string]int
+	}{
+		// 0.95 is a fraction and 95 is detected as a percentage
+		{"heuristic", false, map[string]int{"gemini-3-flash": 95, "gemini-3-pro-high": 95}},
+		// Every value is a percentage, so 0.95 means under 1%
+		{"configured", true, map[string]int{"gemini-3-flash": 0, "gemini-3-pro-high": 95}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewCloudCodeClient(&Config{
+				APIURL:            mockServer.URL,
+				ProjectAPIURL:     mockServer.URL,
+				TokenURL:          mockServer.URL,
+				AccountFile:       createTestAccount(t),
+				FractionIsPercent: tt.fractionIsPercent,
+			})
+			quotaRaw, err := client.GetQuota("test-access-token", "test-project-id")
+			if err != nil {
+				t.Fatalf("Failed to get quota: %v", err)
+			}
+
+			for _, model := range formatQuota(quotaRaw, false, quotaFormatFor(client.config)).Models {
+				if model.Percentage != tt.expected[model.Name] {
+					t.Errorf("Expected %d%% for %s, got %d%%", tt.expected[model.Name], model.Name, model.Percentage)
+				}
+			}
+			if fraction := quotaRaw.Models["gemini-3-pro-high"].QuotaInfo.RemainingFraction; fraction != 95 {
+				t.Errorf("Expected the cached response to keep upstream's 95, got %v", fraction)
+			}
+		})
+	}
+}
+
+func TestGetQuotaTmux(t *testing.T) {
+	reset := time.Now().Add(2*time.Hour + 30*time.Second).UTC().Format(time.RFC3339)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+			"gemini-3-pro-high": {QuotaInfo: QuotaInfo{RemainingFraction: 0.9, ResetTime: reset}},
+			"gemini-3-flash":    {QuotaInfo: QuotaInfo{RemainingFraction: 0.3, ResetTime: reset}},
+			"claude-sonnet-4-5": {QuotaInfo: QuotaInfo{RemainingFraction: 0.1, ResetTime: reset}},
+		}})
+	}))
+	defer mockServer.Close()
+
+	for _, showReset := range []bool{false, true} {
+		client := NewCloudCodeClient(&Config{
+			APIURL:        mockServer.URL,
+			ProjectAPIURL: mockServer.URL,
+			TokenURL:      mockServer.URL,
+			AccountFile:   createTestAccount(t),
+			QueryDebounce: 1,
+			TmuxShowReset: showReset,
+		})
+		router := gin.New()
+		router.GET("/quota/tmux", NewQuotaService(client).GetQuotaTmux)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/quota/tmux", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		body := w.Body.String()
+		for _, want := range []string{"#[fg=green]90%#[default]", "#[fg=yellow]30%#[default]", "#[fg=red]10%#[default]"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("Expected tmux output to contain %q, got %q", want, body)
+			}
+		}
+		if strings.Contains(body, "\033") {
+			t.Errorf("Expected no raw ANSI escapes, got %q", body)
+		}
+		if got := strings.Contains(body, "2h"); got != showReset {
+			t.Errorf("With TmuxShowReset=%v expected reset suffix=%v, got %q", showReset, showReset, body)
+		}
+	}
+}
+
+func TestModelProvider(t *testing.T) {
+	tests := map[string]string{
+		"gemini-3-pro-high":   "gemini",
+		"Gemini-2.5-Flash":    "gemini",
+		"claude-sonnet-4-5":   "claude",
+		"claude-opus-4-5":     "claude",
+		"gpt-oss-120b-medium": "other",
+		"":                    "other",
+	}
+	for name, want := range tests {
+		if got := modelProvider(name); got != want {
+			t.Errorf("modelProvider(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestGroupByProvider(t *testing.T) {
+	quota := &FormattedQuota{Models: []FormattedModel{
+		{Name: "claude-sonnet-4-5", Percentage: 80},
+		{Name: "gemini-3-flash", Percentage: 90},
+		{Name: "gemini-3-pro-high", Percentage: 70},
+		{Name: "gpt-oss-120b-medium", Percentage: 60},
+	}, IsStale: true}
+
+	data, err := json.Marshal(groupByProvider(quota))
+	if err != nil {
+		t.Fatalf("Failed to marshal grouped quota: %v", err)
+	}
+	var grouped struct {
+		Models  map[string][]FormattedModel `json:"models"`
+		IsStale bool                        `json:"is_stale"`
+	}
+	if err := json.Unmarshal(data, &grouped); err != nil {
+		t.Fatalf("Failed to parse grouped quota %s: %v", data, err)
+	}
+
+	names := func(models []FormattedModel) []string {
+		var out []string
+		for _, model := range models {
+			out = append(out, model.Name)
+		}
+		return out
+	}
+	want := map[string][]string{
+		"gemini": {"gemini-3-flash", "gemini-3-pro-high"},
+		"claude": {"claude-sonnet-4-5"},
+		"other":  {"gpt-oss-120b-medium"},
+	}
+	if len(grouped.Models) != len(want) {
+		t.Errorf("Expected providers %v, got %s", want, data)
+	}
+	for provider, models := range want {
+		if got := names(grouped.Models[provider]); !reflect.DeepEqual(got, models) {
+			t.Errorf("Expected %s models %v, got %v", provider, models, got)
+		}
+	}
+	if !grouped.IsStale {
+		t.Error("Expected quota metadata to be kept alongside the groups")
+	}
+}
+
+func TestGetAllQuotaGrouped(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	service := NewQuotaService(NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}))
+	router := gin.New()
+	router.GET("/quota/all", service.GetAllQuota)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/all?group=provider", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Quota struct {
+			Models map[string][]FormattedModel `json:"models"`
+		} `json:"quota"`
+		Total int `json:"total"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(response.Quota.Models["gemini"]) != 2 || len(response.Quota.Models["claude"]) != 1 {
+		t.Errorf("Expected 2 gemini and 1 claude models, got %v", response.Quota.Models)
+	}
+	if _, ok := response.Quota.Models["other"]; ok {
+		t.Errorf("Expected no empty other bucket, got %v", response.Quota.Models)
+	}
+	if response.Total != 3 {
+		t.Errorf("Expected total 3, got %d", response.Total)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/quota/all?group=model", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown group, got %d", w.Code)
+	}
+}
+
+func TestGetQuotaGrafana(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	})
+	router := gin.New()
+	router.GET("/quota/grafana", NewQuotaService(client).GetQuotaGrafana)
+
+	fetch := func() []map[string]interface{} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/quota/grafana", nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var rows []map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+			t.Fatalf("Expected a top-level JSON array, got %s: %v", w.Body.String(), err)
+		}
+		return rows
+	}
+
+	before := time.Now().UnixMilli()
+	rows := fetch()
+	if len(rows) != 3 {
+		t.Fatalf("Expected one row per model, got %v", rows)
+	}
+	for _, row := range rows {
+		if len(row) != 3 {
+			t.Errorf("Expected exactly time, model, and percentage, got %v", row)
+		}
+		ms, ok := row["time"].(float64)
+		if !ok || int64(ms) < before || int64(ms) > time.Now().UnixMilli() {
+			t.Errorf("Expected time in Unix milliseconds, got %v", row["time"])
+		}
+		if _, ok := row["model"].(string); !ok {
+			t.Errorf("Expected a string model, got %v", row["model"])
+		}
+		if _, ok := row["percentage"].(float64); !ok {
+			t.Errorf("Expected a numeric percentage, got %v", row["percentage"])
+		}
+	}
+
+	// A second fetch adds the previous sample's rows ahead of the latest
+	client.ClearCache()
+	time.Sleep(2 * time.Millisecond)
+	rows = fetch()
+	if len(rows) != 6 {
+		t.Fatalf("Expected previous and latest rows, got %v", rows)
+	}
+	if rows[0]["time"].(float64) >= rows[3]["time"].(float64) {
+		t.Errorf("Expected previous rows before the latest, got %v", rows)
+	}
+}
+
+func TestOverviewContentNegotiation(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	service := NewQuotaService(NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}))
+	router := gin.New()
+	router.GET("/quota/overview", service.GetQuotaOverview)
+	router.GET("/quota/status", service.GetQuotaStatus)
+
+	overview := "Pro 95% | Flash 90% | Claude 80%"
+	tests := []struct {
+		path   string
+		accept string
+		json   bool
+	}{
+		{"/quota/overview", "", true},
+		{"/quota/overview", "*/*", true},
+		{"/quota/overview", "application/json", true},
+		{"/quota/overview", "text/plain", false},
+		{"/quota/overview", "text/plain, application/json;q=0.5", false},
+		{"/quota/status", "application/json", true},
+		{"/quota/status", "text/plain", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.accept, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Header().Get("Vary"), "Accept") {
+				t.Errorf("Expected Vary: Accept, got %q", w.Header().Get("Vary"))
+			}
+			contentType := w.Header().Get("Content-Type")
+			if tt.json {
+				var response map[string]string
+				if !strings.HasPrefix(contentType, "application/json") || json.Unmarshal(w.Body.Bytes(), &response) != nil {
+					t.Fatalf("Expected wrapped JSON, got %q %q", contentType, w.Body.String())
+				}
+				if tt.path == "/quota/overview" && response["overview"] != overview {
+					t.Errorf("Expected overview %q, got %q", overview, response["overview"])
+				}
+				return
+			}
+			if !strings.HasPrefix(contentType, "text/plain") {
+				t.Errorf("Expected text/plain, got %q", contentType)
+			}
+			if tt.path == "/quota/overview" && w.Body.String() != overview {
+				t.Errorf("Expected bare overview %q, got %q", overview, w.Body.String())
+			}
+			if strings.HasPrefix(w.Body.String(), "{") {
+				t.Errorf("Expected no JSON wrapper, got %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetDebugCache(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	})
+	start := time.Date(2025, 12, 26, 10, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+	client.SetClock(clock)
+
+	service := NewQuotaService(client)
+	router := gin.New()
+	router.GET("/debug/cache", service.GetDebugCache)
+
+	state := func() CacheState {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/debug/cache", nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var response CacheState
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		return response
+	}
+
+	if got := state(); got.Cached || got.Valid || got.LastUpdated != nil {
+		t.Errorf("Expected an empty cache before the first fetch, got %+v", got)
+	}
+
+	if _, err := service.getQuotaData(context.Background(), "", DebounceMaxAge); err != nil {
+		t.Fatalf("getQuotaData failed: %v", err)
+	}
+	clock.Advance(45 * time.Second)
+
+	got := state()
+	if !got.Cached || !got.Valid {
+		t.Errorf("Expected a valid cache inside the debounce window, got %+v", got)
+	}
+	if got.AgeSeconds == nil || *got.AgeSeconds != 45 || got.TTLSeconds != 15 || got.DebounceSeconds != 60 {
+		t.Errorf("Expected age 45s, ttl 15s, debounce 60s, got %+v", got)
+	}
+	if got.LastUpdated == nil || *got.LastUpdated != start.Unix() {
+		t.Errorf("Expected last_updated at the fetch time, got %+v", got)
+	}
+
+	// Past the debounce window the cache no longer serves requests
+	clock.Advance(30 * time.Second)
+	if got := state(); !got.Cached || got.Valid || got.TTLSeconds != 0 {
+		t.Errorf("Expected an expired cache after the debounce window, got %+v", got)
+	}
+}
+
+func TestFormatTimeWithFakeNow(t *testing.T) {
+	setNow(t, time.Date(2025, 12, 26, 10, 0, 0, 0, time.UTC))
+
+	if got := formatTimeRemaining("2025-12-26T12:30:00Z"); got != "2h 30m" {
+		t.Errorf("Expected 2h 30m, got %q", got)
+	}
+	if got := formatTimeRemaining("2025-12-29T13:00:00Z"); got != "3d 3h" {
+		t.Errorf("Expected 3d 3h, got %q", got)
+	}
+	if got := formatTimeRemaining("2025-12-26T09:59:00Z"); got != "Reset due" {
+		t.Errorf("Expected Reset due, got %q", got)
+	}
+	if got := formatTimeCompact("2025-12-26T12:30:00Z", false); got != "2h30m" {
+		t.Errorf("Expected 2h30m, got %q", got)
+	}
+	if got := formatQuota(&QuotaResponse{}, false, defaultQuotaFormat).LastUpdated; got != now().Unix() {
+		t.Errorf("Expected last_updated from the fake clock, got %d", got)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BadgeContentType is the content type of quota badges
+const BadgeContentType = "image/svg+xml; charset=utf-8"
+
+// badgeColors are the shields.io colors for each percentage class
+var badgeColors = map[string]string{
+	"good":     "#4c1",
+	"warning":  "#dfb317",
+	"critical": "#e05d44",
+}
+
+// badgeCharWidth approximates the width of one 11px Verdana character
+const badgeCharWidth = 7
+
+// badgeTemplate renders a flat shields.io-style badge
+var badgeTemplate = template.Must(template.New("badge").Funcs(template.FuncMap{"xml": html.EscapeString}).Parse(
+	`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{xml .Label}}: {{xml .Message}}">
+<title>{{xml .Label}}: {{xml .Message}}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)">
+<rect width="{{.LabelWidth}}" height="20" fill="#555"/>
+<rect x="{{.LabelWidth}}" width="{{.MessageWidth}}" height="20" fill="{{.Color}}"/>
+<rect width="{{.Width}}" height="20" fill="url(#s)"/>
+</g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="{{.LabelX}}" y="14">{{xml .Label}}</text>
+<text x="{{.MessageX}}" y="14">{{xml .Message}}</text>
+</g>
+</svg>
+`))
+
+// badge holds the computed layout for badgeTemplate
+type badge struct {
+	Label, Message, Color    string
+	LabelWidth, MessageWidth int
+	Width, LabelX, MessageX  int
+}
+
+// GetQuotaBadge returns an SVG badge for ?model, or the worst tracked model by default
+func (s *QuotaService) GetQuotaBadge(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	quota := formatQuota(quotaRaw, false, s.quotaFormat())
+	opts := s.displayOptions()
+
+	var model FormattedModel
+	if pattern := c.Query("model"); pattern != "" {
+		model, err = matchModel(quota.Models, pattern)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+	} else {
+		var tracked []FormattedModel
+		for _, slot := range overviewSlots(quota, opts) {
+			if slot.model.Name != "" {
+				tracked = append(tracked, slot.model)
+			}
+		}
+		var ok bool
+		if model, ok = findWorstModel(tracked); !ok {
+			respondError(c, newAppError(http.StatusNotFound, CodeNotFound, "no tracked models available"))
+			return
+		}
+	}
+
+	var svg strings.Builder
+	if err := badgeTemplate.Execute(&svg, buildBadge(badgeLabel(model.Name, opts), model.Percentage, opts.thresholds())); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, BadgeContentType, []byte(svg.String()))
+}
+
+// badgeLabel returns the lowercase display label for a model, e.g. "pro"
+func badgeLabel(name string, opts DisplayOptions) string {
+	fallback := ModelLabel{Label: name}
+	nameLower := strings.ToLower(name)
+	for pattern, label := range defaultSlotLabels {
+		if strings.Contains(nameLower, pattern) {
+			fallback = label
+		}
+	}
+	return strings.ToLower(opts.labelFor(name, fallback).Label)
+}
+
+// buildBadge lays out a badge reading "label: pct%" colored by the percentage class
+func buildBadge(label string, pct int, thresholds QuotaThresholds) badge {
+	b := badge{
+		Label:   label,
+		Message: fmt.Sprintf("%d%%", pct),
+		Color:   badgeColors[classifyPercentage(pct, thresholds)],
+	}
+	b.LabelWidth = len([]rune(b.Label))*badgeCharWidth + 10
+	b.MessageWidth = len([]rune(b.Message))*badgeCharWidth + 10
+	b.Width = b.LabelWidth + b.MessageWidth
+	b.LabelX = b.LabelWidth / 2
+	b.MessageX = b.LabelWidth + b.MessageWidth/2
+	return b
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetQuotaBadge(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}
+	service := NewQuotaService(NewCloudCodeClient(config))
+
+	router := gin.New()
+	router.GET("/quota/badge", service.GetQuotaBadge)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		text   string
+		color  string
+	}{
+		{"explicit model", "?model=gemini-3-pro-high", http.StatusOK, "pro: 95%", "#4c1"},
+		{"worst tracked model", "", http.StatusOK, "claude: 80%", "#4c1"},
+		{"no match", "?model=gpt-5", http.StatusNotFound, "", ""},
+		{"ambiguous", "?model=gemini", http.StatusBadRequest, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/quota/badge"+tt.query, nil)
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "image/svg+xml") {
+				t.Errorf("Expected SVG content type, got %q", got)
+			}
+
+			// The badge must be well-formed XML
+			decoder := xml.NewDecoder(strings.NewReader(w.Body.String()))
+			for {
+				if _, err := decoder.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("Badge is not valid XML: %v", err)
+				}
+			}
+
+			body := w.Body.String()
+			if !strings.Contains(body, "<title>"+tt.text+"</title>") {
+				t.Errorf("Expected badge text %q, got %s", tt.text, body)
+			}
+			if !strings.Contains(body, `fill="`+tt.color+`"`) {
+				t.Errorf("Expected color %s, got %s", tt.color, body)
+			}
+		})
+	}
+}
+
+func TestBuildBadge(t *testing.T) {
+	thresholds := DefaultQuotaThresholds
+
+	for _, tt := range []struct {
+		pct   int
+		color string
+	}{{95, "#4c1"}, {30, "#dfb317"}, {5, "#e05d44"}, {0, "#e05d44"}} {
+		if got := buildBadge("pro", tt.pct, thresholds).Color; got != tt.color {
+			t.Errorf("Expected color %s for %d%%, got %s", tt.color, tt.pct, got)
+		}
+	}
+
+	b := buildBadge("pro", 95, thresholds)
+	if b.Width != b.LabelWidth+b.MessageWidth || b.MessageX <= b.LabelWidth {
+		t.Errorf("Unexpected badge layout: %+v", b)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache stores quota responses by key, each expiring after its TTL
+type Cache interface {
+	// Get returns the cached quota with its FetchedAt, or false when missing or
+	// expired. Whether it is still fresh is up to the caller.
+	Get(key string) (*QuotaResponse, bool)
+	// Set stores quota and its FetchedAt under key for ttl; a non-positive ttl
+	// stores nothing
+	Set(key string, quota *QuotaResponse, ttl time.Duration) error
+	// Delete removes key so the next Get misses
+	Delete(key string) error
+}
+
+// NewCache returns the cache selected by CACHE_BACKEND, falling back to memory
+// when the backend is unknown or Redis is misconfigured
+func NewCache(config *Config) Cache {
+	switch config.CacheBackend {
+	case "", "memory":
+		return NewMemoryCache()
+	case "redis":
+		cache, err := NewRedisCache(config.RedisURL)
+		if err != nil {
+			slog.Warn("Invalid REDIS_URL, falling back to the in-memory cache", "error", err)
+			return NewMemoryCache()
+		}
+		return cache
+	default:
+		slog.Warn("Unknown CACHE_BACKEND, falling back to the in-memory cache", "backend", config.CacheBackend)
+		return NewMemoryCache()
+	}
+}
+
+// MemoryCache is a process-local Cache
+type MemoryCache struct {
+	mu      sync.RWMutex
+	entries map[string]memoryCacheEntry
+	clock   Clock
+}
+
+type memoryCacheEntry struct {
+	quota     *QuotaResponse
+	expiresAt time.Time
+}
+
+// NewMemoryCache creates an empty in-memory cache
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]memoryCacheEntry), clock: realClock{}}
+}
+
+// Get returns the cached quota if it has not expired
+func (m *MemoryCache) Get(key string) (*QuotaResponse, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, exists := m.entries[key]
+	if !exists || !m.clock.Now().Before(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.quota, true
+}
+
+// Set stores quota under key for ttl
+func (m *MemoryCache) Set(key string, quota *QuotaResponse, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = memoryCacheEntry{quota: quota, expiresAt: m.clock.Now().Add(ttl)}
+	return nil
+}
+
+// Delete removes key
+func (m *MemoryCache) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+// redisKeyPrefix namespaces cache keys in a shared Redis
+const redisKeyPrefix = "coding-plan-quota-query:"
+
+// redisTimeout bounds each Redis command so a slow Redis cannot stall requests
+const redisTimeout = 2 * time.Second
+
+// RedisCache is a Cache shared between replicas, storing quota responses as JSON
+type RedisCache struct {
+	client *redis.Client
+}
+
+// redisCacheEntry is the JSON stored per key, carrying the FetchedAt that
+// QuotaResponse leaves out of its own encoding
+type redisCacheEntry struct {
+	Quota     *QuotaResponse `json:"quota"`
+	FetchedAt time.Time      `json:"fetched_at"`
+}
+
+// NewRedisCache connects to the Redis server at redisURL (redis:// or rediss://)
+func NewRedisCache(redisURL string) (*RedisCache, error) {
+	if redisURL == "" {
+		return nil, errors.New("REDIS_URL is required for the redis cache backend")
+	}
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse REDIS_URL: %w", err)
+	}
+	return &RedisCache{client: redis.NewClient(opts)}, nil
+}
+
+// Get returns the cached quota, treating Redis errors as misses
+func (r *RedisCache) Get(key string) (*QuotaResponse, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	data, err := r.client.Get(ctx, redisKeyPrefix+key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.Warn("Redis cache read failed", "key", key, "error", err)
+		}
+		return nil, false
+	}
+
+	var entry redisCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Quota == nil {
+		slog.Warn("Ignoring malformed Redis cache entry", "key", key, "error", err)
+		return nil, false
+	}
+	entry.Quota.FetchedAt = entry.FetchedAt
+	return entry.Quota, true
+}
+
+// Set stores quota as JSON under key for ttl
+func (r *RedisCache) Set(key string, quota *QuotaResponse, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(redisCacheEntry{Quota: quota, FetchedAt: quota.FetchedAt})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return r.client.Set(ctx, redisKeyPrefix+key, data, ttl).Err()
+}
+
+// Delete removes key
+func (r *RedisCache) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return r.client.Del(ctx, redisKeyPrefix+key).Err()
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func testQuota() *QuotaResponse {
+	return &QuotaResponse{Models: map[string]ModelInfo{
+		"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.75, ResetTime: "2030-01-02T03:04:05Z"}},
+	}}
+}
+
+func TestMemoryCache(t *testing.T) {
+	cache := NewMemoryCache()
+
+	if _, ok := cache.Get("quota"); ok {
+		t.Fatalf("Expected miss on empty cache")
+	}
+
+	quota := testQuota()
+	if err := cache.Set("quota", quota, time.Minute); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if got, ok := cache.Get("quota"); !ok || got != quota {
+		t.Errorf("Expected cached quota, got %v (%v)", got, ok)
+	}
+
+	cache.Delete("quota")
+	if _, ok := cache.Get("quota"); ok {
+		t.Errorf("Expected miss after Delete")
+	}
+
+	// Expired and non-positive TTL entries miss
+	cache.Set("quota", quota, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := cache.Get("quota"); ok {
+		t.Errorf("Expected miss after expiry")
+	}
+	cache.Set("quota", quota, 0)
+	if _, ok := cache.Get("quota"); ok {
+		t.Errorf("Expected zero TTL not to be stored")
+	}
+}
+
+func TestRedisCache(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	cache, err := NewRedisCache("redis://" + server.Addr())
+	if err != nil {
+		t.Fatalf("NewRedisCache failed: %v", err)
+	}
+
+	if _, ok := cache.Get("quota"); ok {
+		t.Fatalf("Expected miss on empty cache")
+	}
+
+	fetchedAt := time.Date(2025, 12, 26, 10, 0, 0, 0, time.UTC)
+	quota := testQuota()
+	quota.FetchedAt = fetchedAt
+	if err := cache.Set("quota", quota, time.Minute); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	got, ok := cache.Get("quota")
+	if !ok {
+		t.Fatalf("Expected cached quota")
+	}
+	info := got.Models["gemini-3-flash"].QuotaInfo
+	if info.RemainingFraction != 0.75 || info.ResetTime != "2030-01-02T03:04:05Z" {
+		t.Errorf("Unexpected round-tripped quota: %+v", info)
+	}
+	if !got.FetchedAt.Equal(fetchedAt) {
+		t.Errorf("Expected FetchedAt %v to round-trip, got %v", fetchedAt, got.FetchedAt)
+	}
+
+	// Stored as JSON with the TTL under a namespaced key
+	raw, err := server.Get(redisKeyPrefix + "quota")
+	if err != nil || !json.Valid([]byte(raw)) {
+		t.Errorf("Expected JSON entry in Redis, got %q (%v)", raw, err)
+	}
+	if ttl := server.TTL(redisKeyPrefix + "quota"); ttl != time.Minute {
+		t.Errorf("Expected 1m TTL, got %v", ttl)
+	}
+
+	server.FastForward(2 * time.Minute)
+	if _, ok := cache.Get("quota"); ok {
+		t.Errorf("Expected miss after expiry")
+	}
+
+	cache.Set("quota", testQuota(), time.Minute)
+	cache.Delete("quota")
+	if _, ok := cache.Get("quota"); ok {
+		t.Errorf("Expected miss after Delete")
+	}
+
+	// Malformed entries and an unreachable server are misses
+	server.Set(redisKeyPrefix+"quota", "not json")
+	if _, ok := cache.Get("quota"); ok {
+		t.Errorf("Expected miss for malformed entry")
+	}
+	server.Close()
+	if _, ok := cache.Get("quota"); ok {
+		t.Errorf("Expected miss when Redis is down")
+	}
+}
+
+func TestNewCache(t *testing.T) {
+	if _, ok := NewCache(&Config{}).(*MemoryCache); !ok {
+		t.Errorf("Expected memory cache by default")
+	}
+	if _, ok := NewCache(&Config{CacheBackend: "redis"}).(*MemoryCache); !ok {
+		t.Errorf("Expected memory fallback without REDIS_URL")
+	}
+	if _, ok := NewCache(&Config{CacheBackend: "memcached"}).(*MemoryCache); !ok {
+		t.Errorf("Expected memory fallback for unknown backend")
+	}
+	if _, ok := NewCache(&Config{CacheBackend: "redis", RedisURL: "redis://localhost:6379/0"}).(*RedisCache); !ok {
+		t.Errorf("Expected redis cache")
+	}
+}
+
+func TestRedisCacheSharedAcrossClients(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		json.NewEncoder(w).Encode(testQuota())
+	}))
+	defer upstream.Close()
+
+	newClient := func() *CloudCodeClient {
+		return NewCloudCodeClient(&Config{
+			APIURL:        upstream.URL,
+			QueryDebounce: 1,
+			CacheBackend:  "redis",
+			RedisURL:      "redis://" + server.Addr(),
+		})
+	}
+	first, second := newClient(), newClient()
+
+	if _, err := first.GetQuota("test-access-token", ""); err != nil {
+		t.Fatalf("First replica fetch failed: %v", err)
+	}
+	quota, err := second.GetQuota("test-access-token", "")
+	if err != nil {
+		t.Fatalf("Second replica fetch failed: %v", err)
+	}
+	if len(quota.Models) != 1 {
+		t.Errorf("Expected the shared quota, got %+v", quota)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("Expected replicas to share one upstream fetch, got %d", got)
+	}
+
+	// Refreshing on one replica clears the shared entry
+	second.ClearCache()
+	if _, err := first.GetQuota("test-access-token", ""); err != nil {
+		t.Fatalf("Fetch after clear failed: %v", err)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("Expected a fresh upstream fetch after clearing, got %d", got)
+	}
+}
+
+func TestRedisCacheSharedFallbacks(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	var hits atomic.Int32
+	var failing atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		hits.Add(1)
+		json.NewEncoder(w).Encode(testQuota())
+	}))
+	defer upstream.Close()
+
+	clock := newFakeClock(time.Date(2025, 12, 26, 10, 0, 0, 0, time.UTC))
+	newClient := func(minUpstreamIntervalSeconds int) *CloudCodeClient {
+		client := NewCloudCodeClient(&Config{
+			APIURL:                     upstream.URL,
+			QueryDebounce:              1,
+			MinUpstreamIntervalSeconds: minUpstreamIntervalSeconds,
+			CacheBackend:               "redis",
+			RedisURL:                   "redis://" + server.Addr(),
+		})
+		client.SetClock(clock)
+		return client
+	}
+
+	if _, err := newClient(0).GetQuota("test-access-token", ""); err != nil {
+		t.Fatalf("First replica fetch failed: %v", err)
+	}
+	clock.Advance(5 * time.Minute)
+
+	// Past the debounce window, another replica's ?max_age still accepts the shared entry
+	quota, err := newClient(0).GetAccountQuota(context.Background(), "test-access-token", "test-access-token", "", 10*time.Minute, nil)
+	if err != nil {
+		t.Fatalf("max_age fetch failed: %v", err)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("Expected max_age to be served from the shared entry, got %d upstream fetches", got)
+	}
+	if !quota.FetchedAt.Equal(clock.Now().Add(-5 * time.Minute)) {
+		t.Errorf("Expected the shared entry's fetch time, got %v", quota.FetchedAt)
+	}
+
+	// A replica throttled by MIN_UPSTREAM_INTERVAL_SECONDS serves the shared entry
+	if _, err := newClient(600).GetQuota("test-access-token", ""); err != nil {
+		t.Fatalf("Throttled fetch failed: %v", err)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("Expected the other replica's recent fetch to throttle upstream, got %d upstream fetches", got)
+	}
+
+	// A replica that never fetched falls back to the shared entry when upstream fails
+	failing.Store(true)
+	quota, err = newClient(0).GetQuota("test-access-token", "")
+	if err != nil {
+		t.Fatalf("Expected stale quota instead of an error, got %v", err)
+	}
+	if !quota.Stale || len(quota.Models) != 1 {
+		t.Errorf("Expected the shared entry marked stale, got %+v", quota)
+	}
+}
+
+func TestRedisCacheEviction(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(testQuota())
+	}))
+	defer upstream.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:        upstream.URL,
+		QueryDebounce: 5,
+		CacheBackend:  "redis",
+		RedisURL:      "redis://" + server.Addr(),
+	})
+	clock := newFakeClock(time.Date(2025, 12, 26, 10, 0, 0, 0, time.UTC))
+	client.SetClock(clock)
+
+	if _, err := client.GetAccountQuota(context.Background(), "identity", "test-access-token", "own-project", DebounceMaxAge, nil); err != nil {
+		t.Fatalf("GetAccountQuota failed: %v", err)
+	}
+	for i := 0; i < MaxCachedQuotas+1; i++ {
+		clock.Advance(time.Second)
+		if _, err := client.GetProjectQuota(context.Background(), "identity", "test-access-token", fmt.Sprintf("project-%d", i), DebounceMaxAge, nil); err != nil {
+			t.Fatalf("GetProjectQuota failed: %v", err)
+		}
+	}
+
+	// The evicted project is removed from Redis too, not left to its TTL
+	if server.Exists(redisKeyPrefix + quotaCacheKey("identity", "project-0")) {
+		t.Errorf("Expected the evicted project's Redis key to be deleted")
+	}
+	if !server.Exists(redisKeyPrefix + quotaCacheKey("identity", fmt.Sprintf("project-%d", MaxCachedQuotas))) {
+		t.Errorf("Expected the latest project's Redis key to be kept")
+	}
+	if !server.Exists(redisKeyPrefix + quotaCacheKey("identity", "own-project")) {
+		t.Errorf("Expected the account's own project Redis key to be kept")
+	}
+	if got := len(server.Keys()); got != MaxCachedQuotas {
+		t.Errorf("Expected %d Redis keys, got %d", MaxCachedQuotas, got)
+	}
+
+	// /debug/cache still reports the account's own project as cached and fresh
+	state := client.CacheState()
+	if !state.Cached || !state.Valid {
+		t.Errorf("Expected the account's quota cached and valid after eviction, got %+v", state)
+	}
+	if state.Backend != "redis" {
+		t.Errorf("Expected backend redis, got %q", state.Backend)
+	}
+}
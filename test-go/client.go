@@ -2,14 +2,32 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
 // Account represents the account structure
@@ -44,6 +62,15 @@ type TokenResponse struct {
 // QuotaResponse represents the API response structure
 type QuotaResponse struct {
 	Models map[string]ModelInfo `json:"models"`
+
+	// Stale is set when a cached response is served because a fresh fetch failed
+	Stale bool `json:"-"`
+
+	// Forbidden is set on the placeholder shown when upstream refuses the account
+	Forbidden bool `json:"-"`
+
+	// FetchedAt is when the response came from upstream, zero if unknown
+	FetchedAt time.Time `json:"-"`
 }
 
 // ModelInfo represents model information
@@ -57,12 +84,68 @@ type QuotaInfo struct {
 	ResetTime         string  `json:"resetTime"`
 }
 
+// Fraction returns the remaining quota as a 0-1 fraction. Upstream sometimes
+// sends a 0-100 percentage instead: every value is read as one when
+// fractionIsPercent is set, and otherwise any value above 1 is.
+func (q QuotaInfo) Fraction(fractionIsPercent bool) float64 {
+	if fractionIsPercent || q.RemainingFraction > 1 {
+		return q.RemainingFraction / 100
+	}
+	return q.RemainingFraction
+}
+
+// percentFractionModels returns the sorted names of the models whose
+// remainingFraction is above 1, so it is read as a percentage by value
+func percentFractionModels(quota *QuotaResponse) []string {
+	var detected []string
+	for name, info := range quota.Models {
+		if info.QuotaInfo.RemainingFraction > 1 {
+			detected = append(detected, name)
+		}
+	}
+	sort.Strings(detected)
+	return detected
+}
+
+// UnmarshalJSON accepts resetTime as either a string or a numeric epoch
+func (q *QuotaInfo) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		RemainingFraction float64         `json:"remainingFraction"`
+		ResetTime         json.RawMessage `json:"resetTime"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	q.RemainingFraction = raw.RemainingFraction
+	q.ResetTime = ""
+	if len(raw.ResetTime) == 0 || string(raw.ResetTime) == "null" {
+		return nil
+	}
+	if raw.ResetTime[0] == '"' {
+		return json.Unmarshal(raw.ResetTime, &q.ResetTime)
+	}
+
+	var epoch json.Number
+	if err := json.Unmarshal(raw.ResetTime, &epoch); err != nil {
+		return fmt.Errorf("invalid resetTime: %w", err)
+	}
+	q.ResetTime = epoch.String()
+	return nil
+}
+
 // FormattedModel represents formatted model data
 type FormattedModel struct {
 	Name                string `json:"name"`
+	DisplayName         string `json:"display_name,omitempty"`
 	Percentage          int    `json:"percentage"`
 	ResetTime           string `json:"reset_time"`
+	ResetTimeUnix       int64  `json:"reset_time_unix"`
 	ResetTimeRelative   string `json:"reset_time_relative,omitempty"`
+	ResetTimeLocal      string `json:"reset_time_local,omitempty"`
+
+	// Set only when DECIMAL_PERCENT is enabled
+	PercentagePrecise *float64 `json:"percentage_precise,omitempty"`
 }
 
 // FormattedQuota represents formatted quota response
@@ -70,6 +153,102 @@ type FormattedQuota struct {
 	Models      []FormattedModel `json:"models"`
 	LastUpdated int64            `json:"last_updated"`
 	IsForbidden bool             `json:"is_forbidden"`
+	IsStale     bool             `json:"is_stale"`
+
+	// Alert is set when any model is below its alert threshold; Alerts names them
+	Alert  bool     `json:"alert"`
+	Alerts []string `json:"alerts"`
+}
+
+// APIError represents a non-200 response from the upstream API
+type APIError struct {
+	StatusCode int
+	Body       string
+
+	// Delay requested by the Retry-After header; zero when absent
+	RetryAfter time.Duration
+}
+
+// parseRetryAfter parses a Retry-After header given as delay seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API request failed: %d - %s", e.StatusCode, e.Body)
+}
+
+// TokenRefreshError is a non-200 response from the OAuth token endpoint
+type TokenRefreshError struct {
+	StatusCode  int
+	Code        string
+	Description string
+}
+
+// Error implements the error interface
+func (e *TokenRefreshError) Error() string {
+	if e.InvalidGrant() {
+		return "refresh token is invalid or revoked; re-authenticate the account to get a new one"
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("token refresh failed: %d - %s", e.StatusCode, e.Code)
+	}
+	return fmt.Sprintf("token refresh failed: %d", e.StatusCode)
+}
+
+// InvalidGrant reports whether the OAuth server rejected the refresh token itself,
+// which happens once it has been revoked or has expired
+func (e *TokenRefreshError) InvalidGrant() bool {
+	return e.Code == "invalid_grant"
+}
+
+// parseTokenRefreshError builds a TokenRefreshError from an OAuth error response
+// body such as {"error": "invalid_grant", "error_description": "..."}
+func parseTokenRefreshError(statusCode int, body []byte) *TokenRefreshError {
+	var oauthErr struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	json.Unmarshal(body, &oauthErr)
+	return &TokenRefreshError{StatusCode: statusCode, Code: oauthErr.Error, Description: oauthErr.ErrorDescription}
+}
+
+// redactPrefixLength is how many leading characters redact keeps
+const redactPrefixLength = 6
+
+// redact shortens a token or upstream response body to a short prefix for logging.
+// Strings too short to keep a prefix safely are fully masked.
+func redact(s string) string {
+	if s == "" {
+		return ""
+	}
+	if len(s) <= 2*redactPrefixLength {
+		return "..."
+	}
+	return s[:redactPrefixLength] + "..."
+}
+
+// redactError renders err for logging with any upstream response body redacted
+func redactError(err error) string {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Body != "" {
+		return strings.Replace(err.Error(), apiErr.Body, redact(apiErr.Body), 1)
+	}
+	return err.Error()
 }
 
 // ProjectResponse represents project API response
@@ -81,48 +260,439 @@ type ProjectResponse struct {
 type CloudCodeClient struct {
 	config     *Config
 	httpClient *http.Client
+	quotaCache Cache
+	fetchGroup singleflight.Group
+	stats      *Stats
+
+	// The last quota this process fetched per key, the stale fallback when the
+	// quota cache no longer has it
 	cache      map[string]interface{}
 	cacheMutex sync.RWMutex
 	cacheTime  time.Time
+
+	// Current time for token expiry, cache ages, and upstream throttling
+	clock Clock
+
+	// Cache key of the latest account-project fetch, which the compare samples belong to
+	historyKey string
+
+	// When each cache key was last served, so ClearCache can expire entries other
+	// replicas stored; bounded to MaxCachedQuotas
+	cacheKeys map[string]time.Time
+
+	// When the last upstream quota fetch started, for the MIN_UPSTREAM_INTERVAL_SECONDS floor
+	lastUpstreamFetch time.Time
+
+	// Upstream asked us to back off until then with a 429 Retry-After
+	cooldownUntil time.Time
+
+	// Upstream fetches so far, for sampling their log lines by LOG_FETCH_SAMPLE
+	fetchLogs atomic.Uint64
+
+	// Semaphore bounding concurrent quota and project ID requests to MAX_CONCURRENT_UPSTREAM
+	upstreamSlots chan struct{}
+
+	// The quota response replaced by the latest fetch, for burn-rate comparisons
+	prevCache     *QuotaResponse
+	prevCacheTime time.Time
+
+	// Smoothed burn rate per model in percent per hour, updated on each fetch
+	burnRates map[string]float64
+
+	// Posts to WEBHOOK_URL when tracked models drop below the threshold; nil when unset
+	notifier *WebhookNotifier
+
+	// Pushes metrics to PUSHGATEWAY_URL on each fresh fetch; nil when unset
+	pusher *PushgatewayPusher
+
+	// In-memory account JSON when loaded from the ACCOUNT_JSON env var
+	accountJSON  []byte
+	accountMutex sync.RWMutex
+
+	// How the last loaded account was wrapped, so saves write it back the same way
+	accountEncoding accountEncoding
+
+	// The refreshed account, kept in memory instead of the file under READ_ONLY or
+	// once a write has failed because the file is not writable
+	memoryAccount []byte
+	writesFailed  bool
+
+	// Guards token refresh and the account write that follows it
+	refreshMutex sync.Mutex
+
+	// Project ID resolved via loadCodeAssist when the account has none
+	projectID      string
+	projectIDMutex sync.RWMutex
+
+	// Upstream endpoints, tried in order with failover
+	apiURLs     *failoverURLs
+	projectURLs *failoverURLs
+}
+
+// failoverURLs is an ordered list of equivalent endpoints that remembers the last
+// one that answered
+type failoverURLs struct {
+	mu      sync.Mutex
+	urls    []string
+	current int
+}
+
+// newFailoverURLs parses a comma-separated list of URLs
+func newFailoverURLs(raw string) *failoverURLs {
+	var urls []string
+	for _, u := range strings.Split(raw, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return &failoverURLs{urls: urls}
+}
+
+// order returns the URLs starting from the last one that worked
+func (f *failoverURLs) order() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ordered := make([]string, 0, len(f.urls))
+	for i := range f.urls {
+		ordered = append(ordered, f.urls[(f.current+i)%len(f.urls)])
+	}
+	return ordered
+}
+
+// markWorking remembers url as the first to try next time
+func (f *failoverURLs) markWorking(url string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, u := range f.urls {
+		if u == url {
+			f.current = i
+			return
+		}
+	}
+}
+
+// Clock tells the current time; tests substitute a fake one
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the system clock
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// now returns the current time from the client's clock
+func (c *CloudCodeClient) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock.Now()
+}
+
+// SetClock replaces the client's clock, including the one the memory cache
+// expires entries by
+func (c *CloudCodeClient) SetClock(clock Clock) {
+	c.clock = clock
+	if memory, ok := c.quotaCache.(*MemoryCache); ok {
+		memory.clock = clock
+	}
 }
 
 // NewCloudCodeClient creates a new client
 func NewCloudCodeClient(config *Config) *CloudCodeClient {
+	httpClient := &http.Client{Timeout: httpTimeout(config), Transport: newTransport(config)}
 	return &CloudCodeClient{
-		config:     config,
-		httpClient: &http.Client{Timeout: 30 * time.Second},
-		cache:      make(map[string]interface{}),
+		config:        config,
+		httpClient:    httpClient,
+		notifier:      NewWebhookNotifier(config, httpClient),
+		pusher:        NewPushgatewayPusher(config, httpClient),
+		quotaCache:    NewCache(config),
+		cache:         make(map[string]interface{}),
+		cacheKeys:     make(map[string]time.Time),
+		burnRates:     make(map[string]float64),
+		stats:         NewStats(),
+		clock:         realClock{},
+		accountJSON:   []byte(config.AccountJSON),
+		upstreamSlots: make(chan struct{}, maxConcurrentUpstream(config)),
+		apiURLs:       newFailoverURLs(config.APIURL),
+		projectURLs:   newFailoverURLs(config.ProjectAPIURL),
 	}
 }
 
-// LoadAccount loads account from file
+// newTransport builds the upstream transport, routing through PROXY_URL when set
+// and otherwise honoring HTTPS_PROXY, HTTP_PROXY, and NO_PROXY. Its connection
+// pool is sized by the HTTP_MAX_IDLE_CONNS settings so concurrent requests and
+// the background refresher reuse connections to the same upstream host.
+func newTransport(config *Config) *http.Transport {
+	proxy := http.ProxyFromEnvironment
+	if config.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			slog.Warn("Ignoring invalid PROXY_URL, falling back to proxy environment variables", "proxy_url", redact(config.ProxyURL))
+		} else {
+			proxy = http.ProxyURL(proxyURL)
+		}
+	}
+
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          positiveOrDefault(config.HTTPMaxIdleConns, DefaultHTTPMaxIdleConns),
+		MaxIdleConnsPerHost:   positiveOrDefault(config.HTTPMaxIdleConnsPerHost, DefaultHTTPMaxIdleConnsPerHost),
+		IdleConnTimeout:       time.Duration(positiveOrDefault(config.HTTPIdleConnTimeoutSeconds, DefaultHTTPIdleConnTimeoutSeconds)) * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// positiveOrDefault returns value, or fallback when value is below 1
+func positiveOrDefault(value, fallback int) int {
+	if value < 1 {
+		return fallback
+	}
+	return value
+}
+
+// maxConcurrentUpstream returns the configured upstream concurrency cap, or the default when unset
+func maxConcurrentUpstream(config *Config) int {
+	if config.MaxConcurrentUpstream < 1 {
+		return DefaultMaxConcurrentUpstream
+	}
+	return config.MaxConcurrentUpstream
+}
+
+// acquireUpstream waits for a free upstream request slot until ctx is done and
+// returns the function that frees it. Clients built without a semaphore are unbounded.
+func (c *CloudCodeClient) acquireUpstream(ctx context.Context) (func(), error) {
+	if c.upstreamSlots == nil {
+		return func() {}, nil
+	}
+	select {
+	case c.upstreamSlots <- struct{}{}:
+		return func() { <-c.upstreamSlots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for an upstream request slot: %w", ctx.Err())
+	}
+}
+
+// httpTimeout returns the configured upstream request timeout
+func httpTimeout(config *Config) time.Duration {
+	if config.HTTPTimeoutSeconds <= 0 {
+		return DefaultHTTPTimeoutSeconds * time.Second
+	}
+	return time.Duration(config.HTTPTimeoutSeconds) * time.Second
+}
+
+// LoadAccount loads account from the ACCOUNT_JSON env var, falling back to the account file
 func (c *CloudCodeClient) LoadAccount() (*Account, error) {
-	data, err := os.ReadFile(c.config.AccountFile)
+	c.accountMutex.RLock()
+	data := c.accountJSON
+	if len(data) == 0 {
+		data = c.memoryAccount
+	}
+	c.accountMutex.RUnlock()
+
+	if len(data) == 0 {
+		var err error
+		data, err = os.ReadFile(c.config.AccountFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrAccountMissing, c.config.AccountFile)
+		}
+	}
+
+	decoded, encoding, err := decodeAccountData(data)
 	if err != nil {
-		return nil, fmt.Errorf("account file not found: %s", c.config.AccountFile)
+		return nil, fmt.Errorf("failed to parse account file: %v", err)
 	}
 
 	var account Account
-	if err := json.Unmarshal(data, &account); err != nil {
+	if err := json.Unmarshal(decoded, &account); err != nil {
 		return nil, fmt.Errorf("failed to parse account file: %v", err)
 	}
 
+	c.accountMutex.Lock()
+	c.accountEncoding = encoding
+	c.accountMutex.Unlock()
+
 	return &account, nil
 }
 
-// NormalizeAccount extracts token info from different account formats
-func (c *CloudCodeClient) NormalizeAccount(account *Account) (string, string, *int64, string) {
-	if account.Token != nil {
-		return account.Token.AccessToken, account.Token.RefreshToken, account.Token.ExpiryTimestamp, account.Token.ProjectID
+// accountEncoding is how an account blob is wrapped around its JSON
+type accountEncoding string
+
+const (
+	accountEncodingJSON       accountEncoding = "json"
+	accountEncodingBase64     accountEncoding = "base64"
+	accountEncodingGzip       accountEncoding = "gzip"
+	accountEncodingBase64Gzip accountEncoding = "base64+gzip"
+)
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decodeAccountData unwraps an account blob stored as raw JSON, base64 (of JSON
+// or of gzip), or gzip, trying them in that order, and reports which it was
+func decodeAccountData(data []byte) ([]byte, accountEncoding, error) {
+	trimmed := bytes.TrimSpace(data)
+	if json.Valid(trimmed) {
+		return data, accountEncodingJSON, nil
 	}
 
+	if decoded, err := base64.StdEncoding.DecodeString(string(trimmed)); err == nil {
+		if !bytes.HasPrefix(decoded, gzipMagic) {
+			return decoded, accountEncodingBase64, nil
+		}
+		plain, err := gunzip(decoded)
+		if err != nil {
+			return nil, "", fmt.Errorf("base64 account is not valid gzip: %w", err)
+		}
+		return plain, accountEncodingBase64Gzip, nil
+	}
+
+	if bytes.HasPrefix(data, gzipMagic) {
+		plain, err := gunzip(data)
+		if err != nil {
+			return nil, "", fmt.Errorf("gzip account is corrupt: %w", err)
+		}
+		return plain, accountEncodingGzip, nil
+	}
+
+	// Not wrapped; report the JSON syntax error
+	var account Account
+	return nil, "", json.Unmarshal(trimmed, &account)
+}
+
+// gunzip decompresses a complete gzip stream
+func gunzip(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// encodeAccountData wraps account JSON the way decodeAccountData found it
+func encodeAccountData(data []byte, encoding accountEncoding) ([]byte, error) {
+	switch encoding {
+	case accountEncodingBase64:
+		return []byte(base64.StdEncoding.EncodeToString(data)), nil
+	case accountEncodingGzip, accountEncodingBase64Gzip:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		if encoding == accountEncodingGzip {
+			return buf.Bytes(), nil
+		}
+		return []byte(base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+	default:
+		return data, nil
+	}
+}
+
+// ValidateAccount checks that the account carries an access and refresh token,
+// either nested under "token" or at the top level. An access token with no known
+// expiry is enough on its own; see assumesFreshToken.
+func (c *CloudCodeClient) ValidateAccount(account *Account) error {
+	accessToken, refreshToken, _, _ := c.NormalizeAccount(account)
+
+	var missing []string
+	if accessToken == "" {
+		missing = append(missing, "access_token")
+	}
+	if refreshToken == "" && !c.assumesFreshToken(account) {
+		missing = append(missing, "refresh_token")
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if account.Token == nil {
+		return fmt.Errorf("invalid account: missing %s (no nested \"token\" object and no top-level token fields)", strings.Join(missing, " and "))
+	}
+	return fmt.Errorf("invalid account: missing %s in both \"token\" and top-level fields", strings.Join(missing, " and "))
+}
+
+// assumesFreshToken reports whether the account is an access token with no known
+// expiry and no refresh token. Such a token is used as-is, and only a 401 from
+// upstream rejects it, since there is nothing to refresh it with.
+func (c *CloudCodeClient) assumesFreshToken(account *Account) bool {
+	accessToken, refreshToken, expiryTimestamp, _ := c.NormalizeAccount(account)
+	return accessToken != "" && refreshToken == "" && expiryTimestamp == nil
+}
+
+// rejectedTokenError reports a 401 for an account whose token is assumed fresh as
+// needing re-authentication; any other error is returned unchanged
+func (c *CloudCodeClient) rejectedTokenError(account *Account, err error) error {
+	if err == nil || !c.assumesFreshToken(account) || toAppError(err).Status != http.StatusUnauthorized {
+		return err
+	}
+	return newAppError(http.StatusUnauthorized, CodeReauthRequired,
+		"access token was rejected and the account has no refresh_token to renew it; re-authenticate")
+}
+
+// HasAccount reports whether account credentials are configured
+func (c *CloudCodeClient) HasAccount() bool {
+	c.accountMutex.RLock()
+	hasJSON := len(c.accountJSON) > 0
+	c.accountMutex.RUnlock()
+
+	if hasJSON {
+		return true
+	}
+	_, err := os.Stat(c.config.AccountFile)
+	return err == nil
+}
+
+// NormalizeAccount extracts token info from different account formats.
+// Nested token fields take precedence, falling back field-by-field to top-level ones.
+func (c *CloudCodeClient) NormalizeAccount(account *Account) (string, string, *int64, string) {
+	accessToken := account.AccessToken
+	refreshToken := account.RefreshToken
+	projectID := account.ProjectID
+
 	var expiryTimestamp *int64
 	if account.Timestamp != nil && account.ExpiresIn > 0 {
 		expiry := (*account.Timestamp / 1000) + int64(account.ExpiresIn)
 		expiryTimestamp = &expiry
 	}
 
-	return account.AccessToken, account.RefreshToken, expiryTimestamp, account.ProjectID
+	if token := account.Token; token != nil {
+		if token.AccessToken != "" {
+			accessToken = token.AccessToken
+		}
+		if token.RefreshToken != "" {
+			refreshToken = token.RefreshToken
+		}
+		if token.ProjectID != "" {
+			projectID = token.ProjectID
+		}
+		if token.ExpiryTimestamp != nil {
+			expiry := normalizeEpochSeconds(*token.ExpiryTimestamp)
+			expiryTimestamp = &expiry
+		}
+	}
+
+	return accessToken, refreshToken, expiryTimestamp, projectID
+}
+
+// normalizeEpochSeconds converts millisecond-scale epoch timestamps to seconds
+func normalizeEpochSeconds(timestamp int64) int64 {
+	if timestamp > MillisecondTimestampThreshold {
+		return timestamp / 1000
+	}
+	return timestamp
 }
 
 // RefreshAccessToken refreshes the access token
@@ -142,7 +712,8 @@ func (c *CloudCodeClient) RefreshAccessToken(refreshToken string) (*TokenRespons
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("token refresh failed: %d", resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		return nil, parseTokenRefreshError(resp.StatusCode, body)
 	}
 
 	var tokenResp TokenResponse
@@ -155,23 +726,104 @@ func (c *CloudCodeClient) RefreshAccessToken(refreshToken string) (*TokenRespons
 
 // EnsureFreshToken checks token expiry and refreshes if needed
 func (c *CloudCodeClient) EnsureFreshToken(account *Account) (string, error) {
+	return c.ensureFreshToken(account, true)
+}
+
+// EnsureFreshTokenInMemory is EnsureFreshToken for an account the server does not
+// own, such as one posted to /quota/query; a refreshed token is never saved
+func (c *CloudCodeClient) EnsureFreshTokenInMemory(account *Account) (string, error) {
+	return c.ensureFreshToken(account, false)
+}
+
+// ensureFreshToken refreshes the account's token when it is due, saving the
+// refreshed account when save is set
+func (c *CloudCodeClient) ensureFreshToken(account *Account, save bool) (string, error) {
 	accessToken, refreshToken, expiryTimestamp, _ := c.NormalizeAccount(account)
 
+	if c.assumesFreshToken(account) {
+		slog.Debug("Token expiry unknown and no refresh token, using the access token as-is")
+		return accessToken, nil
+	}
 	if accessToken == "" || refreshToken == "" {
 		return "", fmt.Errorf("missing access_token or refresh_token")
 	}
 
-	now := time.Now().Unix()
-	if expiryTimestamp != nil && *expiryTimestamp > now+TokenRefreshBufferSeconds {
-		log.Println("Token is fresh, no need to refresh")
+	if !tokenNeedsRefresh(expiryTimestamp, c.now().Unix()) {
+		slog.Debug("Token is fresh, no need to refresh", "expires_at", time.Unix(*expiryTimestamp, 0).UTC().Format(time.RFC3339))
 		return accessToken, nil
 	}
 
+	return c.refreshToken(account, save)
+}
+
+// refreshToken refreshes the account's token regardless of its expiry, saving
+// the refreshed account when save is set, and returns the new access token
+func (c *CloudCodeClient) refreshToken(account *Account, save bool) (string, error) {
+	if !save {
+		newExpiry, err := c.refreshAccount(account)
+		if err != nil {
+			return "", err
+		}
+		slog.Info("Access token refreshed", "expires_at", time.Unix(newExpiry, 0).Format(time.RFC3339), "saved", false)
+		return account.AccessToken, nil
+	}
+
+	// Serialize refresh-and-save so concurrent callers never interleave writes
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+
+	newExpiry, err := c.refreshAccount(account)
+	if err != nil {
+		return "", err
+	}
+
+	// Save updated account
+	expiryTime := time.Unix(newExpiry, 0)
+	if err := c.saveAccount(account); err != nil {
+		slog.Error("Failed to save refreshed token", "error", err)
+	} else {
+		slog.Info("Access token refreshed", "expires_at", expiryTime.Format(time.RFC3339))
+	}
+
+	return account.AccessToken, nil
+}
+
+// TokenRefresher refreshes an account's token and returns the new access token,
+// for retrying a quota request whose token upstream rejected
+type TokenRefresher func() (string, error)
+
+// tokenRefresher returns a TokenRefresher for the account, saving the refreshed
+// account when save is set, or nil when it has no refresh token to refresh with
+func (c *CloudCodeClient) tokenRefresher(account *Account, save bool) TokenRefresher {
+	if _, refreshToken, _, _ := c.NormalizeAccount(account); refreshToken == "" {
+		return nil
+	}
+	return func() (string, error) {
+		return c.refreshToken(account, save)
+	}
+}
+
+// tokenNeedsRefresh reports whether a token with the given expiry is due for
+// refresh; an unknown expiry always is
+func tokenNeedsRefresh(expiryTimestamp *int64, now int64) bool {
+	return expiryTimestamp == nil || *expiryTimestamp <= now+TokenRefreshBufferSeconds
+}
+
+// refreshAccount exchanges the account's refresh token for a new access token and
+// updates the account in memory, returning the new expiry. Nothing is saved.
+func (c *CloudCodeClient) refreshAccount(account *Account) (int64, error) {
+	accessToken, refreshToken, _, _ := c.NormalizeAccount(account)
+	if accessToken == "" || refreshToken == "" {
+		return 0, fmt.Errorf("missing access_token or refresh_token")
+	}
+
 	// Token needs refresh
-	log.Println("Token needs refresh")
+	slog.Info("Token needs refresh")
+	now := c.now().Unix()
 	newToken, err := c.RefreshAccessToken(refreshToken)
 	if err != nil {
-		return "", err
+		slog.Error("Token refresh failed", "error", redactError(err))
+		return 0, err
 	}
 
 	newExpiry := now + int64(newToken.ExpiresIn)
@@ -191,119 +843,1051 @@ func (c *CloudCodeClient) EnsureFreshToken(account *Account) (string, error) {
 	}
 
 	// Update top-level fields
-	expiryTime := time.Unix(newExpiry, 0)
 	account.AccessToken = newToken.AccessToken
-	account.Expired = expiryTime.Format(time.RFC3339)
+	account.Expired = time.Unix(newExpiry, 0).Format(time.RFC3339)
 
-	// Save updated account
-	if err := c.saveAccount(account); err != nil {
-		log.Printf("Failed to save refreshed token: %v", err)
-	} else {
-		log.Printf("Access token refreshed, expires at %s", expiryTime.Format(time.RFC3339))
-	}
+	return newExpiry, nil
+}
 
-	return newToken.AccessToken, nil
+// TokenDiagnosis reports the state of the account's access token
+type TokenDiagnosis struct {
+	Fresh            bool   `json:"fresh"`
+	ExpiresAt        string `json:"expires_at,omitempty"`
+	RefreshAttempted bool   `json:"refresh_attempted"`
+	RefreshSucceeded bool   `json:"refresh_succeeded"`
+	RefreshError     string `json:"refresh_error,omitempty"`
+	NewExpiresAt     string `json:"new_expires_at,omitempty"`
 }
 
-// saveAccount saves account to file
-func (c *CloudCodeClient) saveAccount(account *Account) error {
-	data, err := json.MarshalIndent(account, "", "  ")
-	if err != nil {
-		return err
+// DiagnoseToken checks whether the account's token is fresh and, if not, tries a
+// refresh without saving the result
+func (c *CloudCodeClient) DiagnoseToken(account *Account) TokenDiagnosis {
+	_, _, expiryTimestamp, _ := c.NormalizeAccount(account)
+
+	var diagnosis TokenDiagnosis
+	if expiryTimestamp != nil {
+		diagnosis.ExpiresAt = time.Unix(*expiryTimestamp, 0).UTC().Format(time.RFC3339)
+	}
+	if !tokenNeedsRefresh(expiryTimestamp, c.now().Unix()) {
+		diagnosis.Fresh = true
+		return diagnosis
 	}
-	return os.WriteFile(c.config.AccountFile, data, 0600)
-}
 
-// GetProjectID fetches project ID from API
-func (c *CloudCodeClient) GetProjectID(accessToken string) (string, error) {
-	payload := map[string]interface{}{
-		"metadata": map[string]string{
-			"ideType": "ANTIGRAVITY",
-		},
+	// Refresh a copy so the caller's account is left untouched
+	scratch := *account
+	if account.Token != nil {
+		token := *account.Token
+		scratch.Token = &token
 	}
 
-	jsonData, _ := json.Marshal(payload)
-	req, err := http.NewRequest("POST", c.config.ProjectAPIURL, bytes.NewBuffer(jsonData))
+	diagnosis.RefreshAttempted = true
+	newExpiry, err := c.refreshAccount(&scratch)
 	if err != nil {
-		return "", err
+		diagnosis.RefreshError = err.Error()
+		return diagnosis
 	}
 
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("User-Agent", c.config.UserAgent)
-	req.Header.Set("Content-Type", "application/json")
+	diagnosis.RefreshSucceeded = true
+	diagnosis.NewExpiresAt = time.Unix(newExpiry, 0).UTC().Format(time.RFC3339)
+	return diagnosis
+}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
+// AccountSummary describes a loaded account without exposing its tokens
+type AccountSummary struct {
+	Source          string `json:"source"`
+	Format          string `json:"format"`
+	Valid           bool   `json:"valid"`
+	ValidationError string `json:"validation_error,omitempty"`
+	AccessToken     string `json:"access_token"`
+	RefreshToken    string `json:"refresh_token"`
+	ProjectID       string `json:"project_id,omitempty"`
+	HasProjectID    bool   `json:"has_project_id"`
+	ExpiresAt       string `json:"expires_at,omitempty"`
+	Expired         bool   `json:"expired"`
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to get project ID: %d", resp.StatusCode)
+// accountFormat names the token layout of an account: "nested" when the token
+// object holds both tokens, "top-level" without a token object, and "mixed"
+// when some fields fall back from the token object to the top level
+func accountFormat(account *Account) string {
+	switch {
+	case account.Token == nil:
+		return "top-level"
+	case account.Token.AccessToken != "" && account.Token.RefreshToken != "":
+		return "nested"
+	default:
+		return "mixed"
 	}
+}
 
-	var projectResp ProjectResponse
-	if err := json.NewDecoder(resp.Body).Decode(&projectResp); err != nil {
-		return "", err
+// DescribeAccount validates the account and summarizes how it was parsed, with tokens redacted
+func (c *CloudCodeClient) DescribeAccount(account *Account) AccountSummary {
+	accessToken, refreshToken, expiryTimestamp, projectID := c.NormalizeAccount(account)
+
+	c.accountMutex.RLock()
+	source := c.config.AccountFile
+	if len(c.accountJSON) > 0 {
+		source = "ACCOUNT_JSON"
 	}
+	c.accountMutex.RUnlock()
 
-	return projectResp.CloudAICompanionProject, nil
+	summary := AccountSummary{
+		Source:       source,
+		Format:       accountFormat(account),
+		Valid:        true,
+		AccessToken:  redact(accessToken),
+		RefreshToken: redact(refreshToken),
+		ProjectID:    projectID,
+		HasProjectID: projectID != "",
+	}
+	if err := c.ValidateAccount(account); err != nil {
+		summary.Valid = false
+		summary.ValidationError = err.Error()
+	}
+	if expiryTimestamp != nil {
+		summary.ExpiresAt = time.Unix(*expiryTimestamp, 0).UTC().Format(time.RFC3339)
+		summary.Expired = *expiryTimestamp <= c.now().Unix()
+	}
+	return summary
 }
 
-// GetQuota fetches quota information with caching
+// saveAccount saves account to file, or to the in-memory copy when loaded from
+// ACCOUNT_JSON. Under READ_ONLY, or once the file has proven unwritable, it only
+// updates the in-memory copy.
+func (c *CloudCodeClient) saveAccount(account *Account) error {
+	data, err := json.MarshalIndent(account, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Keep the wrapping the account was loaded with
+	c.accountMutex.RLock()
+	encoding := c.accountEncoding
+	c.accountMutex.RUnlock()
+	data, err = encodeAccountData(data, encoding)
+	if err != nil {
+		return err
+	}
+
+	c.accountMutex.Lock()
+	fromEnv := len(c.accountJSON) > 0
+	if fromEnv {
+		c.accountJSON = data
+	}
+	skipWrite := c.config.ReadOnly || c.writesFailed
+	if skipWrite && !fromEnv {
+		c.memoryAccount = data
+	}
+	c.accountMutex.Unlock()
+
+	path := c.config.AccountFile
+	if fromEnv {
+		path = c.config.RefreshWritePath
+	}
+	if skipWrite || path == "" {
+		return nil
+	}
+
+	err = writeFileAtomic(path, data, 0600)
+	if isNotWritable(err) {
+		// Stop retrying the write on every refresh; this process keeps the new tokens
+		c.accountMutex.Lock()
+		c.writesFailed = true
+		if !fromEnv {
+			c.memoryAccount = data
+		}
+		c.accountMutex.Unlock()
+		slog.Warn("Account file is not writable, keeping refreshed tokens in memory", "path", path, "error", err)
+		return nil
+	}
+	return err
+}
+
+// isNotWritable reports whether a write failed because of permissions or a
+// read-only filesystem
+func isNotWritable(err error) bool {
+	return errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.EROFS)
+}
+
+// writeFileAtomic writes data to a temp file in the target's directory and renames
+// it over the target, so readers never observe a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// postWithFailover POSTs body to each endpoint in turn, moving on after a network
+// error or 5xx. The first other response is returned and its endpoint remembered;
+// if every endpoint fails, the last error or 5xx response is returned.
+func (c *CloudCodeClient) postWithFailover(ctx context.Context, endpoints *failoverURLs, accessToken string, body []byte) (*http.Response, error) {
+	urls := endpoints.order()
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no upstream URL configured")
+	}
+
+	var lastErr error
+	for i, endpoint := range urls {
+		req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		req.Header.Set("User-Agent", c.config.UserAgent)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := c.httpClient.Do(req)
+		last := i == len(urls)-1
+		switch {
+		case err != nil:
+			lastErr = err
+		case resp.StatusCode >= http.StatusInternalServerError && !last:
+			resp.Body.Close()
+			lastErr = fmt.Errorf("upstream returned %d", resp.StatusCode)
+		default:
+			if resp.StatusCode < http.StatusInternalServerError {
+				endpoints.markWorking(endpoint)
+			}
+			return resp, nil
+		}
+
+		if !last {
+			slog.Warn("Upstream endpoint failed, trying next", "url", endpoint, "error", redactError(lastErr))
+		}
+	}
+
+	return nil, lastErr
+}
+
+// GetProjectID fetches project ID from API
+func (c *CloudCodeClient) GetProjectID(accessToken string) (string, error) {
+	payload := map[string]interface{}{
+		"metadata": map[string]string{
+			"ideType": firstNonEmpty(c.config.IDEType, DefaultIDEType),
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpTimeout(c.config))
+	defer cancel()
+
+	release, err := c.acquireUpstream(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	jsonData, _ := json.Marshal(payload)
+	resp, err := c.postWithFailover(ctx, c.projectURLs, accessToken, jsonData)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get project ID: %d", resp.StatusCode)
+	}
+
+	var projectResp ProjectResponse
+	if err := json.NewDecoder(resp.Body).Decode(&projectResp); err != nil {
+		return "", err
+	}
+
+	return projectResp.CloudAICompanionProject, nil
+}
+
+// ResolveProjectID returns the cached project ID, looking it up via GetProjectID
+// on first use. Failed lookups are not cached and yield an empty ID.
+func (c *CloudCodeClient) ResolveProjectID(accessToken string) string {
+	projectID, _, err := c.resolveProjectID(accessToken)
+	if err != nil {
+		slog.Warn("Failed to resolve project ID", "error", redactError(err))
+	}
+	return projectID
+}
+
+// resolveProjectID is ResolveProjectID, also reporting whether the ID came from
+// the cache or a live lookup and returning the lookup's error
+func (c *CloudCodeClient) resolveProjectID(accessToken string) (string, string, error) {
+	c.projectIDMutex.RLock()
+	projectID := c.projectID
+	c.projectIDMutex.RUnlock()
+
+	if projectID != "" {
+		return projectID, ProjectSourceCache, nil
+	}
+
+	projectID, err := c.GetProjectID(accessToken)
+	if err != nil || projectID == "" {
+		return "", ProjectSourceAPI, err
+	}
+
+	c.projectIDMutex.Lock()
+	c.projectID = projectID
+	c.projectIDMutex.Unlock()
+	return projectID, ProjectSourceAPI, nil
+}
+
+// Where the project ID queried for the account comes from
+const (
+	ProjectSourceAccount = "account"
+	ProjectSourceCache   = "cache"
+	ProjectSourceAPI     = "api"
+)
+
+// ProjectResolution reports the project ID queried for the account and its source
+type ProjectResolution struct {
+	ProjectID string `json:"project_id"`
+	Source    string `json:"source"`
+}
+
+// DiagnoseProject resolves the account's project ID the way quota requests do:
+// the account's own ID, else the cached one, else a live loadCodeAssist lookup
+// whose result is cached
+func (c *CloudCodeClient) DiagnoseProject(account *Account) (ProjectResolution, error) {
+	if _, _, _, projectID := c.NormalizeAccount(account); projectID != "" {
+		return ProjectResolution{ProjectID: projectID, Source: ProjectSourceAccount}, nil
+	}
+
+	accessToken, err := c.EnsureFreshToken(account)
+	if err != nil {
+		return ProjectResolution{}, err
+	}
+
+	projectID, source, err := c.resolveProjectID(accessToken)
+	if err != nil {
+		return ProjectResolution{}, err
+	}
+	return ProjectResolution{ProjectID: projectID, Source: source}, nil
+}
+
+// lookupProjectID fetches the project ID without caching it, logging a failed
+// lookup and yielding an empty ID
+func (c *CloudCodeClient) lookupProjectID(accessToken string) string {
+	projectID, err := c.GetProjectID(accessToken)
+	if err != nil {
+		slog.Warn("Failed to resolve project ID", "error", redactError(err))
+		return ""
+	}
+	return projectID
+}
+
+// ClearProjectID drops the cached project ID so the next request looks it up again
+func (c *CloudCodeClient) ClearProjectID() {
+	c.projectIDMutex.Lock()
+	c.projectID = ""
+	c.projectIDMutex.Unlock()
+}
+
+// quotaCacheKey returns the cache key for an account identity and project ID. Both
+// are hashed so swapping accounts or projects never serves another one's quota,
+// and no token ends up in a shared cache.
+func quotaCacheKey(identity, projectID string) string {
+	sum := sha256.Sum256([]byte(identity + "\x00" + projectID))
+	return "quota:" + hex.EncodeToString(sum[:8])
+}
+
+// accountIdentity identifies an account across token refreshes by its refresh
+// token, falling back to the access token
+func (c *CloudCodeClient) accountIdentity(account *Account) string {
+	accessToken, refreshToken, _, _ := c.NormalizeAccount(account)
+	return firstNonEmpty(refreshToken, accessToken)
+}
+
+// DebounceMaxAge asks for quota cached for the QUERY_DEBOUNCE window, rather
+// than within a caller-chosen maximum age
+const DebounceMaxAge time.Duration = -1
+
+// GetQuota fetches quota information with caching, using the access token as the account identity
 func (c *CloudCodeClient) GetQuota(accessToken, projectID string) (*QuotaResponse, error) {
-	cacheKey := "quota"
+	return c.GetAccountQuota(context.Background(), accessToken, accessToken, projectID, DebounceMaxAge, nil)
+}
+
+// GetAccountQuota fetches quota for the account's own project with caching. Its
+// fetches feed the compare history and burn rates. A non-nil refresh is called
+// once when upstream rejects the token with a 401, and the fetch retried.
+func (c *CloudCodeClient) GetAccountQuota(ctx context.Context, identity, accessToken, projectID string, maxAge time.Duration, refresh TokenRefresher) (*QuotaResponse, error) {
+	return c.getQuota(ctx, quotaCacheKey(identity, projectID), accessToken, projectID, true, maxAge, refresh)
+}
+
+// GetProjectQuota fetches quota for an explicitly chosen project, cached separately
+// from the account's own project so switching between them does not refetch
+func (c *CloudCodeClient) GetProjectQuota(ctx context.Context, identity, accessToken, projectID string, maxAge time.Duration, refresh TokenRefresher) (*QuotaResponse, error) {
+	return c.getQuota(ctx, quotaCacheKey(identity, projectID), accessToken, projectID, false, maxAge, refresh)
+}
+
+// getQuota returns the quota cached under cacheKey, fetching it when expired.
+// Fetches with history set update the compare samples and burn rates. A maxAge
+// other than DebounceMaxAge replaces the debounce window for this call; the
+// MIN_UPSTREAM_INTERVAL_SECONDS floor still applies. The caller stops waiting
+// when ctx is done, and a shared fetch's retries end by its deadline.
+func (c *CloudCodeClient) getQuota(ctx context.Context, cacheKey, accessToken, projectID string, history bool, maxAge time.Duration, refresh TokenRefresher) (*QuotaResponse, error) {
+	getCached := c.getCachedQuota
+	if maxAge != DebounceMaxAge {
+		getCached = func(cacheKey string) (*QuotaResponse, bool) {
+			return c.getQuotaWithin(cacheKey, maxAge)
+		}
+	}
 
 	// Check cache
+	if cached, ok := getCached(cacheKey); ok {
+		c.touchCacheKey(cacheKey)
+		return cached, nil
+	}
+
+	// Collapse concurrent cache misses into a single upstream fetch
+	flight := c.fetchGroup.DoChan(cacheKey, func() (interface{}, error) {
+		if cached, ok := getCached(cacheKey); ok {
+			return cached, nil
+		}
+		if cached, ok := c.getThrottledQuota(cacheKey); ok {
+			return cached, nil
+		}
+		if stale, ok := c.getCooldownQuota(cacheKey); ok {
+			return stale, nil
+		}
+		// The fetch outlives the caller that started it, since others may be waiting on it
+		fetchCtx, cancel := detachedContext(ctx)
+		defer cancel()
+		return c.fetchQuotaWithRefresh(fetchCtx, cacheKey, accessToken, projectID, history, refresh)
+	})
+
+	var result singleflight.Result
+	select {
+	case result = <-flight:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for quota: %w", ctx.Err())
+	}
+	if err := result.Err; err != nil {
+		if stale, ok := c.getStaleQuota(cacheKey); ok && isTransientError(err) {
+			slog.Warn("Failed to fetch fresh quota, serving stale data", "error", redactError(err))
+			return stale, nil
+		}
+		return nil, err
+	}
+
+	c.touchCacheKey(cacheKey)
+	return result.Val.(*QuotaResponse), nil
+}
+
+// detachedContext returns a context with ctx's deadline that is not cancelled along with it
+func detachedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	detached := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(detached, deadline)
+	}
+	return context.WithCancel(detached)
+}
+
+// touchCacheKey records cacheKey as just served. Only keys that produced quota
+// are recorded, and beyond MaxCachedQuotas the least recently served one is
+// dropped along with its cached response. The account's own project is kept.
+func (c *CloudCodeClient) touchCacheKey(cacheKey string) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	c.cacheKeys[cacheKey] = c.now()
+	for len(c.cacheKeys) > MaxCachedQuotas {
+		oldest := ""
+		for key, served := range c.cacheKeys {
+			if key == cacheKey || key == c.historyKey {
+				continue
+			}
+			if oldest == "" || served.Before(c.cacheKeys[oldest]) {
+				oldest = key
+			}
+		}
+		if oldest == "" {
+			return
+		}
+
+		delete(c.cacheKeys, oldest)
+		delete(c.cache, oldest)
+		if err := c.quotaCache.Delete(oldest); err != nil {
+			slog.Warn("Failed to evict quota cache", "key", oldest, "error", err)
+		}
+	}
+}
+
+// ClearCache expires the cached quota for every project so the next GetQuota fetches
+// from upstream. The cached responses are kept as the stale fallback and compare sample.
+func (c *CloudCodeClient) ClearCache() {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	for key := range c.cacheKeys {
+		if err := c.quotaCache.Delete(key); err != nil {
+			slog.Warn("Failed to clear quota cache", "key", key, "error", err)
+		}
+	}
+
+	c.cacheTime = time.Time{}
+}
+
+// CacheTTL returns how long the cached quota stays fresh, or 0 if expired or empty
+func (c *CloudCodeClient) CacheTTL() time.Duration {
+	c.cacheMutex.RLock()
+	defer c.cacheMutex.RUnlock()
+
+	if _, exists := c.cache[c.historyKey]; !exists {
+		return 0
+	}
+	remaining := time.Duration(c.config.QueryDebounce)*time.Minute - c.now().Sub(c.cacheTime)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// CacheState describes the account's cached quota and whether the next request
+// is served from it
+type CacheState struct {
+	Backend         string `json:"backend"`
+	Cached          bool   `json:"cached"`
+	Valid           bool   `json:"valid"`
+	AgeSeconds      *int64 `json:"age_seconds"`
+	TTLSeconds      int64  `json:"ttl_seconds"`
+	DebounceSeconds int64  `json:"debounce_seconds"`
+	LastUpdated     *int64 `json:"last_updated"`
+}
+
+// CacheState reports the state of the account's cached quota. It is valid while
+// younger than QUERY_DEBOUNCE; ClearCache invalidates it early.
+func (c *CloudCodeClient) CacheState() CacheState {
+	debounce := time.Duration(c.config.QueryDebounce) * time.Minute
+	state := CacheState{
+		Backend:         firstNonEmpty(c.config.CacheBackend, "memory"),
+		DebounceSeconds: int64(debounce.Seconds()),
+	}
+
+	c.cacheMutex.RLock()
+	_, state.Cached = c.cache[c.historyKey]
+	cacheTime := c.cacheTime
+	c.cacheMutex.RUnlock()
+
+	if !state.Cached || cacheTime.IsZero() {
+		return state
+	}
+	age := c.now().Sub(cacheTime)
+	ageSeconds := int64(age.Seconds())
+	lastUpdated := cacheTime.Unix()
+	state.AgeSeconds = &ageSeconds
+	state.LastUpdated = &lastUpdated
+	if remaining := debounce - age; remaining > 0 {
+		state.Valid = true
+		state.TTLSeconds = int64(remaining.Seconds())
+	}
+	return state
+}
+
+// QuotaSample is a fetched quota response and the time it was fetched
+type QuotaSample struct {
+	Quota     *QuotaResponse
+	FetchedAt time.Time
+}
+
+// QuotaHistory returns the latest and previous fetched quota samples.
+// Either is nil until enough fetches have happened.
+func (c *CloudCodeClient) QuotaHistory() (latest, previous *QuotaSample) {
+	c.cacheMutex.RLock()
+	defer c.cacheMutex.RUnlock()
+
+	if cached, exists := c.cache[c.historyKey]; exists {
+		latest = &QuotaSample{Quota: cached.(*QuotaResponse), FetchedAt: c.cacheTime}
+	}
+	if c.prevCache != nil {
+		previous = &QuotaSample{Quota: c.prevCache, FetchedAt: c.prevCacheTime}
+	}
+	return latest, previous
+}
+
+// BurnRates returns a copy of the smoothed per-model burn rates in percent per hour
+func (c *CloudCodeClient) BurnRates() map[string]float64 {
 	c.cacheMutex.RLock()
-	if cached, exists := c.cache[cacheKey]; exists {
-		if time.Since(c.cacheTime) < time.Duration(c.config.QueryDebounce)*time.Minute {
-			c.cacheMutex.RUnlock()
-			log.Println("Returning cached quota data")
-			return cached.(*QuotaResponse), nil
+	defer c.cacheMutex.RUnlock()
+
+	rates := make(map[string]float64, len(c.burnRates))
+	for name, rate := range c.burnRates {
+		rates[name] = rate
+	}
+	return rates
+}
+
+// burnEMAAlpha returns the configured burn-rate smoothing factor, or the default when unset
+func burnEMAAlpha(config *Config) float64 {
+	if config.BurnEMAAlpha <= 0 || config.BurnEMAAlpha > 1 {
+		return DefaultBurnEMAAlpha
+	}
+	return config.BurnEMAAlpha
+}
+
+// updateBurnRates folds the burn rate between two samples into each model's moving average.
+// A rising percentage means the quota reset, so that model's average starts over.
+func updateBurnRates(rates map[string]float64, previous, current *QuotaResponse, elapsed time.Duration, alpha float64, fractionIsPercent bool) {
+	if elapsed <= 0 {
+		return
+	}
+	for name, info := range current.Models {
+		prevInfo, ok := previous.Models[name]
+		if !ok {
+			continue
+		}
+		rate := (prevInfo.QuotaInfo.Fraction(fractionIsPercent) - info.QuotaInfo.Fraction(fractionIsPercent)) * 100 / elapsed.Hours()
+		if rate < 0 {
+			delete(rates, name)
+			continue
 		}
+		if ema, ok := rates[name]; ok {
+			rates[name] = alpha*rate + (1-alpha)*ema
+		} else {
+			rates[name] = rate
+		}
+	}
+}
+
+// getStaleQuota returns a copy of the last fetched quota marked stale, regardless of age
+func (c *CloudCodeClient) getStaleQuota(cacheKey string) (*QuotaResponse, bool) {
+	cached, ok := c.getLastQuota(cacheKey)
+	if !ok {
+		return nil, false
+	}
+
+	stale := *cached
+	stale.Stale = true
+	return &stale, true
+}
+
+// getLastQuota returns the last quota fetched under cacheKey regardless of age:
+// the quota cache's entry, which another replica may have fetched, or else this
+// process's own
+func (c *CloudCodeClient) getLastQuota(cacheKey string) (*QuotaResponse, bool) {
+	if cached, ok := c.quotaCache.Get(cacheKey); ok {
+		return cached, true
+	}
+
+	c.cacheMutex.RLock()
+	defer c.cacheMutex.RUnlock()
+
+	cached, exists := c.cache[cacheKey]
+	if !exists {
+		return nil, false
+	}
+	return cached.(*QuotaResponse), true
+}
+
+// isTransientError reports whether a fetch error is worth masking with stale data.
+// Network failures, rate limits, and server errors qualify; auth errors do not.
+func isTransientError(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// getCachedQuota returns the cached quota if it is within the debounce window
+func (c *CloudCodeClient) getCachedQuota(cacheKey string) (*QuotaResponse, bool) {
+	cached, ok := c.quotaCache.Get(cacheKey)
+	if !ok || c.now().Sub(cached.FetchedAt) >= time.Duration(c.config.QueryDebounce)*time.Minute {
+		return nil, false
+	}
+
+	c.stats.RecordCacheHit()
+	c.logCacheHit("backend", firstNonEmpty(c.config.CacheBackend, "memory"))
+	return cached, true
+}
+
+// logCacheHit logs a quota cache hit when LOG_CACHE_HITS is set
+func (c *CloudCodeClient) logCacheHit(args ...any) {
+	if c.config.LogCacheHits {
+		slog.Info("Returning cached quota data", args...)
+	}
+}
+
+// sampleFetchLog reports whether this fetch writes its informational log lines:
+// one in every LOG_FETCH_SAMPLE fetches, starting with the first. Errors are
+// always logged.
+func (c *CloudCodeClient) sampleFetchLog() bool {
+	every := uint64(max(c.config.LogFetchSample, 1))
+	return (c.fetchLogs.Add(1)-1)%every == 0
+}
+
+// getQuotaWithin returns the cached quota if it is at most maxAge old, whether or
+// not the debounce window has expired
+func (c *CloudCodeClient) getQuotaWithin(cacheKey string, maxAge time.Duration) (*QuotaResponse, bool) {
+	cached, ok := c.quotaCache.Get(cacheKey)
+	if !ok || c.now().Sub(cached.FetchedAt) > maxAge {
+		return nil, false
+	}
+
+	c.stats.RecordCacheHit()
+	c.logCacheHit("max_age", maxAge)
+	return cached, true
+}
+
+// getThrottledQuota returns the last fetched quota regardless of age when the previous
+// upstream fetch started less than MinUpstreamIntervalSeconds ago. A cached quota
+// another replica fetched more recently counts as the previous fetch. With nothing
+// cached under cacheKey there is nothing to fall back on, so the fetch is allowed.
+func (c *CloudCodeClient) getThrottledQuota(cacheKey string) (*QuotaResponse, bool) {
+	interval := time.Duration(c.config.MinUpstreamIntervalSeconds) * time.Second
+	if interval <= 0 {
+		return nil, false
+	}
+
+	cached, ok := c.getLastQuota(cacheKey)
+	if !ok {
+		return nil, false
+	}
+
+	c.cacheMutex.RLock()
+	lastFetch := c.lastUpstreamFetch
+	c.cacheMutex.RUnlock()
+	if cached.FetchedAt.After(lastFetch) {
+		lastFetch = cached.FetchedAt
+	}
+	if c.now().Sub(lastFetch) >= interval {
+		return nil, false
 	}
+
+	c.stats.RecordCacheHit()
+	slog.Info("Upstream fetch throttled, returning cached quota", "min_interval", interval)
+	return cached, true
+}
+
+// getCooldownQuota returns the stale cached quota while a 429 Retry-After cooldown
+// is running, so upstream is not asked again before it said to
+func (c *CloudCodeClient) getCooldownQuota(cacheKey string) (*QuotaResponse, bool) {
+	c.cacheMutex.RLock()
+	cooling := c.now().Before(c.cooldownUntil)
 	c.cacheMutex.RUnlock()
+	if !cooling {
+		return nil, false
+	}
+
+	stale, ok := c.getStaleQuota(cacheKey)
+	if ok {
+		slog.Info("Upstream rate limit cooldown, serving stale quota")
+	}
+	return stale, ok
+}
+
+// fetchQuotaWithRefresh is fetchQuotaWithRetry that, when upstream rejects the
+// access token with a 401 and refresh is set, refreshes the token once and
+// retries with the new one. The token's expiry metadata can be wrong, so a
+// token that looked fresh may still be stale.
+func (c *CloudCodeClient) fetchQuotaWithRefresh(ctx context.Context, cacheKey, accessToken, projectID string, history bool, refresh TokenRefresher) (*QuotaResponse, error) {
+	quota, err := c.fetchQuotaWithRetry(ctx, cacheKey, accessToken, projectID, history)
 
-	// Fetch fresh data
-	log.Println("Fetching fresh quota data from googleapis.com")
+	var apiErr *APIError
+	if refresh == nil || !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		return quota, err
+	}
+
+	slog.Warn("Upstream rejected the access token, refreshing and retrying")
+	newAccessToken, refreshErr := refresh()
+	if refreshErr != nil {
+		return nil, refreshErr
+	}
+	return c.fetchQuotaWithRetry(ctx, cacheKey, newAccessToken, projectID, history)
+}
+
+// fetchQuotaWithRetry fetches quota, honoring a 429 Retry-After by starting a
+// cooldown. With stale data to serve the error is returned straight away for the
+// caller to fall back on; otherwise it waits, up to MaxRetryAfterSeconds, and
+// retries. A wait that would outlast ctx's deadline is not started.
+func (c *CloudCodeClient) fetchQuotaWithRetry(ctx context.Context, cacheKey, accessToken, projectID string, history bool) (*QuotaResponse, error) {
+	maxWait := time.Duration(c.config.MaxRetryAfterSeconds) * time.Second
+	for attempt := 0; ; attempt++ {
+		quota, err := c.fetchQuota(ctx, cacheKey, accessToken, projectID, history)
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests || apiErr.RetryAfter <= 0 {
+			return quota, err
+		}
+
+		c.cacheMutex.Lock()
+		c.cooldownUntil = c.now().Add(apiErr.RetryAfter)
+		c.cacheMutex.Unlock()
+
+		if _, warm := c.getStaleQuota(cacheKey); warm || maxWait <= 0 || attempt >= MaxRateLimitRetries {
+			return nil, err
+		}
+
+		wait := min(apiErr.RetryAfter, maxWait)
+		if deadline, ok := ctx.Deadline(); ok && deadline.Sub(c.now()) < wait {
+			return nil, err
+		}
+		slog.Warn("Upstream rate limited, retrying", "retry_after", apiErr.RetryAfter, "wait", wait)
+		if !sleepContext(ctx, wait) {
+			return nil, err
+		}
+	}
+}
+
+// sleepContext waits for d, returning false if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// fetchQuota fetches fresh quota data from the API and caches it
+func (c *CloudCodeClient) fetchQuota(ctx context.Context, cacheKey, accessToken, projectID string, history bool) (*QuotaResponse, error) {
+	c.stats.RecordCacheMiss()
+	c.cacheMutex.Lock()
+	c.lastUpstreamFetch = c.now()
+	c.cacheMutex.Unlock()
+
+	logFetch := c.sampleFetchLog()
+	if logFetch {
+		slog.Info("Fetching fresh quota data", "url", c.config.APIURL)
+	}
+	start := time.Now()
 	payload := make(map[string]interface{})
 	if projectID != "" {
 		payload["project"] = projectID
 	}
 
-	jsonData, _ := json.Marshal(payload)
-	req, err := http.NewRequest("POST", c.config.APIURL, bytes.NewBuffer(jsonData))
+	ctx, cancel := context.WithTimeout(ctx, httpTimeout(c.config))
+	defer cancel()
+
+	release, err := c.acquireUpstream(ctx)
 	if err != nil {
+		c.stats.RecordUpstreamError()
+		slog.Error("Quota request failed", "error", err, "duration", time.Since(start))
 		return nil, err
 	}
+	defer release()
 
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("User-Agent", c.config.UserAgent)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.httpClient.Do(req)
+	jsonData, _ := json.Marshal(payload)
+	resp, err := c.postWithFailover(ctx, c.apiURLs, accessToken, jsonData)
 	if err != nil {
+		c.stats.RecordUpstreamError()
+		slog.Error("Quota request failed", "error", redactError(err), "duration", time.Since(start))
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.stats.RecordUpstreamError()
+		slog.Error("Failed to read quota response", "error", err)
+		return nil, err
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed: %d - %s", resp.StatusCode, string(body))
+		c.stats.RecordUpstreamError()
+		slog.Error("Quota request returned an error", "status", resp.StatusCode, "duration", time.Since(start))
+		retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"), c.now())
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(body), RetryAfter: retryAfter}
 	}
 
 	var quotaResp QuotaResponse
-	if err := json.NewDecoder(resp.Body).Decode(&quotaResp); err != nil {
+	if err := json.Unmarshal(body, &quotaResp); err != nil {
+		slog.Error("Failed to decode quota response", "error", err)
 		return nil, err
 	}
+	if detected := percentFractionModels(&quotaResp); len(detected) > 0 && !c.config.FractionIsPercent {
+		slog.Warn("Upstream sent remainingFraction as a percentage; set FRACTION_IS_PERCENT=true if it always does", "models", detected)
+	}
+	if len(quotaResp.Models) == 0 && c.isForbiddenBody(body) {
+		// Some blocked accounts get a 200 with no models and an error payload
+		c.stats.RecordUpstreamError()
+		slog.Error("Quota response reports the account as forbidden", "duration", time.Since(start))
+		return nil, &APIError{StatusCode: http.StatusForbidden, Body: string(body)}
+	}
+
+	quotaResp.FetchedAt = c.now()
 
-	// Update cache
+	// Update cache, keeping the replaced response as the previous sample
 	c.cacheMutex.Lock()
+	if history {
+		now := c.now()
+		if cacheKey != c.historyKey {
+			// A different account or project; its samples are not comparable
+			c.prevCache = nil
+			c.burnRates = make(map[string]float64)
+			c.historyKey = cacheKey
+		} else if previous, exists := c.cache[cacheKey]; exists {
+			c.prevCache = previous.(*QuotaResponse)
+			c.prevCacheTime = c.cacheTime
+			updateBurnRates(c.burnRates, c.prevCache, &quotaResp, now.Sub(c.prevCacheTime), burnEMAAlpha(c.config), c.config.FractionIsPercent)
+		}
+		c.cacheTime = now
+	}
 	c.cache[cacheKey] = &quotaResp
-	c.cacheTime = time.Now()
 	c.cacheMutex.Unlock()
 
-	log.Printf("Cached quota data for %d minute(s)", c.config.QueryDebounce)
+	if err := c.quotaCache.Set(cacheKey, &quotaResp, time.Duration(c.config.QueryDebounce)*time.Minute+CacheRetention); err != nil {
+		slog.Warn("Failed to store quota in cache", "error", err)
+	}
+
+	if c.notifier != nil && history {
+		go c.notifier.Check(&quotaResp)
+	}
+	if c.pusher != nil && history {
+		go c.pusher.Push(&quotaResp)
+	}
+
+	if logFetch {
+		slog.Info("Cached quota data",
+			"status", resp.StatusCode,
+			"models", len(quotaResp.Models),
+			"duration", time.Since(start),
+			"debounce_minutes", c.config.QueryDebounce)
+	}
 	return &quotaResp, nil
 }
+
+// isForbiddenBody reports whether an upstream response body contains one of the
+// FORBIDDEN_MARKERS that flag a blocked account, ignoring case
+func (c *CloudCodeClient) isForbiddenBody(body []byte) bool {
+	lower := bytes.ToLower(body)
+	for _, marker := range c.config.ForbiddenMarkers {
+		if marker != "" && bytes.Contains(lower, []byte(strings.ToLower(marker))) {
+			return true
+		}
+	}
+	return false
+}
+
+// runRefresher calls refresh every interval until ctx is cancelled,
+// backing off exponentially while refresh keeps failing
+func runRefresher(ctx context.Context, interval time.Duration, refresh func() error) {
+	failures := 0
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if err := refresh(); err != nil {
+			failures++
+			delay := refreshBackoff(interval, failures)
+			slog.Warn("Background quota refresh failed", "error", redactError(err), "failures", failures, "retry_in", delay)
+			timer.Reset(delay)
+			continue
+		}
+
+		failures = 0
+		timer.Reset(interval)
+	}
+}
+
+// RefresherHealth tracks the background refresher for /healthz, so monitoring
+// can tell a stuck refresher from a quiet one
+type RefresherHealth struct {
+	started     atomic.Bool
+	lastSuccess atomic.Int64 // Unix seconds, 0 before the first success
+	restarts    atomic.Int64
+}
+
+// Started reports whether a supervised refresher has been started
+func (h *RefresherHealth) Started() bool {
+	return h.started.Load()
+}
+
+// LastSuccess returns the time of the last successful refresh, or the zero time
+func (h *RefresherHealth) LastSuccess() time.Time {
+	if unix := h.lastSuccess.Load(); unix > 0 {
+		return time.Unix(unix, 0)
+	}
+	return time.Time{}
+}
+
+// Restarts returns how many times the refresher has been restarted after crashing
+func (h *RefresherHealth) Restarts() int64 {
+	return h.restarts.Load()
+}
+
+// superviseRefresher runs runRefresher until ctx is cancelled, recording each
+// successful refresh in health at the time clock reports. A refresher that panics
+// or returns early is restarted after restartDelay, doubled for each crash
+// without a success in between.
+func superviseRefresher(ctx context.Context, clock Clock, interval, restartDelay time.Duration, refresh func() error, health *RefresherHealth) {
+	tracked := func() error {
+		if err := refresh(); err != nil {
+			return err
+		}
+		health.lastSuccess.Store(clock.Now().Unix())
+		return nil
+	}
+
+	health.started.Store(true)
+	crashes := 0
+	for {
+		before := health.lastSuccess.Load()
+		runRefresherRecovered(ctx, interval, tracked)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if health.lastSuccess.Load() != before {
+			crashes = 0
+		}
+		delay := refreshBackoff(restartDelay, crashes)
+		crashes++
+		health.restarts.Add(1)
+		slog.Error("Background refresher stopped unexpectedly", "restarts", health.Restarts(), "restart_in", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// runRefresherRecovered is runRefresher with a panic logged and recovered
+func runRefresherRecovered(ctx context.Context, interval time.Duration, refresh func() error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			slog.Error("Background refresher panicked", "panic", rec, "stack", string(debug.Stack()))
+		}
+	}()
+	runRefresher(ctx, interval, refresh)
+}
+
+// refreshBackoff returns the delay before the next refresh after the given number
+// of consecutive failures, doubling the interval each time up to MaxRefreshBackoff
+func refreshBackoff(interval time.Duration, failures int) time.Duration {
+	delay := interval
+	for i := 0; i < failures && delay < MaxRefreshBackoff; i++ {
+		delay *= 2
+	}
+	if delay > MaxRefreshBackoff {
+		return max(interval, MaxRefreshBackoff)
+	}
+	return delay
+}
@@ -0,0 +1,1377 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoadAccountFromEnvJSON(t *testing.T) {
+	config := &Config{
+		AccountFile: filepath.Join(t.TempDir(), "missing.json"),
+		AccountJSON: `{"access_token":"env-access","refresh_token":"env-refresh","project_id":"env-project"}`,
+	}
+	client := NewCloudCodeClient(config)
+
+	account, err := client.LoadAccount()
+	if err != nil {
+		t.Fatalf("Failed to load account: %v", err)
+	}
+
+	if account.AccessToken != "env-access" {
+		t.Errorf("Expected access token 'env-access', got %s", account.AccessToken)
+	}
+	if account.ProjectID != "env-project" {
+		t.Errorf("Expected project ID 'env-project', got %s", account.ProjectID)
+	}
+}
+
+func TestLoadAccountFallsBackToFile(t *testing.T) {
+	accountFile := createTestAccount(t)
+	client := NewCloudCodeClient(&Config{AccountFile: accountFile})
+
+	account, err := client.LoadAccount()
+	if err != nil {
+		t.Fatalf("Failed to load account: %v", err)
+	}
+
+	if account.AccessToken != "test-access-token" {
+		t.Errorf("Expected access token 'test-access-token', got %s", account.AccessToken)
+	}
+}
+
+func TestSaveAccountFromEnvJSON(t *testing.T) {
+	tmpDir := t.TempDir()
+	accountFile := filepath.Join(tmpDir, "account.json")
+	writePath := filepath.Join(tmpDir, "refreshed.json")
+
+	config := &Config{
+		AccountFile:      accountFile,
+		AccountJSON:      `{"access_token":"old-access","refresh_token":"env-refresh"}`,
+		RefreshWritePath: writePath,
+	}
+	client := NewCloudCodeClient(config)
+
+	account, err := client.LoadAccount()
+	if err != nil {
+		t.Fatalf("Failed to load account: %v", err)
+	}
+
+	account.AccessToken = "new-access"
+	if err := client.saveAccount(account); err != nil {
+		t.Fatalf("Failed to save account: %v", err)
+	}
+
+	// The in-memory copy is updated
+	reloaded, err := client.LoadAccount()
+	if err != nil {
+		t.Fatalf("Failed to reload account: %v", err)
+	}
+	if reloaded.AccessToken != "new-access" {
+		t.Errorf("Expected in-memory access token 'new-access', got %s", reloaded.AccessToken)
+	}
+
+	// The refresh write path receives the refreshed account
+	data, err := os.ReadFile(writePath)
+	if err != nil {
+		t.Fatalf("Expected refreshed account at %s: %v", writePath, err)
+	}
+	var written Account
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatalf("Failed to parse refreshed account: %v", err)
+	}
+	if written.AccessToken != "new-access" {
+		t.Errorf("Expected written access token 'new-access', got %s", written.AccessToken)
+	}
+
+	// The account file is never touched
+	if _, err := os.Stat(accountFile); !os.IsNotExist(err) {
+		t.Errorf("Expected account file to not be written")
+	}
+}
+
+func TestGetQuotaSingleFlight(t *testing.T) {
+	var hits atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		// Hold the response so concurrent callers overlap
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(QuotaResponse{
+			Models: map[string]ModelInfo{
+				"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.5}},
+			},
+		})
+	}))
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{APIURL: mockServer.URL, QueryDebounce: 1})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			quota, err := client.GetQuota("test-access-token", "test-project-id")
+			if err == nil && len(quota.Models) != 1 {
+				err = fmt.Errorf("expected 1 model, got %d", len(quota.Models))
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("GetQuota failed: %v", err)
+		}
+	}
+
+	if got := hits.Load(); got != 1 {
+		t.Errorf("Expected 1 upstream hit, got %d", got)
+	}
+}
+
+func TestLoadAccountEncodings(t *testing.T) {
+	plain := []byte(`{"access_token":"old-access","refresh_token":"test-refresh"}`)
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		writer.Write(data)
+		writer.Close()
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		encoding accountEncoding
+	}{
+		{"raw", plain, accountEncodingJSON},
+		{"base64", []byte(base64.StdEncoding.EncodeToString(plain) + "\n"), accountEncodingBase64},
+		{"gzip", gzipped(plain), accountEncodingGzip},
+		{"base64 gzip", []byte(base64.StdEncoding.EncodeToString(gzipped(plain))), accountEncodingBase64Gzip},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accountFile := filepath.Join(t.TempDir(), "account.json")
+			os.WriteFile(accountFile, tt.data, 0600)
+			client := NewCloudCodeClient(&Config{AccountFile: accountFile})
+
+			account, err := client.LoadAccount()
+			if err != nil {
+				t.Fatalf("LoadAccount failed: %v", err)
+			}
+			if account.AccessToken != "old-access" || account.RefreshToken != "test-refresh" {
+				t.Errorf("Unexpected account: %+v", account)
+			}
+
+			// Saving keeps the detected encoding
+			account.AccessToken = "new-access"
+			if err := client.saveAccount(account); err != nil {
+				t.Fatalf("saveAccount failed: %v", err)
+			}
+			saved, _ := os.ReadFile(accountFile)
+			decoded, encoding, err := decodeAccountData(saved)
+			if err != nil {
+				t.Fatalf("Failed to decode saved account: %v", err)
+			}
+			if encoding != tt.encoding {
+				t.Errorf("Expected the saved account to stay %s, got %s", tt.encoding, encoding)
+			}
+			if !strings.Contains(string(decoded), "new-access") {
+				t.Errorf("Expected the saved account to hold the new token, got %s", decoded)
+			}
+		})
+	}
+
+	// Something that is none of them reports the JSON error
+	accountFile := filepath.Join(t.TempDir(), "account.json")
+	os.WriteFile(accountFile, []byte("{not json"), 0600)
+	if _, err := NewCloudCodeClient(&Config{AccountFile: accountFile}).LoadAccount(); err == nil || !strings.Contains(err.Error(), "failed to parse account file") {
+		t.Errorf("Expected a parse error, got %v", err)
+	}
+}
+
+func TestReadOnlySkipsAccountWrites(t *testing.T) {
+	var refreshes atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "refreshed-access-token", ExpiresIn: 3600})
+	}))
+	defer mockServer.Close()
+
+	accountFile := createTestAccount(t)
+	original, _ := os.ReadFile(accountFile)
+	before, _ := os.Stat(accountFile)
+
+	client := NewCloudCodeClient(&Config{AccountFile: accountFile, TokenURL: mockServer.URL, ReadOnly: true})
+	for i := 0; i < 2; i++ {
+		account, err := client.LoadAccount()
+		if err != nil {
+			t.Fatalf("LoadAccount failed: %v", err)
+		}
+		token, err := client.EnsureFreshToken(account)
+		if err != nil {
+			t.Fatalf("EnsureFreshToken failed: %v", err)
+		}
+		if token != "refreshed-access-token" {
+			t.Errorf("Expected the refreshed token, got %q", token)
+		}
+	}
+
+	// The refreshed token is reused from memory rather than refreshed again
+	if got := refreshes.Load(); got != 1 {
+		t.Errorf("Expected one refresh, got %d", got)
+	}
+
+	after, _ := os.Stat(accountFile)
+	current, _ := os.ReadFile(accountFile)
+	if !bytes.Equal(current, original) || !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("Expected the account file to be untouched, got %s", current)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(accountFile)); len(entries) != 1 {
+		t.Errorf("Expected no temp files next to the account, got %d entries", len(entries))
+	}
+}
+
+func TestNormalizeAccountMillisecondExpiry(t *testing.T) {
+	client := NewCloudCodeClient(&Config{})
+
+	expiryMillis := int64(1767225600000)
+	account := &Account{
+		Type: "oauth",
+		Token: &TokenData{
+			AccessToken:     "nested-access",
+			RefreshToken:    "nested-refresh",
+			ExpiryTimestamp: &expiryMillis,
+		},
+	}
+
+	_, _, expiryTimestamp, _ := client.NormalizeAccount(account)
+	if expiryTimestamp == nil || *expiryTimestamp != 1767225600 {
+		t.Errorf("Expected expiry timestamp 1767225600, got %v", expiryTimestamp)
+	}
+}
+
+func TestNormalizeAccountFieldFallback(t *testing.T) {
+	client := NewCloudCodeClient(&Config{})
+
+	timestamp := int64(1767225600000)
+	account := &Account{
+		Token: &TokenData{
+			AccessToken: "nested-access",
+		},
+		AccessToken:  "top-access",
+		RefreshToken: "top-refresh",
+		ProjectID:    "top-project",
+		Timestamp:    &timestamp,
+		ExpiresIn:    3600,
+	}
+
+	accessToken, refreshToken, expiryTimestamp, projectID := client.NormalizeAccount(account)
+
+	if accessToken != "nested-access" {
+		t.Errorf("Expected nested access token to win, got %s", accessToken)
+	}
+	if refreshToken != "top-refresh" {
+		t.Errorf("Expected fallback refresh token 'top-refresh', got %s", refreshToken)
+	}
+	if projectID != "top-project" {
+		t.Errorf("Expected fallback project ID 'top-project', got %s", projectID)
+	}
+	if expiryTimestamp == nil || *expiryTimestamp != 1767225600+3600 {
+		t.Errorf("Expected fallback expiry timestamp %d, got %v", 1767225600+3600, expiryTimestamp)
+	}
+}
+
+func TestGetQuotaTimeout(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-time.After(2 * time.Second):
+			w.WriteHeader(http.StatusOK)
+		case <-r.Context().Done():
+		}
+	}))
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{APIURL: mockServer.URL, QueryDebounce: 1, HTTPTimeoutSeconds: 1})
+
+	start := time.Now()
+	_, err := client.GetQuota("test-access-token", "test-project-id")
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 2*time.Second {
+		t.Errorf("Expected request to fail fast, took %v", elapsed)
+	}
+}
+
+func TestHTTPTimeoutDefault(t *testing.T) {
+	if timeout := httpTimeout(&Config{}); timeout != 30*time.Second {
+		t.Errorf("Expected default timeout 30s, got %v", timeout)
+	}
+	if timeout := httpTimeout(&Config{HTTPTimeoutSeconds: 5}); timeout != 5*time.Second {
+		t.Errorf("Expected timeout 5s, got %v", timeout)
+	}
+}
+
+func TestGetQuotaServesStaleOnError(t *testing.T) {
+	var failing atomic.Bool
+	var status atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(int(status.Load()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(QuotaResponse{
+			Models: map[string]ModelInfo{
+				"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.5}},
+			},
+		})
+	}))
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{APIURL: mockServer.URL, QueryDebounce: 1})
+
+	fresh, err := client.GetQuota("test-access-token", "")
+	if err != nil {
+		t.Fatalf("Failed to get quota: %v", err)
+	}
+	if fresh.Stale {
+		t.Errorf("Expected fresh data not to be stale")
+	}
+
+	// Expire the cache and make the upstream fail
+	client.ClearCache()
+	failing.Store(true)
+	status.Store(http.StatusServiceUnavailable)
+
+	stale, err := client.GetQuota("test-access-token", "")
+	if err != nil {
+		t.Fatalf("Expected stale data, got error: %v", err)
+	}
+	if !stale.Stale {
+		t.Errorf("Expected stale flag to be set")
+	}
+	if len(stale.Models) != 1 {
+		t.Errorf("Expected 1 stale model, got %d", len(stale.Models))
+	}
+	if !formatQuota(stale, false, defaultQuotaFormat).IsStale {
+		t.Errorf("Expected is_stale in formatted quota")
+	}
+
+	// Auth errors are not masked by stale data
+	status.Store(http.StatusForbidden)
+	if _, err := client.GetQuota("test-access-token", ""); err == nil {
+		t.Errorf("Expected 403 to be returned rather than stale data")
+	}
+}
+
+func TestGetQuotaRetryAfter(t *testing.T) {
+	var hits atomic.Int32
+	var limited atomic.Int32
+	var retryAfter atomic.Value
+	retryAfter.Store("1")
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if limited.Load() > 0 {
+			limited.Add(-1)
+			w.Header().Set("Retry-After", retryAfter.Load().(string))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(QuotaResponse{
+			Models: map[string]ModelInfo{
+				"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.5}},
+			},
+		})
+	}))
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{APIURL: mockServer.URL, QueryDebounce: 1, MaxRetryAfterSeconds: 5})
+
+	// Cold cache: wait out the Retry-After, then retry
+	limited.Store(1)
+	start := time.Now()
+	quota, err := client.GetQuota("test-access-token", "")
+	if err != nil {
+		t.Fatalf("Expected the retry to succeed, got %v", err)
+	}
+	if quota.Stale {
+		t.Errorf("Expected fresh data after the retry")
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected to wait for Retry-After, only waited %v", elapsed)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("Expected 429 then 200, got %d upstream calls", got)
+	}
+
+	// Warm cache: serve stale right away and stay off upstream during the cooldown
+	retryAfter.Store("60")
+	limited.Store(1)
+	for i := 0; i < 2; i++ {
+		client.ClearCache()
+		start = time.Now()
+		quota, err = client.GetQuota("test-access-token", "")
+		if err != nil {
+			t.Fatalf("Expected stale data, got %v", err)
+		}
+		if !quota.Stale {
+			t.Errorf("Expected stale data during the cooldown")
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("Expected stale data without waiting, took %v", elapsed)
+		}
+	}
+	if got := hits.Load(); got != 3 {
+		t.Errorf("Expected one rate-limited call during the cooldown, got %d upstream calls", got-2)
+	}
+}
+
+func TestGetQuotaRetryAfterRespectsContext(t *testing.T) {
+	var hits atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{APIURL: mockServer.URL, QueryDebounce: 1, MaxRetryAfterSeconds: 5})
+
+	// A wait that would outlast the deadline is not started
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.GetAccountQuota(ctx, "deadline", "test-access-token", "", DebounceMaxAge, nil)
+	if quotaErrorStatus(err) != http.StatusTooManyRequests {
+		t.Errorf("Expected the 429 without retrying past the deadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("Expected to give up before the deadline, took %v", elapsed)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("Expected no retry, got %d upstream calls", got)
+	}
+
+	// A cancelled caller stops waiting on the fetch
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start = time.Now()
+	_, err = client.GetAccountQuota(ctx, "cancelled", "test-access-token", "", DebounceMaxAge, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancellation, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected to stop waiting on cancellation, took %v", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 12, 26, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{"0", 0, true},
+		{"Fri, 26 Dec 2025 10:00:30 GMT", 30 * time.Second, true},
+		{"Fri, 26 Dec 2025 09:00:00 GMT", 0, true},
+		{"-5", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = (%v, %v), expected (%v, %v)", tt.value, got, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestRunRefresher(t *testing.T) {
+	var calls atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runRefresher(ctx, 10*time.Millisecond, func() error {
+			if calls.Add(1) >= 3 {
+				cancel()
+			}
+			return nil
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		cancel()
+		t.Fatal("Refresher did not stop after cancellation")
+	}
+
+	if got := calls.Load(); got < 3 {
+		t.Errorf("Expected at least 3 refreshes, got %d", got)
+	}
+}
+
+func TestSuperviseRefresherRestartsAfterPanic(t *testing.T) {
+	var calls atomic.Int32
+	var health RefresherHealth
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		superviseRefresher(ctx, realClock{}, 10*time.Millisecond, 10*time.Millisecond, func() error {
+			if calls.Add(1) == 1 {
+				panic("refresh exploded")
+			}
+			cancel()
+			return nil
+		}, &health)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		cancel()
+		t.Fatal("Supervisor did not restart the refresher after a panic")
+	}
+
+	if got := calls.Load(); got != 2 {
+		t.Errorf("Expected the restarted refresher to run again, got %d calls", got)
+	}
+	if got := health.Restarts(); got != 1 {
+		t.Errorf("Expected 1 restart, got %d", got)
+	}
+	if health.LastSuccess().IsZero() {
+		t.Errorf("Expected the successful refresh after the restart to be recorded")
+	}
+}
+
+func TestRefreshBackoff(t *testing.T) {
+	interval := time.Minute
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{0, time.Minute},
+		{1, 2 * time.Minute},
+		{3, 8 * time.Minute},
+		{10, MaxRefreshBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := refreshBackoff(interval, tt.failures); got != tt.expected {
+			t.Errorf("failures=%d: expected %v, got %v", tt.failures, tt.expected, got)
+		}
+	}
+
+	if got := refreshBackoff(time.Hour, 2); got != time.Hour {
+		t.Errorf("Expected intervals above the cap to be kept, got %v", got)
+	}
+}
+
+func TestHasAccount(t *testing.T) {
+	if NewCloudCodeClient(&Config{AccountFile: filepath.Join(t.TempDir(), "missing.json")}).HasAccount() {
+		t.Errorf("Expected no account for a missing file")
+	}
+	if !NewCloudCodeClient(&Config{AccountFile: createTestAccount(t)}).HasAccount() {
+		t.Errorf("Expected account file to be detected")
+	}
+	if !NewCloudCodeClient(&Config{AccountJSON: `{"access_token":"x"}`}).HasAccount() {
+		t.Errorf("Expected ACCOUNT_JSON to be detected")
+	}
+}
+
+func TestEnsureFreshTokenConcurrentWrites(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	accountFile := createTestAccount(t)
+	client := NewCloudCodeClient(&Config{
+		TokenURL:    mockServer.URL + "/token",
+		AccountFile: accountFile,
+	})
+
+	stop := make(chan struct{})
+	readErrs := make(chan error, 1)
+	go func() {
+		defer close(readErrs)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			data, err := os.ReadFile(accountFile)
+			if err != nil {
+				readErrs <- err
+				return
+			}
+			if !json.Valid(data) {
+				readErrs <- fmt.Errorf("account file is not valid JSON: %q", data)
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			account, err := client.LoadAccount()
+			if err != nil {
+				t.Errorf("Failed to load account: %v", err)
+				return
+			}
+			if _, err := client.EnsureFreshToken(account); err != nil {
+				t.Errorf("Failed to refresh token: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+
+	if err := <-readErrs; err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(accountFile)
+	if err != nil {
+		t.Fatalf("Failed to stat account file: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Expected 0600 permissions, got %o", perm)
+	}
+
+	account, err := client.LoadAccount()
+	if err != nil {
+		t.Fatalf("Account file is unreadable after concurrent refreshes: %v", err)
+	}
+	if account.AccessToken != "new-access-token" {
+		t.Errorf("Expected refreshed token, got %s", account.AccessToken)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(accountFile))
+	if len(entries) != 1 {
+		t.Errorf("Expected temp files to be cleaned up, found %d entries", len(entries))
+	}
+}
+
+func TestValidateAccount(t *testing.T) {
+	client := NewCloudCodeClient(&Config{})
+	expiry := int64(1700000000)
+
+	tests := []struct {
+		name    string
+		account Account
+		missing []string
+	}{
+		{"empty", Account{}, []string{"access_token", "refresh_token"}},
+		{"top-level access only, unknown expiry", Account{AccessToken: "access"}, nil},
+		{"nested access only with expiry", Account{Token: &TokenData{AccessToken: "access", ExpiryTimestamp: &expiry}}, []string{"refresh_token"}},
+		{"nested refresh only", Account{Token: &TokenData{RefreshToken: "refresh"}}, []string{"access_token"}},
+		{"split across sources", Account{AccessToken: "access", Token: &TokenData{RefreshToken: "refresh"}}, nil},
+		{"nested complete", Account{Token: &TokenData{AccessToken: "access", RefreshToken: "refresh"}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := client.ValidateAccount(&tt.account)
+			if tt.missing == nil {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Expected an error naming %v", tt.missing)
+			}
+			for _, field := range tt.missing {
+				if !strings.Contains(err.Error(), field) {
+					t.Errorf("Expected error to name %s, got %v", field, err)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyURL(t *testing.T) {
+	var proxiedHost atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A forward proxy receives the absolute upstream URL
+		proxiedHost.Store(r.URL.Host)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(QuotaResponse{
+			Models: map[string]ModelInfo{
+				"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.5}},
+			},
+		})
+	}))
+	defer proxy.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:        "http://upstream.invalid/v1internal:fetchAvailableModels",
+		ProxyURL:      proxy.URL,
+		QueryDebounce: 1,
+	})
+
+	quota, err := client.GetQuota("test-access-token", "")
+	if err != nil {
+		t.Fatalf("Expected request to route through the proxy, got %v", err)
+	}
+	if host, _ := proxiedHost.Load().(string); host != "upstream.invalid" {
+		t.Errorf("Expected proxy to receive upstream.invalid, got %q", host)
+	}
+	if len(quota.Models) != 1 {
+		t.Errorf("Expected 1 model from the proxied response, got %d", len(quota.Models))
+	}
+}
+
+func TestTransportPoolConfig(t *testing.T) {
+	client := NewCloudCodeClient(&Config{
+		HTTPMaxIdleConns:           20,
+		HTTPMaxIdleConnsPerHost:    5,
+		HTTPIdleConnTimeoutSeconds: 30,
+	})
+	transport, ok := client.httpClient.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("Expected *http.Transport, got %T", client.httpClient.Transport)
+	}
+	if transport.MaxIdleConns != 20 || transport.MaxIdleConnsPerHost != 5 || transport.IdleConnTimeout != 30*time.Second {
+		t.Errorf("Expected pool 20/5/30s from config, got %d/%d/%v",
+			transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+	}
+
+	defaults := newTransport(&Config{})
+	if defaults.MaxIdleConns != DefaultHTTPMaxIdleConns ||
+		defaults.MaxIdleConnsPerHost != DefaultHTTPMaxIdleConnsPerHost ||
+		defaults.IdleConnTimeout != DefaultHTTPIdleConnTimeoutSeconds*time.Second {
+		t.Errorf("Expected default pool settings for unset config, got %d/%d/%v",
+			defaults.MaxIdleConns, defaults.MaxIdleConnsPerHost, defaults.IdleConnTimeout)
+	}
+}
+
+func TestUpstreamFailover(t *testing.T) {
+	var failedHits, workingHits atomic.Int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failedHits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		workingHits.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/v1internal:loadCodeAssist" {
+			json.NewEncoder(w).Encode(ProjectResponse{CloudAICompanionProject: "failover-project"})
+			return
+		}
+		json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+			"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.9}},
+		}})
+	}))
+	defer working.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:        failing.URL + "/v1internal:fetchAvailableModels, " + working.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: failing.URL + "/v1internal:loadCodeAssist," + working.URL + "/v1internal:loadCodeAssist",
+		QueryDebounce: 1,
+	})
+
+	projectID, err := client.GetProjectID("test-access-token")
+	if err != nil || projectID != "failover-project" {
+		t.Fatalf("Expected project ID from the second endpoint, got %q (%v)", projectID, err)
+	}
+
+	quota, err := client.GetQuota("test-access-token", projectID)
+	if err != nil {
+		t.Fatalf("Expected quota from the second endpoint, got %v", err)
+	}
+	if len(quota.Models) != 1 {
+		t.Errorf("Expected 1 model, got %d", len(quota.Models))
+	}
+	if got := failedHits.Load(); got != 2 {
+		t.Errorf("Expected the failing endpoint to be tried once per API, got %d", got)
+	}
+
+	// The working endpoint is remembered and tried first
+	client.ClearCache()
+	if _, err := client.GetQuota("test-access-token", projectID); err != nil {
+		t.Fatalf("Second fetch failed: %v", err)
+	}
+	if got := failedHits.Load(); got != 2 {
+		t.Errorf("Expected the failing endpoint to be skipped after failover, got %d hits", got)
+	}
+	if got := workingHits.Load(); got != 3 {
+		t.Errorf("Expected 3 hits on the working endpoint, got %d", got)
+	}
+}
+
+func TestUpstreamFailoverAllFail(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	client := NewCloudCodeClient(&Config{APIURL: failing.URL + "," + failing.URL, QueryDebounce: 1})
+
+	_, err := client.GetQuota("test-access-token", "")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected the last endpoint's 502 to surface as an APIError, got %v", err)
+	}
+}
+
+func TestGetProjectIDIDEType(t *testing.T) {
+	var ideType, userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Metadata map[string]string `json:"metadata"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		ideType = payload.Metadata["ideType"]
+		userAgent = r.Header.Get("User-Agent")
+		json.NewEncoder(w).Encode(ProjectResponse{CloudAICompanionProject: "test-project"})
+	}))
+	defer server.Close()
+
+	// Unset IDE type falls back to the default
+	client := NewCloudCodeClient(&Config{ProjectAPIURL: server.URL})
+	if _, err := client.GetProjectID("test-access-token"); err != nil {
+		t.Fatalf("GetProjectID failed: %v", err)
+	}
+	if ideType != DefaultIDEType {
+		t.Errorf("Expected default ideType %q, got %q", DefaultIDEType, ideType)
+	}
+
+	// A configured IDE type is sent along with a matching default User-Agent
+	t.Setenv("IDE_TYPE", "VSCODE")
+	t.Setenv("USER_AGENT", "")
+	config := LoadConfig()
+	config.ProjectAPIURL = server.URL
+	client = NewCloudCodeClient(config)
+	if _, err := client.GetProjectID("test-access-token"); err != nil {
+		t.Fatalf("GetProjectID failed: %v", err)
+	}
+	if ideType != "VSCODE" {
+		t.Errorf("Expected ideType VSCODE, got %q", ideType)
+	}
+	if !strings.HasPrefix(userAgent, "vscode/") {
+		t.Errorf("Expected User-Agent to name the IDE, got %q", userAgent)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", ""},
+		{"short", "..."},
+		{"ya29.a0AfH6SMBx-secret-token", "ya29.a..."},
+	}
+	for _, tt := range tests {
+		if got := redact(tt.input); got != tt.expected {
+			t.Errorf("redact(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+
+	err := fmt.Errorf("wrapped: %w", &APIError{StatusCode: 500, Body: `{"token":"ya29.secret-value"}`})
+	if got := redactError(err); got != "wrapped: API request failed: 500 - {\"toke..." {
+		t.Errorf("Unexpected redacted error: %q", got)
+	}
+}
+
+func TestLogsNeverContainTokens(t *testing.T) {
+	const secret = "ya29.super-secret-token-value"
+
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(newLogger("debug", "json", &buf))
+	defer slog.SetDefault(previous)
+
+	// The upstream echoes the bearer token back in its error bodies
+	var failing atomic.Bool
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, `{"error":"bad credentials %s"}`, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+			return
+		}
+		json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+			"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.5}},
+		}})
+	}))
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL + "," + mockServer.URL,
+		QueryDebounce: 1,
+	})
+	if _, err := client.GetQuota(secret, ""); err != nil {
+		t.Fatalf("Initial fetch failed: %v", err)
+	}
+
+	// Failover, the failed fetch, and the stale fallback all log the error
+	failing.Store(true)
+	client.ClearCache()
+	if _, err := client.GetQuota(secret, ""); err != nil {
+		t.Fatalf("Expected stale data, got %v", err)
+	}
+
+	// So does the handler when no stale data is available
+	service := NewQuotaService(NewCloudCodeClient(&Config{APIURL: mockServer.URL, AccountJSON: `{"access_token":"` + secret + `","refresh_token":"refresh","expiry_timestamp":9999999999}`}))
+	router := gin.New()
+	router.Use(RequestID())
+	router.GET("/quota/all", service.GetAllQuota)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/quota/all", nil))
+
+	if buf.Len() == 0 {
+		t.Fatalf("Expected log output")
+	}
+	if !strings.Contains(buf.String(), "API request failed: 500") {
+		t.Errorf("Expected the upstream error to be logged, got %s", buf.String())
+	}
+	if strings.Contains(buf.String(), secret) {
+		t.Errorf("Token leaked into logs: %s", buf.String())
+	}
+}
+
+func TestMaxConcurrentUpstream(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			observed := maxInFlight.Load()
+			if current <= observed || maxInFlight.CompareAndSwap(observed, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "loadCodeAssist") {
+			json.NewEncoder(w).Encode(ProjectResponse{CloudAICompanionProject: "resolved-project"})
+			return
+		}
+		json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+			"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.5}},
+		}})
+	}))
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:                mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL:         mockServer.URL + "/v1internal:loadCodeAssist",
+		QueryDebounce:         1,
+		MaxConcurrentUpstream: 2,
+	})
+
+	// Distinct projects so no fetches are collapsed into one
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := client.GetProjectQuota(context.Background(), "identity", "test-access-token", fmt.Sprintf("project-%d", i), DebounceMaxAge, nil); err != nil {
+				t.Errorf("GetProjectQuota failed: %v", err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := client.GetProjectID("test-access-token"); err != nil {
+				t.Errorf("GetProjectID failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := maxInFlight.Load(); got != 2 {
+		t.Errorf("Expected at most 2 concurrent upstream requests, observed %d", got)
+	}
+}
+
+func TestProjectQuotaCacheBounded(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Project string `json:"project"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		if strings.HasPrefix(request.Project, "unknown-") {
+			http.Error(w, `{"error":{"code":403,"message":"permission denied"}}`, http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+			"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.5}},
+		}})
+	}))
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		QueryDebounce: 5,
+	})
+	clock := newFakeClock(time.Date(2025, 12, 26, 9, 0, 0, 0, time.UTC))
+	client.SetClock(clock)
+
+	if _, err := client.GetAccountQuota(context.Background(), "identity", "test-access-token", "own-project", DebounceMaxAge, nil); err != nil {
+		t.Fatalf("GetAccountQuota failed: %v", err)
+	}
+
+	// Projects upstream rejects are never recorded
+	for i := 0; i < 5; i++ {
+		if _, err := client.GetProjectQuota(context.Background(), "identity", "test-access-token", fmt.Sprintf("unknown-%d", i), DebounceMaxAge, nil); err == nil {
+			t.Fatalf("Expected an unknown project to fail")
+		}
+	}
+	if got := len(client.cacheKeys); got != 1 {
+		t.Errorf("Expected only the account's project recorded, got %d keys", got)
+	}
+
+	for i := 0; i < MaxCachedQuotas+10; i++ {
+		clock.Advance(time.Second)
+		if _, err := client.GetProjectQuota(context.Background(), "identity", "test-access-token", fmt.Sprintf("project-%d", i), DebounceMaxAge, nil); err != nil {
+			t.Fatalf("GetProjectQuota failed: %v", err)
+		}
+	}
+
+	if got := len(client.cacheKeys); got != MaxCachedQuotas {
+		t.Errorf("Expected %d cache keys, got %d", MaxCachedQuotas, got)
+	}
+	if got := len(client.cache); got != MaxCachedQuotas {
+		t.Errorf("Expected %d cached responses, got %d", MaxCachedQuotas, got)
+	}
+	if _, ok := client.cacheKeys[quotaCacheKey("identity", "own-project")]; !ok {
+		t.Errorf("Expected the account's own project to be kept")
+	}
+	if _, ok := client.cacheKeys[quotaCacheKey("identity", "project-0")]; ok {
+		t.Errorf("Expected the least recently served project to be evicted")
+	}
+	if _, ok := client.cacheKeys[quotaCacheKey("identity", fmt.Sprintf("project-%d", MaxCachedQuotas+9))]; !ok {
+		t.Errorf("Expected the latest project to be kept")
+	}
+}
+
+// fakeClock is a Clock that only moves when advanced
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock(start time.Time) *fakeClock {
+	return &fakeClock{now: start}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// setNow pins the formatting clock to at for the rest of the test
+func setNow(t *testing.T, at time.Time) {
+	t.Helper()
+	original := now
+	now = func() time.Time { return at }
+	t.Cleanup(func() { now = original })
+}
+
+func TestDebounceWithFakeClock(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+			"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.5}},
+		}})
+	}))
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{APIURL: mockServer.URL, QueryDebounce: 1})
+	clock := newFakeClock(time.Date(2025, 12, 26, 10, 0, 0, 0, time.UTC))
+	client.SetClock(clock)
+
+	steps := []struct {
+		advance time.Duration
+		calls   int32
+	}{
+		{0, 1},
+		{30 * time.Second, 1},
+		{29 * time.Second, 1},
+		{time.Second, 2},
+		{59 * time.Second, 2},
+	}
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		if _, err := client.GetQuota("test-access-token", "test-project-id"); err != nil {
+			t.Fatalf("GetQuota failed: %v", err)
+		}
+		if got := upstreamCalls.Load(); got != step.calls {
+			t.Errorf("After advancing %s: expected %d upstream calls, got %d", step.advance, step.calls, got)
+		}
+	}
+}
+
+func TestQuotaMaxAge(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+			"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.5}},
+		}})
+	}))
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{APIURL: mockServer.URL, QueryDebounce: 1, MinUpstreamIntervalSeconds: 5})
+	clock := newFakeClock(time.Date(2025, 12, 26, 10, 0, 0, 0, time.UTC))
+	client.SetClock(clock)
+
+	steps := []struct {
+		advance time.Duration
+		maxAge  time.Duration
+		calls   int32
+	}{
+		{0, DebounceMaxAge, 1},
+		// A small max_age refetches inside the debounce window
+		{10 * time.Second, 5 * time.Second, 2},
+		// but not faster than MIN_UPSTREAM_INTERVAL_SECONDS
+		{2 * time.Second, 0, 2},
+		// A large max_age serves cache past the debounce window
+		{2 * time.Minute, 5 * time.Minute, 2},
+		{0, DebounceMaxAge, 3},
+	}
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		if _, err := client.GetAccountQuota(context.Background(), "identity", "test-access-token", "test-project-id", step.maxAge, nil); err != nil {
+			t.Fatalf("GetAccountQuota failed: %v", err)
+		}
+		if got := upstreamCalls.Load(); got != step.calls {
+			t.Errorf("After advancing %s with max age %s: expected %d upstream calls, got %d", step.advance, step.maxAge, step.calls, got)
+		}
+	}
+}
+
+func TestAccessTokenOnlyWithoutExpiry(t *testing.T) {
+	var refreshes atomic.Int32
+	var reject atomic.Bool
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			refreshes.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer only-access-token" {
+			t.Errorf("Expected the account's access token, got %q", r.Header.Get("Authorization"))
+		}
+		if reject.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"code":401,"status":"UNAUTHENTICATED"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+			"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.5}},
+		}})
+	}))
+	defer mockServer.Close()
+
+	accountFile := filepath.Join(t.TempDir(), "account.json")
+	os.WriteFile(accountFile, []byte(`{"access_token":"only-access-token","project_id":"test-project-id"}`), 0600)
+	client := NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   accountFile,
+		QueryDebounce: 1,
+	})
+	service := NewQuotaService(client)
+
+	// No expiry and nothing to refresh with, so the token is assumed fresh
+	quota, err := service.getQuotaData(context.Background(), "", DebounceMaxAge)
+	if err != nil {
+		t.Fatalf("Expected the access token to be used as-is, got %v", err)
+	}
+	if len(quota.Models) != 1 {
+		t.Errorf("Expected 1 model, got %d", len(quota.Models))
+	}
+	if refreshes.Load() != 0 {
+		t.Errorf("Expected no refresh attempt, got %d", refreshes.Load())
+	}
+
+	// Once upstream rejects it, only re-authenticating helps
+	reject.Store(true)
+	client.ClearCache()
+	_, err = service.getQuotaData(context.Background(), "", DebounceMaxAge)
+	if appErr := toAppError(err); appErr.Status != http.StatusUnauthorized || appErr.Code != CodeReauthRequired {
+		t.Errorf("Expected 401 %s for a rejected token, got %d %s (%v)", CodeReauthRequired, appErr.Status, appErr.Code, err)
+	}
+	if refreshes.Load() != 0 {
+		t.Errorf("Expected no refresh attempt without a refresh token, got %d", refreshes.Load())
+	}
+}
+
+func TestQuotaRefreshesOnUnauthorized(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		acceptRefresh bool
+	}{
+		{"retry succeeds", true},
+		{"retry rejected too", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var quotaCalls, refreshes atomic.Int32
+			mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if r.URL.Path == "/token" {
+					refreshes.Add(1)
+					json.NewEncoder(w).Encode(TokenResponse{AccessToken: "refreshed-access-token", ExpiresIn: 3600})
+					return
+				}
+				quotaCalls.Add(1)
+				if !tt.acceptRefresh || r.Header.Get("Authorization") != "Bearer refreshed-access-token" {
+					w.WriteHeader(http.StatusUnauthorized)
+					w.Write([]byte(`{"error":{"code":401,"status":"UNAUTHENTICATED"}}`))
+					return
+				}
+				json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+					"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.5}},
+				}})
+			}))
+			defer mockServer.Close()
+
+			// The expiry claims the token is fresh, but upstream disagrees
+			expiry := time.Now().Add(time.Hour).Unix()
+			accountFile := filepath.Join(t.TempDir(), "account.json")
+			data, _ := json.Marshal(Account{Token: &TokenData{
+				AccessToken:     "stale-access-token",
+				RefreshToken:    "test-refresh-token",
+				ExpiryTimestamp: &expiry,
+				ProjectID:       "test-project-id",
+			}})
+			os.WriteFile(accountFile, data, 0600)
+
+			client := NewCloudCodeClient(&Config{
+				APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+				TokenURL:      mockServer.URL + "/token",
+				AccountFile:   accountFile,
+				QueryDebounce: 1,
+			})
+			quota, err := NewQuotaService(client).getQuotaData(context.Background(), "", DebounceMaxAge)
+
+			if tt.acceptRefresh {
+				if err != nil {
+					t.Fatalf("Expected the retry with the refreshed token to succeed, got %v", err)
+				}
+				if len(quota.Models) != 1 {
+					t.Errorf("Expected 1 model, got %d", len(quota.Models))
+				}
+				account, _ := client.LoadAccount()
+				if account.Token.AccessToken != "refreshed-access-token" {
+					t.Errorf("Expected the refreshed token to be saved, got %q", account.Token.AccessToken)
+				}
+			} else if status := quotaErrorStatus(err); status != http.StatusUnauthorized {
+				t.Errorf("Expected the retry's 401, got %d (%v)", status, err)
+			}
+			// One retry at most, whether or not it succeeds
+			if quotaCalls.Load() != 2 || refreshes.Load() != 1 {
+				t.Errorf("Expected 2 quota calls and 1 refresh, got %d and %d", quotaCalls.Load(), refreshes.Load())
+			}
+		})
+	}
+}
+
+func TestEnsureFreshTokenWithFakeClock(t *testing.T) {
+	var refreshes atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "refreshed-access-token", ExpiresIn: 3600})
+	}))
+	defer mockServer.Close()
+
+	start := time.Date(2025, 12, 26, 10, 0, 0, 0, time.UTC)
+	expiry := start.Add(10 * time.Minute).Unix()
+	accountFile := filepath.Join(t.TempDir(), "account.json")
+	data, _ := json.Marshal(Account{Token: &TokenData{
+		AccessToken:     "old-access-token",
+		RefreshToken:    "test-refresh-token",
+		ExpiryTimestamp: &expiry,
+	}})
+	os.WriteFile(accountFile, data, 0600)
+
+	client := NewCloudCodeClient(&Config{AccountFile: accountFile, TokenURL: mockServer.URL})
+	clock := newFakeClock(start)
+	client.SetClock(clock)
+
+	ensure := func() string {
+		account, err := client.LoadAccount()
+		if err != nil {
+			t.Fatalf("LoadAccount failed: %v", err)
+		}
+		token, err := client.EnsureFreshToken(account)
+		if err != nil {
+			t.Fatalf("EnsureFreshToken failed: %v", err)
+		}
+		return token
+	}
+
+	// Ten minutes out is past the refresh buffer, so the token is kept
+	if got := ensure(); got != "old-access-token" || refreshes.Load() != 0 {
+		t.Errorf("Expected the fresh token to be kept, got %q after %d refreshes", got, refreshes.Load())
+	}
+
+	// Inside the buffer before expiry, it is refreshed and stamped from the clock
+	clock.Advance(10*time.Minute - TokenRefreshBufferSeconds*time.Second)
+	if got := ensure(); got != "refreshed-access-token" || refreshes.Load() != 1 {
+		t.Errorf("Expected a refresh inside the buffer, got %q after %d refreshes", got, refreshes.Load())
+	}
+	account, _ := client.LoadAccount()
+	if want := clock.Now().Unix() + 3600; *account.Token.ExpiryTimestamp != want {
+		t.Errorf("Expected new expiry %d from the fake clock, got %d", want, *account.Token.ExpiryTimestamp)
+	}
+}
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -13,23 +21,86 @@ const (
 	// Time conversion
 	SecondsPerMinute = 60
 
+	// Epoch timestamps above this are in milliseconds rather than seconds
+	MillisecondTimestampThreshold = 1e12
+
 	// Quota percentage thresholds for color coding
 	QuotaFull     = 100
 	QuotaGood     = 50
 	QuotaWarning  = 20
 	QuotaCritical = 1
+
+	// Default upstream HTTP request timeout
+	DefaultHTTPTimeoutSeconds = 30
+
+	// Default grace period for in-flight requests on shutdown
+	DefaultShutdownTimeoutSeconds = 10
+
+	// Default minimum response size in bytes before gzip compression applies
+	DefaultGzipMinSize = 1024
+
+	// Default smoothing factor for the per-model burn-rate moving average
+	DefaultBurnEMAAlpha = 0.3
+
+	// Idle time after which a client's rate limiter is evicted
+	RateLimitIdleTTL = 10 * time.Minute
+
+	// Account-project quotas kept in memory; the least recently used beyond this
+	// are dropped, so arbitrary ?project= values cannot grow the cache unbounded
+	MaxCachedQuotas = 64
+
+	// How long fetched quota stays in the quota cache past QUERY_DEBOUNCE, as the
+	// stale, ?max_age, and throttled fallback shared by every replica
+	CacheRetention = 24 * time.Hour
+
+	// Upper bound on the background refresh delay after repeated failures
+	MaxRefreshBackoff = 30 * time.Minute
+
+	// Delay before restarting a crashed background refresher, doubled per consecutive crash
+	RefresherRestartDelay = 5 * time.Second
+
+	// Retries of a quota fetch rate limited with a Retry-After, and the default wait cap
+	MaxRateLimitRetries         = 2
+	DefaultMaxRetryAfterSeconds = 10
+
+	// Default cap on concurrent outbound quota and project ID requests
+	DefaultMaxConcurrentUpstream = 2
+
+	// Default job label for metrics pushed to PUSHGATEWAY_URL
+	DefaultPushgatewayJob = "antigravity_quota"
+
+	// Default upstream connection pool: idle connections kept in total and per
+	// host, and how long an idle connection is kept before closing
+	DefaultHTTPMaxIdleConns           = 100
+	DefaultHTTPMaxIdleConnsPerHost    = 10
+	DefaultHTTPIdleConnTimeoutSeconds = 90
+
+	// Cloud Code API base URL and the default version path segment
+	CloudCodeBaseURL  = "https://cloudcode-pa.googleapis.com"
+	DefaultAPIVersion = "v1internal"
+
+	// Default IDE type reported to loadCodeAssist, and the client version and
+	// platform appended to it in the default User-Agent
+	DefaultIDEType          = "ANTIGRAVITY"
+	DefaultUserAgentVersion = "1.13.3 Darwin/arm64"
+
+	// Default Z.ai API base URL
+	DefaultZAIBaseURL = "https://api.z.ai/api/anthropic"
 )
 
 // Config holds all configuration values
 type Config struct {
-	// Google Cloud Code API URLs
+	// Google Cloud Code API URLs; APIURL and ProjectAPIURL accept comma-separated failover lists
 	APIURL        string
 	ProjectAPIURL string
 	TokenURL      string
 
-	// User agent
+	// User agent, defaulting to one derived from the IDE type
 	UserAgent string
 
+	// IDE type sent in the loadCodeAssist metadata
+	IDEType string
+
 	// Google OAuth credentials
 	ClientID     string
 	ClientSecret string
@@ -37,25 +108,400 @@ type Config struct {
 	// Account file path
 	AccountFile string
 
-	// Server port
+	// Account JSON from the environment, used instead of AccountFile when set
+	AccountJSON string
+
+	// Optional writable path for refreshed tokens when using AccountJSON
+	RefreshWritePath string
+
+	// Never write the account; refreshed tokens are kept in memory for this process
+	ReadOnly bool
+
+	// Server port, and the host or IP to bind to; an empty host binds all interfaces
 	Port int
+	Host string
+
+	// Port for the gRPC QuotaService, served alongside HTTP; 0 disables it
+	GRPCPort int
+
+	// Expose debugging endpoints such as /quota/raw
+	DebugEndpoints bool
+
+	// Optional API key required on /quota routes
+	APIKey string
+
+	// Per-IP rate limit on /quota routes; RateLimitRPS <= 0 disables it
+	RateLimitRPS   float64
+	RateLimitBurst int
 
 	// Query debounce time in minutes
 	QueryDebounce int
+
+	// Upstream HTTP request timeout in seconds
+	HTTPTimeoutSeconds int
+
+	// Upstream connection pool: idle connections kept in total and per host, and
+	// seconds an idle connection is kept; values below 1 use the defaults
+	HTTPMaxIdleConns           int
+	HTTPMaxIdleConnsPerHost    int
+	HTTPIdleConnTimeoutSeconds int
+
+	// Minimum seconds between upstream quota fetches, including forced refreshes;
+	// 0 disables the floor
+	MinUpstreamIntervalSeconds int
+
+	// Longest Retry-After wait in seconds before retrying a rate-limited fetch with
+	// no stale data to serve; 0 fails straight away
+	MaxRetryAfterSeconds int
+
+	// Cap on concurrent outbound quota and project ID requests; values below 1 use the default
+	MaxConcurrentUpstream int
+
+	// Log level (debug, info, warn, error) and format (text, json)
+	LogLevel  string
+	LogFormat string
+
+	// Log every quota cache hit, and log one in every LogFetchSample upstream fetches
+	LogCacheHits   bool
+	LogFetchSample int
+
+	// Render days in compact reset times when more than 24 hours remain
+	CompactShowDays bool
+
+	// Display labels and icons keyed by lowercase model name substring
+	ModelLabels map[string]ModelLabel
+
+	// Display names keyed by lowercase raw model name, the defaults merged with MODEL_DISPLAY_NAMES
+	DisplayNames map[string]string
+
+	// /quota/score weights keyed by lowercase model name substring. Models matching
+	// no key weigh 1.0, or are left out when ExcludeUnweighted is set.
+	Weights           map[string]float64
+	ExcludeUnweighted bool
+
+	// Quota percentage thresholds for color coding
+	Thresholds QuotaThresholds
+
+	// Per-model alert thresholds keyed by lowercase model name substring, used in
+	// place of Thresholds.Critical for the alert flag when a model matches
+	AlertThresholds map[string]int
+
+	// Ordered lowercase model name substrings shown as overview and status slots
+	TrackedModels []string
+
+	// Show the Pro slot as the average of all Gemini 3 Pro tiers instead of pro-high only
+	ProAverage bool
+
+	// Append compact reset times to /quota/tmux entries
+	TmuxShowReset bool
+
+	// Append the soonest reset among the tracked models to the overview
+	OverviewShowReset bool
+
+	// Keep models from every provider instead of only Gemini and Claude
+	IncludeAllModels bool
+
+	// Treat upstream remainingFraction as a 0-100 percentage rather than a 0-1
+	// fraction; without it only values above 1 are treated as percentages
+	FractionIsPercent bool
+
+	// Round percentages half-up instead of truncating and add percentage_precise to one decimal
+	DecimalPercent bool
+
+	// Smoothing factor in (0, 1] for the burn-rate moving average; higher reacts faster
+	BurnEMAAlpha float64
+
+	// Quota cache backend (memory or redis) and the Redis URL for the redis backend
+	CacheBackend string
+	RedisURL     string
+
+	// Location for reset_time_local in JSON output; nil omits the field
+	Location *time.Location
+
+	// Background cache refresh interval in seconds; 0 disables it
+	BackgroundRefreshSeconds int
+
+	// Grace period in seconds for in-flight requests on shutdown
+	ShutdownTimeoutSeconds int
+
+	// Gzip response compression and the minimum body size it applies to
+	GzipEnabled bool
+	GzipMinSize int
+
+	// Path prefix for all routes when mounted under a reverse proxy subpath
+	BasePath string
+
+	// Explicit HTTP or SOCKS5 proxy for upstream requests, overriding HTTPS_PROXY/HTTP_PROXY
+	ProxyURL string
+
+	// Slack-compatible webhook notified when a tracked model drops below WebhookThreshold percent
+	WebhookURL       string
+	WebhookThreshold int
+
+	// Prometheus pushgateway that receives the quota metrics on each fresh fetch,
+	// grouped under PushgatewayJob; pushing is disabled when the URL is empty
+	PushgatewayURL string
+	PushgatewayJob string
+
+	// Substrings of a 200 body with no models that mark the account as forbidden,
+	// as an upstream 403 does
+	ForbiddenMarkers []string
+
+	// Certificate and key files for serving HTTPS directly; plain HTTP when both are unset
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
+// defaultConfig returns the built-in settings that CONFIG_FILE and then env vars
+// override. Settings derived from others, such as APIURL from API_VERSION, are
+// left empty here and filled in by LoadConfig.
+func defaultConfig() *Config {
+	return &Config{
+		TokenURL:      "https://oauth2.googleapis.com/token",
+		IDEType:       DefaultIDEType,
+		AccountFile:   "antigravity.json",
+		Port:          8000,
+		QueryDebounce: 1,
+
+		HTTPTimeoutSeconds: DefaultHTTPTimeoutSeconds,
+		LogLevel:           "info",
+		LogFormat:          "text",
+		LogFetchSample:     1,
+		CompactShowDays:    true,
+		Thresholds:         DefaultQuotaThresholds,
+		BurnEMAAlpha:       DefaultBurnEMAAlpha,
+		CacheBackend:       "memory",
+		WebhookThreshold:   QuotaWarning,
+		PushgatewayJob:     DefaultPushgatewayJob,
+
+		ShutdownTimeoutSeconds:     DefaultShutdownTimeoutSeconds,
+		GzipEnabled:                true,
+		GzipMinSize:                DefaultGzipMinSize,
+		MaxRetryAfterSeconds:       DefaultMaxRetryAfterSeconds,
+		MaxConcurrentUpstream:      DefaultMaxConcurrentUpstream,
+		HTTPMaxIdleConns:           DefaultHTTPMaxIdleConns,
+		HTTPMaxIdleConnsPerHost:    DefaultHTTPMaxIdleConnsPerHost,
+		HTTPIdleConnTimeoutSeconds: DefaultHTTPIdleConnTimeoutSeconds,
+	}
 }
 
-// LoadConfig loads configuration from environment variables
+// configFieldNames maps each lowercased Config field name to the field name;
+// these are the keys CONFIG_FILE accepts
+func configFieldNames() map[string]string {
+	fields := reflect.VisibleFields(reflect.TypeOf(Config{}))
+	names := make(map[string]string, len(fields))
+	for _, field := range fields {
+		if field.IsExported() {
+			names[strings.ToLower(field.Name)] = field.Name
+		}
+	}
+	return names
+}
+
+// loadConfigFile decodes a YAML or JSON file keyed by Config field name
+// (case-insensitive) over config. Location is given as an IANA zone name. Unknown
+// keys and values of the wrong type are skipped with a warning.
+func loadConfigFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// YAML is a superset of JSON, so one parser handles both
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	names := configFieldNames()
+	for key, value := range settings {
+		name, ok := names[strings.ToLower(key)]
+		if !ok {
+			log.Printf("Warning: ignoring unknown key %q in CONFIG_FILE", key)
+			continue
+		}
+		if name == "Location" {
+			zone, _ := value.(string)
+			config.Location = loadLocation(zone)
+			continue
+		}
+
+		// Decode one key at a time so a bad value only loses that setting
+		field, err := json.Marshal(map[string]interface{}{name: value})
+		if err == nil {
+			err = json.Unmarshal(field, config)
+		}
+		if err != nil {
+			log.Printf("Warning: ignoring CONFIG_FILE key %q: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// getEnvOrJSON returns the env var, or the CONFIG_FILE map encoded as JSON when
+// the env var is unset, so both go through the same parsing and validation
+func getEnvOrJSON[V any](key string, fileValue map[string]V) string {
+	if value := os.Getenv(key); value != "" || len(fileValue) == 0 {
+		return value
+	}
+	data, err := json.Marshal(fileValue)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// QuotaThresholds are the minimum percentages for the good, warning, and critical colors
+type QuotaThresholds struct {
+	Good     int
+	Warning  int
+	Critical int
+}
+
+// DefaultQuotaThresholds are the built-in color thresholds
+var DefaultQuotaThresholds = QuotaThresholds{
+	Good:     QuotaGood,
+	Warning:  QuotaWarning,
+	Critical: QuotaCritical,
+}
+
+// DefaultForbiddenMarkers flag a blocked account in an upstream response body
+var DefaultForbiddenMarkers = []string{"PERMISSION_DENIED"}
+
+// DefaultTrackedModels are the overview and status slots: Pro, Flash, and Claude
+var DefaultTrackedModels = []string{"gemini-3-pro-high", "gemini-3-flash", "claude-sonnet-4-5"}
+
+// DefaultModelDisplayNames are the built-in display names keyed by raw model name
+var DefaultModelDisplayNames = map[string]string{
+	"gemini-3-pro-high":          "Gemini 3 Pro (High)",
+	"gemini-3-pro-low":           "Gemini 3 Pro (Low)",
+	"gemini-3-pro-image":         "Gemini 3 Pro Image",
+	"gemini-3-flash":             "Gemini 3 Flash",
+	"claude-sonnet-4-5":          "Claude Sonnet 4.5",
+	"claude-sonnet-4-5-thinking": "Claude Sonnet 4.5 (Thinking)",
+	"claude-opus-4-5-thinking":   "Claude Opus 4.5 (Thinking)",
+}
+
+// ModelLabel is a display label and icon for a model
+type ModelLabel struct {
+	Label string `json:"label"`
+	Icon  string `json:"icon"`
+}
+
+// LoadConfig loads configuration from the built-in defaults, overridden by
+// CONFIG_FILE, overridden in turn by environment variables
 func LoadConfig() *Config {
+	base := defaultConfig()
+	if path := trimQuotes(os.Getenv("CONFIG_FILE")); path != "" {
+		if err := loadConfigFile(path, base); err != nil {
+			log.Printf("Warning: ignoring CONFIG_FILE: %v", err)
+		}
+	}
+
+	accountFile, accountErr := resolveAccountFile(getEnvOrDefault("ACCOUNT_FILE", base.AccountFile))
+
+	// Explicit API_URL/PROJECT_API_URL win over URLs derived from API_VERSION
+	apiVersion := strings.Trim(getEnvOrDefault("API_VERSION", DefaultAPIVersion), "/")
+
+	location := base.Location
+	if zone := os.Getenv("TIMEZONE"); zone != "" {
+		location = loadLocation(zone)
+	}
+
 	config := &Config{
-		APIURL:        "https://cloudcode-pa.googleapis.com/v1internal:fetchAvailableModels",
-		ProjectAPIURL: "https://cloudcode-pa.googleapis.com/v1internal:loadCodeAssist",
-		TokenURL:      "https://oauth2.googleapis.com/token",
-		UserAgent:     getEnvOrDefault("USER_AGENT", "antigravity/1.13.3 Darwin/arm64"),
-		ClientID:      os.Getenv("CLIENT_ID"),
-		ClientSecret:  os.Getenv("CLIENT_SECRET"),
-		AccountFile:   resolveAccountFile(getEnvOrDefault("ACCOUNT_FILE", "antigravity.json")),
-		Port:          getEnvAsInt("PORT", 8000),
-		QueryDebounce: getEnvAsInt("QUERY_DEBOUNCE", 1),
+		APIURL:        getEnvOrDefault("API_URL", firstNonEmpty(base.APIURL, cloudCodeURL(apiVersion, "fetchAvailableModels"))),
+		ProjectAPIURL: getEnvOrDefault("PROJECT_API_URL", firstNonEmpty(base.ProjectAPIURL, cloudCodeURL(apiVersion, "loadCodeAssist"))),
+		TokenURL:      base.TokenURL,
+		IDEType:       getEnvOrDefault("IDE_TYPE", base.IDEType),
+		ClientID:      getEnvOrDefault("CLIENT_ID", base.ClientID),
+		ClientSecret:  getEnvOrDefault("CLIENT_SECRET", base.ClientSecret),
+		AccountFile:   accountFile,
+		Port:          getEnvAsInt("PORT", base.Port),
+		Host:          strings.TrimSpace(firstNonEmpty(os.Getenv("BIND_ADDRESS"), os.Getenv("HOST"), base.Host)),
+		GRPCPort:      getEnvAsInt("GRPC_PORT", base.GRPCPort),
+		QueryDebounce: getEnvAsInt("QUERY_DEBOUNCE", base.QueryDebounce),
+
+		DebugEndpoints:     getEnvAsBool("DEBUG_ENDPOINTS", base.DebugEndpoints),
+		APIKey:             getEnvOrDefault("API_KEY", base.APIKey),
+		RateLimitRPS:       getEnvAsFloat("RATE_LIMIT_RPS", base.RateLimitRPS),
+		RateLimitBurst:     getEnvAsInt("RATE_LIMIT_BURST", base.RateLimitBurst),
+		AccountJSON:        getEnvOrDefault("ACCOUNT_JSON", base.AccountJSON),
+		RefreshWritePath:   getEnvOrDefault("REFRESH_WRITE_PATH", base.RefreshWritePath),
+		ReadOnly:           getEnvAsBool("READ_ONLY", base.ReadOnly),
+		HTTPTimeoutSeconds: getEnvAsInt("HTTP_TIMEOUT_SECONDS", base.HTTPTimeoutSeconds),
+		LogLevel:           getEnvOrDefault("LOG_LEVEL", base.LogLevel),
+		LogFormat:          getEnvOrDefault("LOG_FORMAT", base.LogFormat),
+		LogCacheHits:       getEnvAsBool("LOG_CACHE_HITS", base.LogCacheHits),
+		LogFetchSample:     getEnvAsInt("LOG_FETCH_SAMPLE", base.LogFetchSample),
+		CompactShowDays:    getEnvAsBool("COMPACT_SHOW_DAYS", base.CompactShowDays),
+		ModelLabels:        loadModelLabels(base.ModelLabels),
+		DisplayNames:       loadDisplayNames(getEnvOrJSON("MODEL_DISPLAY_NAMES", base.DisplayNames)),
+		Weights:            parseWeights(getEnvOrJSON("WEIGHTS", base.Weights)),
+		ExcludeUnweighted:  getEnvAsBool("WEIGHTS_EXCLUDE_UNLISTED", base.ExcludeUnweighted),
+		Thresholds:         loadQuotaThresholds(base.Thresholds),
+		AlertThresholds:    parseAlertThresholds(getEnvOrJSON("ALERT_THRESHOLDS", base.AlertThresholds)),
+		TrackedModels:      getEnvAsList("TRACKED_MODELS", normalizeList(base.TrackedModels, DefaultTrackedModels)),
+		ProAverage:         getEnvAsBool("PRO_AVERAGE", base.ProAverage),
+		DecimalPercent:     getEnvAsBool("DECIMAL_PERCENT", base.DecimalPercent),
+		FractionIsPercent:  getEnvAsBool("FRACTION_IS_PERCENT", base.FractionIsPercent),
+		IncludeAllModels:   getEnvAsBool("INCLUDE_ALL_MODELS", base.IncludeAllModels),
+		TmuxShowReset:      getEnvAsBool("TMUX_SHOW_RESET", base.TmuxShowReset),
+		OverviewShowReset:  getEnvAsBool("OVERVIEW_SHOW_RESET", base.OverviewShowReset),
+		Location:           location,
+		BurnEMAAlpha:       loadBurnEMAAlpha(base.BurnEMAAlpha),
+		CacheBackend:       strings.ToLower(getEnvOrDefault("CACHE_BACKEND", base.CacheBackend)),
+		RedisURL:           getEnvOrDefault("REDIS_URL", base.RedisURL),
+		WebhookURL:         getEnvOrDefault("WEBHOOK_URL", base.WebhookURL),
+		WebhookThreshold:   getEnvAsInt("WEBHOOK_THRESHOLD", base.WebhookThreshold),
+		PushgatewayURL:     getEnvOrDefault("PUSHGATEWAY_URL", base.PushgatewayURL),
+		PushgatewayJob:     getEnvOrDefault("PUSHGATEWAY_JOB", base.PushgatewayJob),
+
+		BackgroundRefreshSeconds:   getEnvAsInt("BACKGROUND_REFRESH_SECONDS", base.BackgroundRefreshSeconds),
+		ShutdownTimeoutSeconds:     getEnvAsInt("SHUTDOWN_TIMEOUT_SECONDS", base.ShutdownTimeoutSeconds),
+		GzipEnabled:                getEnvAsBool("GZIP_ENABLED", base.GzipEnabled),
+		GzipMinSize:                getEnvAsInt("GZIP_MIN_SIZE", base.GzipMinSize),
+		BasePath:                   normalizeBasePath(getEnvOrDefault("BASE_PATH", base.BasePath)),
+		ProxyURL:                   getEnvOrDefault("PROXY_URL", base.ProxyURL),
+		MinUpstreamIntervalSeconds: getEnvAsInt("MIN_UPSTREAM_INTERVAL_SECONDS", base.MinUpstreamIntervalSeconds),
+		MaxRetryAfterSeconds:       getEnvAsInt("MAX_RETRY_AFTER_SECONDS", base.MaxRetryAfterSeconds),
+		MaxConcurrentUpstream:      getEnvAsInt("MAX_CONCURRENT_UPSTREAM", base.MaxConcurrentUpstream),
+		HTTPMaxIdleConns:           getEnvAsInt("HTTP_MAX_IDLE_CONNS", base.HTTPMaxIdleConns),
+		HTTPMaxIdleConnsPerHost:    getEnvAsInt("HTTP_MAX_IDLE_CONNS_PER_HOST", base.HTTPMaxIdleConnsPerHost),
+		HTTPIdleConnTimeoutSeconds: getEnvAsInt("HTTP_IDLE_CONN_TIMEOUT_SECONDS", base.HTTPIdleConnTimeoutSeconds),
+		ForbiddenMarkers:           getEnvAsList("FORBIDDEN_MARKERS", normalizeList(base.ForbiddenMarkers, DefaultForbiddenMarkers)),
+		TLSCertFile:                trimQuotes(getEnvOrDefault("TLS_CERT_FILE", base.TLSCertFile)),
+		TLSKeyFile:                 trimQuotes(getEnvOrDefault("TLS_KEY_FILE", base.TLSKeyFile)),
+	}
+
+	if accountErr != nil && config.AccountJSON == "" {
+		log.Printf("Warning: %v", accountErr)
+	}
+
+	config.UserAgent = getEnvOrDefault("USER_AGENT", firstNonEmpty(base.UserAgent, defaultUserAgent(config.IDEType)))
+
+	// Fill in OAuth client credentials missing from the environment
+	if path := trimQuotes(os.Getenv("CREDENTIALS_FILE")); path != "" && (config.ClientID == "" || config.ClientSecret == "") {
+		clientID, clientSecret, err := loadCredentialsFile(path)
+		if err != nil {
+			log.Printf("Warning: ignoring CREDENTIALS_FILE: %v", err)
+		} else {
+			if config.ClientID == "" {
+				config.ClientID = clientID
+			}
+			if config.ClientSecret == "" {
+				config.ClientSecret = clientSecret
+			}
+		}
+	}
+
+	// Map ZAI_ prefixed variables to ANTHROPIC_ for z.ai queries
+	if zaiToken := os.Getenv("ZAI_ANTHROPIC_AUTH_TOKEN"); zaiToken != "" {
+		os.Setenv("ANTHROPIC_AUTH_TOKEN", zaiToken)
+	}
+	if zaiBaseURL := os.Getenv("ZAI_ANTHROPIC_BASE_URL"); zaiBaseURL != "" {
+		os.Setenv("ANTHROPIC_BASE_URL", zaiBaseURL)
+	} else {
+		os.Setenv("ANTHROPIC_BASE_URL", DefaultZAIBaseURL)
 	}
 
 	return config
@@ -77,17 +523,291 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
-func resolveAccountFile(accountFile string) string {
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// loadLocation resolves an IANA time zone name, falling back to UTC when it is invalid.
+// An empty name returns nil.
+func loadLocation(name string) *time.Location {
+	if name == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Warning: invalid TIMEZONE %q, using UTC: %v", name, err)
+		return time.UTC
+	}
+	return loc
+}
+
+// loadBurnEMAAlpha reads BURN_EMA_ALPHA over fallback, reverting to the default outside (0, 1]
+func loadBurnEMAAlpha(fallback float64) float64 {
+	alpha := getEnvAsFloat("BURN_EMA_ALPHA", fallback)
+	if alpha <= 0 || alpha > 1 {
+		log.Printf("Warning: BURN_EMA_ALPHA must be in (0, 1], got %v, using %v", alpha, DefaultBurnEMAAlpha)
+		return DefaultBurnEMAAlpha
+	}
+	return alpha
+}
+
+// cloudCodeURL builds a Cloud Code API method URL, e.g. ".../v1internal:loadCodeAssist"
+func cloudCodeURL(version, method string) string {
+	return CloudCodeBaseURL + "/" + version + ":" + method
+}
+
+// defaultUserAgent builds a User-Agent naming the IDE type, e.g. "antigravity/1.13.3 Darwin/arm64"
+func defaultUserAgent(ideType string) string {
+	return strings.ToLower(ideType) + "/" + DefaultUserAgentVersion
+}
+
+// getEnvAsList splits a comma-separated env var into trimmed, lowercase, non-empty entries
+func getEnvAsList(key string, defaultValue []string) []string {
+	return normalizeList(strings.Split(os.Getenv(key), ","), defaultValue)
+}
+
+// normalizeList trims and lowercases values, dropping empty ones, and returns
+// defaultValue when none are left
+func normalizeList(values, defaultValue []string) []string {
+	var normalized []string
+	for _, value := range values {
+		if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+			normalized = append(normalized, value)
+		}
+	}
+	if len(normalized) == 0 {
+		return defaultValue
+	}
+	return normalized
+}
+
+// loadQuotaThresholds reads QUOTA_GOOD, QUOTA_WARNING, and QUOTA_CRITICAL over
+// fallback, reverting to the defaults unless good > warning > critical
+func loadQuotaThresholds(fallback QuotaThresholds) QuotaThresholds {
+	thresholds := QuotaThresholds{
+		Good:     getEnvAsInt("QUOTA_GOOD", fallback.Good),
+		Warning:  getEnvAsInt("QUOTA_WARNING", fallback.Warning),
+		Critical: getEnvAsInt("QUOTA_CRITICAL", fallback.Critical),
+	}
+	if err := thresholds.Validate(); err != nil {
+		log.Printf("Warning: %v, using defaults", err)
+		return DefaultQuotaThresholds
+	}
+	return thresholds
+}
+
+// Validate checks that good > warning > critical
+func (t QuotaThresholds) Validate() error {
+	if t.Good <= t.Warning || t.Warning <= t.Critical {
+		return fmt.Errorf("invalid quota thresholds (good=%d, warning=%d, critical=%d): must satisfy good > warning > critical", t.Good, t.Warning, t.Critical)
+	}
+	return nil
+}
+
+// loadModelLabels reads the MODEL_LABELS JSON map from the env var or
+// MODEL_LABELS_FILE, falling back to the CONFIG_FILE labels
+func loadModelLabels(fallback map[string]ModelLabel) map[string]ModelLabel {
+	raw := os.Getenv("MODEL_LABELS")
+	if path := os.Getenv("MODEL_LABELS_FILE"); raw == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Warning: failed to read MODEL_LABELS_FILE: %v", err)
+			return nil
+		}
+		raw = string(data)
+	}
+	if raw == "" {
+		raw = getEnvOrJSON("MODEL_LABELS", fallback)
+	}
+	if raw == "" {
+		return nil
+	}
+
+	return parseModelLabels(raw)
+}
+
+// parseModelLabels parses a JSON map of model substrings to labels, lowercasing the keys
+func parseModelLabels(raw string) map[string]ModelLabel {
+	var parsed map[string]ModelLabel
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		log.Printf("Warning: ignoring malformed MODEL_LABELS: %v", err)
+		return nil
+	}
+
+	labels := make(map[string]ModelLabel, len(parsed))
+	for pattern, label := range parsed {
+		labels[strings.ToLower(pattern)] = label
+	}
+	return labels
+}
+
+// loadDisplayNames merges a MODEL_DISPLAY_NAMES JSON map of raw model names to
+// display names over the defaults, lowercasing the keys
+func loadDisplayNames(raw string) map[string]string {
+	names := make(map[string]string, len(DefaultModelDisplayNames))
+	for name, display := range DefaultModelDisplayNames {
+		names[name] = display
+	}
+	if raw == "" {
+		return names
+	}
+
+	var parsed map[string]string
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		log.Printf("Warning: ignoring malformed MODEL_DISPLAY_NAMES: %v", err)
+		return names
+	}
+	for name, display := range parsed {
+		names[strings.ToLower(name)] = display
+	}
+	return names
+}
+
+// parseWeights parses a JSON map of model substrings to non-negative score weights,
+// lowercasing the keys. Negative weights are dropped.
+func parseWeights(raw string) map[string]float64 {
+	if raw == "" {
+		return nil
+	}
+
+	var parsed map[string]float64
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		log.Printf("Warning: ignoring malformed WEIGHTS: %v", err)
+		return nil
+	}
+
+	weights := make(map[string]float64, len(parsed))
+	for pattern, weight := range parsed {
+		if weight < 0 {
+			log.Printf("Warning: ignoring negative WEIGHTS entry %q: %v", pattern, weight)
+			continue
+		}
+		weights[strings.ToLower(pattern)] = weight
+	}
+	return weights
+}
+
+// parseAlertThresholds parses a JSON map of model substrings to alert percentages,
+// lowercasing the keys. Percentages outside 0-100 are dropped.
+func parseAlertThresholds(raw string) map[string]int {
+	if raw == "" {
+		return nil
+	}
+
+	var parsed map[string]int
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		log.Printf("Warning: ignoring malformed ALERT_THRESHOLDS: %v", err)
+		return nil
+	}
+
+	thresholds := make(map[string]int, len(parsed))
+	for pattern, percent := range parsed {
+		if percent < 0 || percent > 100 {
+			log.Printf("Warning: ignoring out-of-range ALERT_THRESHOLDS entry %q: %d", pattern, percent)
+			continue
+		}
+		thresholds[strings.ToLower(pattern)] = percent
+	}
+	return thresholds
+}
+
+// oauthCredentials holds OAuth client fields as found in credential JSON files
+type oauthCredentials struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// loadCredentialsFile reads client_id and client_secret from a gcloud-style
+// credentials JSON file, either at the top level or nested under "installed" or "web"
+func loadCredentialsFile(path string) (string, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var file struct {
+		oauthCredentials
+		Installed *oauthCredentials `json:"installed"`
+		Web       *oauthCredentials `json:"web"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return "", "", fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	creds := file.oauthCredentials
+	if file.Installed != nil {
+		creds = *file.Installed
+	} else if file.Web != nil {
+		creds = *file.Web
+	}
+
+	if creds.ClientID == "" || creds.ClientSecret == "" {
+		return "", "", fmt.Errorf("%s must contain both client_id and client_secret", path)
+	}
+	return creds.ClientID, creds.ClientSecret, nil
+}
+
+// normalizeBasePath returns the path with a leading slash and no trailing slash,
+// or "" for the root
+func normalizeBasePath(path string) string {
+	path = strings.Trim(trimQuotes(path), "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
+
+// resolveAccountFile returns the first existing account file among: an absolute
+// path as-is, or for a relative path the working directory, then
+// $XDG_CONFIG_HOME/antigravity/ (default ~/.config), then ~/.antigravity/.
+// When none exists it returns the first location and an error listing them all.
+func resolveAccountFile(accountFile string) (string, error) {
 	// Remove quotes if present
 	accountFile = trimQuotes(accountFile)
-	
+
 	// If absolute path, return as is
 	if filepath.IsAbs(accountFile) {
-		return accountFile
+		if _, err := os.Stat(accountFile); err != nil {
+			return accountFile, fmt.Errorf("account file not found: %s", accountFile)
+		}
+		return accountFile, nil
 	}
-	
+
 	// Resolve relative to parent directory (project root)
-	return filepath.Join("..", accountFile)
+	candidates := []string{filepath.Join("..", accountFile)}
+
+	home, _ := os.UserHomeDir()
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" && home != "" {
+		configHome = filepath.Join(home, ".config")
+	}
+	if configHome != "" {
+		candidates = append(candidates, filepath.Join(configHome, "antigravity", accountFile))
+	}
+	if home != "" {
+		candidates = append(candidates, filepath.Join(home, ".antigravity", accountFile))
+	}
+
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return candidates[0], fmt.Errorf("account file %s not found, searched: %s", accountFile, strings.Join(candidates, ", "))
 }
 
 func trimQuotes(s string) string {
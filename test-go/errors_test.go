@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errorEnvelope is the JSON error body every handler and middleware writes
+type errorEnvelope struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	RequestID string `json:"request_id"`
+}
+
+func TestRespondError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"missing account", fmt.Errorf("%w: antigravity.json", ErrAccountMissing), http.StatusServiceUnavailable, CodeAccountMissing},
+		{"invalid grant", &TokenRefreshError{StatusCode: http.StatusBadRequest, Code: "invalid_grant"}, http.StatusUnauthorized, CodeReauthRequired},
+		{"other refresh failure", &TokenRefreshError{StatusCode: http.StatusBadRequest, Code: "invalid_client"}, http.StatusInternalServerError, CodeInternal},
+		{"upstream forbidden", &APIError{StatusCode: http.StatusForbidden}, http.StatusForbidden, CodeForbidden},
+		{"upstream rate limit", &APIError{StatusCode: http.StatusTooManyRequests}, http.StatusTooManyRequests, CodeUpstreamRateLimited},
+		{"upstream failure", &APIError{StatusCode: http.StatusBadGateway}, http.StatusBadGateway, CodeUpstreamError},
+		{"model not found", fmt.Errorf("%w: %q", errModelNotFound, "gpt"), http.StatusNotFound, CodeModelNotFound},
+		{"ambiguous model", fmt.Errorf("%w: %q", errAmbiguousModel, "gemini"), http.StatusBadRequest, CodeAmbiguousModel},
+		{"handler error", newAppError(http.StatusBadRequest, CodeBadRequest, "invalid sort"), http.StatusBadRequest, CodeBadRequest},
+		{"unknown", errors.New("boom"), http.StatusInternalServerError, CodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(RequestID())
+			router.GET("/quota/all", func(c *gin.Context) { respondError(c, tt.err) })
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/quota/all", nil)
+			req.Header.Set(RequestIDHeader, "req-1")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			var body errorEnvelope
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to parse error body %q: %v", w.Body.String(), err)
+			}
+			if body.Error.Code != tt.code || body.Error.Message != tt.err.Error() || body.RequestID != "req-1" {
+				t.Errorf("Unexpected error body: %+v", body)
+			}
+		})
+	}
+}
+
+func TestHandlerErrorShape(t *testing.T) {
+	service := NewQuotaService(NewCloudCodeClient(&Config{AccountFile: "/nonexistent/antigravity.json"}))
+	router := gin.New()
+	router.GET("/quota/all", service.GetAllQuota)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/all", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+	var body map[string]map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to parse error body %q: %v", w.Body.String(), err)
+	}
+	if body["error"]["code"] != CodeAccountMissing || body["error"]["message"] == "" {
+		t.Errorf("Expected {\"error\":{\"code\",\"message\"}}, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newGRPCTestClient serves service over an in-process connection and returns a client for it
+func newGRPCTestClient(t *testing.T, service *QuotaService, config *Config) QuotaServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(service, config, nil)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to create gRPC client: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewQuotaServiceClient(conn)
+}
+
+func TestGRPCGetQuota(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+		APIKey:        "secret",
+	}
+	client := newGRPCTestClient(t, NewQuotaService(NewCloudCodeClient(config)), config)
+
+	_, err := client.GetQuota(context.Background(), &GetQuotaRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Expected Unauthenticated without an API key, got %v", err)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	resp, err := client.GetQuota(ctx, &GetQuotaRequest{})
+	if err != nil {
+		t.Fatalf("GetQuota failed: %v", err)
+	}
+
+	want := map[string]int32{"claude-sonnet-4-5": 80, "gemini-3-flash": 90, "gemini-3-pro-high": 95}
+	models := resp.GetQuota().GetModels()
+	if len(models) != len(want) {
+		t.Fatalf("Expected %d models, got %d", len(want), len(models))
+	}
+	for _, model := range models {
+		if model.GetPercentage() != want[model.GetName()] {
+			t.Errorf("Expected %s at %d%%, got %d%%", model.GetName(), want[model.GetName()], model.GetPercentage())
+		}
+		if model.GetResetTime() == "" || model.GetResetTimeUnix() == 0 {
+			t.Errorf("Expected reset time for %s", model.GetName())
+		}
+	}
+	if resp.GetQuota().GetLastUpdated() == 0 {
+		t.Errorf("Expected last_updated to be set")
+	}
+
+	negative := int64(-1)
+	_, err = client.GetQuota(ctx, &GetQuotaRequest{MaxAgeSeconds: &negative})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for a negative max age, got %v", err)
+	}
+}
+
+func TestGRPCGetQuotaError(t *testing.T) {
+	config := &Config{AccountFile: "/nonexistent/antigravity.json"}
+	client := newGRPCTestClient(t, NewQuotaService(NewCloudCodeClient(config)), config)
+
+	_, err := client.GetQuota(context.Background(), &GetQuotaRequest{})
+	st := status.Convert(err)
+	if st.Code() != codes.Unavailable {
+		t.Fatalf("Expected Unavailable for a missing account, got %v", err)
+	}
+
+	var reason string
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			reason = info.GetReason()
+		}
+	}
+	if reason != CodeAccountMissing {
+		t.Errorf("Expected ErrorInfo reason %q, got %q", CodeAccountMissing, reason)
+	}
+}
@@ -1,9 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -28,6 +45,327 @@ func TestLoadConfig(t *testing.T) {
 	}
 }
 
+// clearEnv unsets keys for the rest of the test, restoring them afterwards
+func clearEnv(t *testing.T, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if value, ok := os.LookupEnv(key); ok {
+			t.Cleanup(func() { os.Setenv(key, value) })
+		} else {
+			t.Cleanup(func() { os.Unsetenv(key) })
+		}
+		os.Unsetenv(key)
+	}
+}
+
+// captureLog redirects the standard logger for the rest of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestConfigFileOnly(t *testing.T) {
+	clearEnv(t, "PORT", "QUERY_DEBOUNCE", "TRACKED_MODELS", "WEIGHTS", "DEBUG_ENDPOINTS", "QUOTA_GOOD",
+		"HTTP_MAX_IDLE_CONNS", "TIMEZONE", "API_URL")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `Port: 9000
+querydebounce: 5
+debugEndpoints: true
+TrackedModels:
+  - Gemini-3-Flash
+  - claude-opus
+Weights:
+  Pro: 2
+Thresholds:
+  Good: 60
+HTTPMaxIdleConns: 7
+Location: Europe/Berlin
+APIURL: http://upstream.example/v1internal:fetchAvailableModels
+LogFetchSample: not-a-number
+QUERY_DEBOUNCE: 9
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	logs := captureLog(t)
+
+	config := LoadConfig()
+
+	if config.Port != 9000 || config.QueryDebounce != 5 || !config.DebugEndpoints {
+		t.Errorf("Expected file values, got port=%d debounce=%d debug=%v", config.Port, config.QueryDebounce, config.DebugEndpoints)
+	}
+	if !reflect.DeepEqual(config.TrackedModels, []string{"gemini-3-flash", "claude-opus"}) {
+		t.Errorf("Expected lowercased tracked models from the file list, got %v", config.TrackedModels)
+	}
+	if config.Weights["pro"] != 2 {
+		t.Errorf("Expected lowercased weights from the file map, got %v", config.Weights)
+	}
+	if config.Thresholds != (QuotaThresholds{Good: 60, Warning: QuotaWarning, Critical: QuotaCritical}) {
+		t.Errorf("Expected the file to override only the good threshold, got %+v", config.Thresholds)
+	}
+	if config.HTTPMaxIdleConns != 7 || config.APIURL != "http://upstream.example/v1internal:fetchAvailableModels" {
+		t.Errorf("Expected any Config field to be settable, got idle=%d api=%q", config.HTTPMaxIdleConns, config.APIURL)
+	}
+	if config.Location == nil || config.Location.String() != "Europe/Berlin" {
+		t.Errorf("Expected Location from the zone name, got %v", config.Location)
+	}
+	if config.LogFetchSample != 1 {
+		t.Errorf("Expected the default for a value of the wrong type, got %d", config.LogFetchSample)
+	}
+	for _, want := range []string{`unknown key "QUERY_DEBOUNCE"`, `ignoring CONFIG_FILE key "LogFetchSample"`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("Expected a warning containing %q, got %q", want, logs.String())
+		}
+	}
+	if _, set := os.LookupEnv("PORT"); set {
+		t.Error("Expected the config file to leave the environment untouched")
+	}
+}
+
+func TestConfigFilePortListenAddress(t *testing.T) {
+	clearEnv(t, "PORT", "HOST", "BIND_ADDRESS")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("Port: 9100\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+
+	addr, err := serverAddress(LoadConfig())
+	if err != nil {
+		t.Fatalf("serverAddress failed: %v", err)
+	}
+	if addr != ":9100" {
+		t.Errorf("Expected to listen on the CONFIG_FILE port, got %q", addr)
+	}
+
+	if _, err := serverAddress(&Config{Port: 70000}); err == nil {
+		t.Error("Expected an out-of-range port to be rejected")
+	}
+}
+
+func TestConfigFileEnvOverride(t *testing.T) {
+	clearEnv(t, "PORT", "QUERY_DEBOUNCE", "TRACKED_MODELS")
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"Port": 9000, "QueryDebounce": 5, "TrackedModels": ["claude"]}`), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("PORT", "7000")
+	t.Setenv("TRACKED_MODELS", "flash")
+
+	config := LoadConfig()
+
+	if config.Port != 7000 {
+		t.Errorf("Expected the env var to override the file, got port %d", config.Port)
+	}
+	if config.QueryDebounce != 5 {
+		t.Errorf("Expected the file to override the default, got debounce %d", config.QueryDebounce)
+	}
+	if !reflect.DeepEqual(config.TrackedModels, []string{"flash"}) {
+		t.Errorf("Expected the env list to override the file, got %v", config.TrackedModels)
+	}
+}
+
+func TestConfigFileMissing(t *testing.T) {
+	clearEnv(t, "PORT")
+	t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+	logs := captureLog(t)
+
+	config := LoadConfig()
+
+	if config.Port != 8000 {
+		t.Errorf("Expected defaults with a missing config file, got port %d", config.Port)
+	}
+	if !strings.Contains(logs.String(), "ignoring CONFIG_FILE") {
+		t.Errorf("Expected a warning for the missing file, got %q", logs.String())
+	}
+}
+
+func TestLoadQuotaThresholds(t *testing.T) {
+	t.Setenv("QUOTA_GOOD", "70")
+	t.Setenv("QUOTA_WARNING", "30")
+	t.Setenv("QUOTA_CRITICAL", "5")
+	got := loadQuotaThresholds(DefaultQuotaThresholds)
+	if got != (QuotaThresholds{Good: 70, Warning: 30, Critical: 5}) {
+		t.Errorf("Unexpected thresholds: %+v", got)
+	}
+
+	t.Setenv("QUOTA_WARNING", "80")
+	if got := loadQuotaThresholds(DefaultQuotaThresholds); got != DefaultQuotaThresholds {
+		t.Errorf("Expected defaults for invalid ordering, got %+v", got)
+	}
+}
+
+func TestGetEnvAsList(t *testing.T) {
+	t.Setenv("TRACKED_MODELS", " Gemini-2.5-Pro, ,claude-opus ")
+	got := getEnvAsList("TRACKED_MODELS", DefaultTrackedModels)
+	if !reflect.DeepEqual(got, []string{"gemini-2.5-pro", "claude-opus"}) {
+		t.Errorf("Unexpected list: %v", got)
+	}
+
+	t.Setenv("TRACKED_MODELS", " , ")
+	if got := getEnvAsList("TRACKED_MODELS", DefaultTrackedModels); !reflect.DeepEqual(got, DefaultTrackedModels) {
+		t.Errorf("Expected defaults for empty list, got %v", got)
+	}
+}
+
+func TestLoadLocation(t *testing.T) {
+	if loc := loadLocation(""); loc != nil {
+		t.Errorf("Expected nil location when unset, got %v", loc)
+	}
+	if loc := loadLocation("America/New_York"); loc == nil || loc.String() != "America/New_York" {
+		t.Errorf("Expected America/New_York, got %v", loc)
+	}
+	if loc := loadLocation("Mars/Olympus_Mons"); loc != time.UTC {
+		t.Errorf("Expected UTC fallback for invalid zone, got %v", loc)
+	}
+}
+
+func TestResolveAccountFile(t *testing.T) {
+	home := t.TempDir()
+	xdg := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+
+	write := func(path string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("Failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte("{}"), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	name := "resolve-test-account.json"
+
+	// Nothing exists yet: the error lists every searched location
+	_, err := resolveAccountFile(name)
+	if err == nil {
+		t.Fatalf("Expected error when no account file exists")
+	}
+	for _, location := range []string{filepath.Join(xdg, "antigravity", name), filepath.Join(home, ".antigravity", name)} {
+		if !strings.Contains(err.Error(), location) {
+			t.Errorf("Expected error to list %s, got %v", location, err)
+		}
+	}
+
+	// ~/.antigravity is the last resort
+	homeFile := filepath.Join(home, ".antigravity", name)
+	write(homeFile)
+	if got, err := resolveAccountFile(name); err != nil || got != homeFile {
+		t.Errorf("Expected %s, got %s (%v)", homeFile, got, err)
+	}
+
+	// XDG_CONFIG_HOME takes precedence over ~/.antigravity
+	xdgFile := filepath.Join(xdg, "antigravity", name)
+	write(xdgFile)
+	if got, err := resolveAccountFile(`"` + name + `"`); err != nil || got != xdgFile {
+		t.Errorf("Expected %s, got %s (%v)", xdgFile, got, err)
+	}
+
+	// Without XDG_CONFIG_HOME the XDG location defaults to ~/.config
+	t.Setenv("XDG_CONFIG_HOME", "")
+	defaultXDGFile := filepath.Join(home, ".config", "antigravity", name)
+	write(defaultXDGFile)
+	if got, err := resolveAccountFile(name); err != nil || got != defaultXDGFile {
+		t.Errorf("Expected %s, got %s (%v)", defaultXDGFile, got, err)
+	}
+
+	// Absolute paths are used as-is
+	absolute := filepath.Join(t.TempDir(), "account.json")
+	if got, err := resolveAccountFile(absolute); got != absolute || err == nil {
+		t.Errorf("Expected missing absolute path with error, got %s (%v)", got, err)
+	}
+	write(absolute)
+	if got, err := resolveAccountFile(absolute); got != absolute || err != nil {
+		t.Errorf("Expected absolute path, got %s (%v)", got, err)
+	}
+}
+
+func TestAPIVersionPrecedence(t *testing.T) {
+	t.Setenv("API_URL", "")
+	t.Setenv("PROJECT_API_URL", "")
+	t.Setenv("API_VERSION", "")
+
+	// Default
+	config := LoadConfig()
+	if config.APIURL != "https://cloudcode-pa.googleapis.com/v1internal:fetchAvailableModels" ||
+		config.ProjectAPIURL != "https://cloudcode-pa.googleapis.com/v1internal:loadCodeAssist" {
+		t.Errorf("Unexpected default URLs: %s, %s", config.APIURL, config.ProjectAPIURL)
+	}
+
+	// API_VERSION-derived
+	t.Setenv("API_VERSION", "/v1beta/")
+	config = LoadConfig()
+	if config.APIURL != "https://cloudcode-pa.googleapis.com/v1beta:fetchAvailableModels" ||
+		config.ProjectAPIURL != "https://cloudcode-pa.googleapis.com/v1beta:loadCodeAssist" {
+		t.Errorf("Unexpected API_VERSION URLs: %s, %s", config.APIURL, config.ProjectAPIURL)
+	}
+
+	// Explicit URLs win over API_VERSION, each independently
+	t.Setenv("API_URL", "https://example.com/custom:fetchAvailableModels")
+	config = LoadConfig()
+	if config.APIURL != "https://example.com/custom:fetchAvailableModels" {
+		t.Errorf("Expected explicit API_URL, got %s", config.APIURL)
+	}
+	if config.ProjectAPIURL != "https://cloudcode-pa.googleapis.com/v1beta:loadCodeAssist" {
+		t.Errorf("Expected API_VERSION project URL, got %s", config.ProjectAPIURL)
+	}
+}
+
+func TestLoadCredentialsFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"top level", write("adc.json", `{"client_id":"file-id","client_secret":"file-secret","type":"authorized_user"}`), false},
+		{"installed app", write("installed.json", `{"installed":{"client_id":"file-id","client_secret":"file-secret"}}`), false},
+		{"missing secret", write("partial.json", `{"client_id":"file-id"}`), true},
+		{"malformed", write("bad.json", `{not json`), true},
+		{"missing file", filepath.Join(dir, "missing.json"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientID, clientSecret, err := loadCredentialsFile(tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if clientID != "file-id" || clientSecret != "file-secret" {
+				t.Errorf("Unexpected credentials: %s / %s", clientID, clientSecret)
+			}
+		})
+	}
+
+	// Env vars take precedence; the file only fills in what's missing
+	t.Setenv("CREDENTIALS_FILE", filepath.Join(dir, "adc.json"))
+	t.Setenv("CLIENT_ID", "env-id")
+	t.Setenv("CLIENT_SECRET", "")
+	config := LoadConfig()
+	if config.ClientID != "env-id" || config.ClientSecret != "file-secret" {
+		t.Errorf("Expected env client ID and file secret, got %s / %s", config.ClientID, config.ClientSecret)
+	}
+}
+
 func TestNormalizeAccount(t *testing.T) {
 	client := NewCloudCodeClient(LoadConfig())
 	
@@ -111,7 +449,7 @@ func TestFormatQuota(t *testing.T) {
 		},
 	}
 	
-	formatted := formatQuota(quotaData, true)
+	formatted := formatQuota(quotaData, true, defaultQuotaFormat)
 	
 	// Should only include gemini and claude models
 	if len(formatted.Models) != 2 {
@@ -129,6 +467,59 @@ func TestFormatQuota(t *testing.T) {
 	}
 }
 
+func TestFormatQuotaResetTimeUnix(t *testing.T) {
+	quotaData := &QuotaResponse{
+		Models: map[string]ModelInfo{
+			"gemini-3-pro-high": {QuotaInfo: QuotaInfo{RemainingFraction: 0.95, ResetTime: "2025-12-26T10:00:00Z"}},
+			"gemini-3-flash":    {QuotaInfo: QuotaInfo{RemainingFraction: 0.90, ResetTime: ""}},
+			"claude-sonnet-4-5": {QuotaInfo: QuotaInfo{RemainingFraction: 0.80, ResetTime: "not-a-time"}},
+		},
+	}
+
+	expected := map[string]int64{
+		"gemini-3-pro-high": time.Date(2025, 12, 26, 10, 0, 0, 0, time.UTC).Unix(),
+		"gemini-3-flash":    0,
+		"claude-sonnet-4-5": 0,
+	}
+
+	// Populated whether or not relative times are requested
+	for _, showRelative := range []bool{true, false} {
+		for _, model := range formatQuota(quotaData, showRelative, defaultQuotaFormat).Models {
+			if model.ResetTimeUnix != expected[model.Name] {
+				t.Errorf("showRelative=%v: expected reset_time_unix %d for %s, got %d", showRelative, expected[model.Name], model.Name, model.ResetTimeUnix)
+			}
+		}
+	}
+}
+
+func TestIncludeAllModels(t *testing.T) {
+	quotaData := &QuotaResponse{
+		Models: map[string]ModelInfo{
+			"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.90}},
+			"gpt-5":          {QuotaInfo: QuotaInfo{RemainingFraction: 0.70}},
+		},
+	}
+
+	tests := []struct {
+		includeAll bool
+		expected   []string
+	}{
+		{false, []string{"gemini-3-flash"}},
+		{true, []string{"gemini-3-flash", "gpt-5"}},
+	}
+
+	for _, tt := range tests {
+		service := NewQuotaService(NewCloudCodeClient(&Config{IncludeAllModels: tt.includeAll}))
+		var names []string
+		for _, model := range service.formatDisplayQuota(quotaData).Models {
+			names = append(names, model.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("INCLUDE_ALL_MODELS=%v: expected %v, got %v", tt.includeAll, tt.expected, names)
+		}
+	}
+}
+
 func TestFilterModels(t *testing.T) {
 	quota := &FormattedQuota{
 		Models: []FormattedModel{
@@ -192,3 +583,412 @@ func TestLoadAccount(t *testing.T) {
 		t.Errorf("Expected project ID 'test-project', got %s", account.ProjectID)
 	}
 }
+
+func TestFormatDurationDays(t *testing.T) {
+	tests := []struct {
+		name      string
+		delta     time.Duration
+		remaining string
+		compact   string
+		noDays    string
+	}{
+		{"exactly 24h", 24 * time.Hour, "24h 0m", "24h", "24h"},
+		{"25h", 25 * time.Hour, "1d 1h", "1d1h", "25h"},
+		{"36h", 36 * time.Hour, "1d 12h", "1d12h", "36h"},
+		{"48h", 48 * time.Hour, "2d 0h", "2d", "48h"},
+		{"sub-day", 2*time.Hour + 30*time.Minute, "2h 30m", "2h30m", "2h30m"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := formatDurationRemaining(tt.delta); result != tt.remaining {
+				t.Errorf("Expected remaining %s, got %s", tt.remaining, result)
+			}
+			if result := formatDurationCompact(tt.delta, true); result != tt.compact {
+				t.Errorf("Expected compact %s, got %s", tt.compact, result)
+			}
+			if result := formatDurationCompact(tt.delta, false); result != tt.noDays {
+				t.Errorf("Expected compact without days %s, got %s", tt.noDays, result)
+			}
+		})
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		UserAgent:     "test-agent",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}
+	service := NewQuotaService(NewCloudCodeClient(config))
+
+	tests := []struct {
+		format   string
+		contains string
+	}{
+		{"overview", "Pro 95% | Flash 90% | Claude 80%"},
+		{"status", "G "},
+		{"json", `"name": "gemini-3-pro-high"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := runOnce(service, tt.format, &buf); err != nil {
+				t.Fatalf("runOnce failed: %v", err)
+			}
+			if !strings.Contains(buf.String(), tt.contains) {
+				t.Errorf("Expected output to contain %q, got %q", tt.contains, buf.String())
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := runOnce(service, "yaml", &buf); err == nil {
+		t.Errorf("Expected error for unknown format")
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger("warn", "json", &buf)
+
+	logger.Info("hidden")
+	logger.Warn("visible", "status", 503)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected 1 log line at warn level, got %d: %q", len(lines), buf.String())
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("Expected JSON log line: %v", err)
+	}
+	if entry["msg"] != "visible" || entry["status"] != float64(503) {
+		t.Errorf("Unexpected log entry: %v", entry)
+	}
+
+	buf.Reset()
+	newLogger("bogus", "text", &buf).Info("fallback", "key", "value")
+	if !strings.Contains(buf.String(), "key=value") {
+		t.Errorf("Expected text log with info fallback, got %q", buf.String())
+	}
+}
+
+func TestClientStructuredLogging(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(newLogger("info", "json", &buf))
+	defer slog.SetDefault(previous)
+
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		QueryDebounce: 1,
+	})
+	if _, err := client.GetQuota("test-access-token", "test-project-id"); err != nil {
+		t.Fatalf("Failed to get quota: %v", err)
+	}
+
+	found := false
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Expected JSON log line, got %q", line)
+		}
+		if entry["msg"] == "Cached quota data" {
+			found = true
+			if entry["models"] != float64(3) || entry["status"] != float64(200) {
+				t.Errorf("Unexpected structured fields: %v", entry)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected a 'Cached quota data' log entry, got %q", buf.String())
+	}
+}
+
+func TestLogCacheHits(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	for _, logCacheHits := range []bool{false, true} {
+		var buf bytes.Buffer
+		previous := slog.Default()
+		slog.SetDefault(newLogger("info", "json", &buf))
+
+		client := NewCloudCodeClient(&Config{
+			APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+			QueryDebounce: 1,
+			LogCacheHits:  logCacheHits,
+		})
+		for i := 0; i < 3; i++ {
+			if _, err := client.GetQuota("test-access-token", "test-project-id"); err != nil {
+				t.Fatalf("Failed to get quota: %v", err)
+			}
+		}
+		slog.SetDefault(previous)
+
+		// The first request fetches and the other two are cache hits
+		expected := 0
+		if logCacheHits {
+			expected = 2
+		}
+		if got := strings.Count(buf.String(), "Returning cached quota data"); got != expected {
+			t.Errorf("LOG_CACHE_HITS=%v: unexpected %d cache-hit log lines in %q", logCacheHits, got, buf.String())
+		}
+	}
+}
+
+func TestLogFetchSample(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(newLogger("info", "json", &buf))
+	defer slog.SetDefault(previous)
+
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	// Without a debounce every request fetches; only the 1st and 4th are logged
+	client := NewCloudCodeClient(&Config{
+		APIURL:         mockServer.URL + "/v1internal:fetchAvailableModels",
+		LogFetchSample: 3,
+	})
+	for i := 0; i < 4; i++ {
+		if _, err := client.GetQuota("test-access-token", "test-project-id"); err != nil {
+			t.Fatalf("Failed to get quota: %v", err)
+		}
+	}
+
+	if got := strings.Count(buf.String(), "Fetching fresh quota data"); got != 2 {
+		t.Errorf("Expected 2 sampled fetch log lines, got %d in %q", got, buf.String())
+	}
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	started := make(chan struct{})
+	srv := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(100 * time.Millisecond)
+			w.Write([]byte("done"))
+		}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve(ctx, srv, 5*time.Second)
+	}()
+
+	// Wait for the listener, then start a slow in-flight request
+	var resp *http.Response
+	respErr := make(chan error, 1)
+	go func() {
+		var getErr error
+		for i := 0; i < 50; i++ {
+			if resp, getErr = http.Get("http://" + addr); getErr == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		respErr <- getErr
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Request never reached the server")
+	}
+	cancel()
+
+	if err := <-serveErr; err != nil {
+		t.Errorf("Expected clean shutdown, got %v", err)
+	}
+	if err := <-respErr; err != nil {
+		t.Fatalf("In-flight request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "done" {
+		t.Errorf("Expected in-flight request to complete, got %q", body)
+	}
+}
+
+// writeTestCert writes a self-signed localhost certificate and key to a temp dir
+func writeTestCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+
+	tests := []struct {
+		name    string
+		cert    string
+		key     string
+		enabled bool
+		wantErr string
+	}{
+		{"both unset", "", "", false, ""},
+		{"both set", certFile, keyFile, true, ""},
+		{"cert only", certFile, "", false, "must be set together"},
+		{"key only", "", keyFile, false, "must be set together"},
+		{"missing cert", missing, keyFile, false, "TLS_CERT_FILE"},
+		{"missing key", certFile, missing, false, "TLS_KEY_FILE"},
+		{"swapped files", keyFile, certFile, false, "invalid certificate or key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := serverTLSConfig(&Config{TLSCertFile: tt.cert, TLSKeyFile: tt.key})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := tlsConfig != nil; got != tt.enabled {
+				t.Errorf("Expected TLS enabled=%v, got %v", tt.enabled, got)
+			}
+		})
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	tlsConfig, err := serverTLSConfig(&Config{TLSCertFile: certFile, TLSKeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("serverTLSConfig failed: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve a port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	srv := &http.Server{
+		Addr:      addr,
+		TLSConfig: tlsConfig,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("secure"))
+		}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve(ctx, srv, 5*time.Second)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = client.Get("https://" + addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.TLS == nil {
+		t.Error("Expected the response to arrive over TLS")
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "secure" {
+		t.Errorf("Expected body %q, got %q", "secure", body)
+	}
+	if err := <-serveErr; err != nil {
+		t.Errorf("Expected clean shutdown, got %v", err)
+	}
+}
+
+func TestListenAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{"all interfaces", "", ":8000", false},
+		{"localhost IPv4", "127.0.0.1", "127.0.0.1:8000", false},
+		{"localhost name", "localhost", "localhost:8000", false},
+		{"all IPv4", "0.0.0.0", "0.0.0.0:8000", false},
+		{"IPv6 loopback", "::1", "[::1]:8000", false},
+		{"bracketed IPv6", "[::1]", "[::1]:8000", false},
+		{"hostname", "quota.internal.example.com", "quota.internal.example.com:8000", false},
+		{"host with port", "127.0.0.1:9000", "", true},
+		{"space", "local host", "", true},
+		{"leading hyphen", "-bad.example", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := listenAddress(tt.host, "8000")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error for %q, got %q", tt.host, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// RequestIDHeader carries the request ID for correlating logs with client reports
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the gin context key holding the request ID
+const requestIDKey = "request_id"
+
+// maxRequestIDLength bounds client-supplied request IDs; longer ones are replaced
+const maxRequestIDLength = 128
+
+// RequestID takes the request ID from X-Request-ID or generates a UUID, stores it in
+// the context, echoes it in the response header, and logs the completed request with it
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+
+		start := time.Now()
+		c.Next()
+
+		requestLogger(c).Info("Request completed",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"duration", time.Since(start))
+	}
+}
+
+// newRequestID returns a random version 4 UUID
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// requestLogger returns the default logger tagged with the request's ID
+func requestLogger(c *gin.Context) *slog.Logger {
+	return slog.With("request_id", c.GetString(requestIDKey))
+}
+
+// Recovery turns a panicking handler into a 500 with a JSON error body carrying
+// the request ID, logging the panic value and stack trace
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			requestLogger(c).Error("Handler panicked",
+				"method", c.Request.Method,
+				"path", c.Request.URL.Path,
+				"panic", rec,
+				"stack", string(debug.Stack()))
+
+			// A partly written response can't be replaced; just stop the chain
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			abortWithError(c, newAppError(http.StatusInternalServerError, CodeInternal, "internal"))
+		}()
+		c.Next()
+	}
+}
+
+// APIKeyAuth requires a matching API key via "Authorization: Bearer <key>" or "X-API-Key".
+// An empty key disables authentication.
+func APIKeyAuth(apiKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apiKey == "" {
+			c.Next()
+			return
+		}
+
+		provided := c.GetHeader("X-API-Key")
+		if auth := c.GetHeader("Authorization"); provided == "" && strings.HasPrefix(auth, "Bearer ") {
+			provided = strings.TrimPrefix(auth, "Bearer ")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(apiKey)) != 1 {
+			abortWithError(c, newAppError(http.StatusUnauthorized, CodeUnauthorized, "unauthorized"))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// maxAgeKey is the gin context key holding the validated ?max_age
+const maxAgeKey = "max_age"
+
+// MaxAge validates ?max_age=<seconds>, the oldest cached quota the caller accepts,
+// rejecting anything but a non-negative integer with a 400
+func MaxAge() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if raw, ok := c.GetQuery("max_age"); ok {
+			seconds, err := strconv.Atoi(raw)
+			if err != nil || seconds < 0 {
+				abortWithError(c, newAppError(http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("invalid max_age %q: must be a non-negative number of seconds", raw)))
+				return
+			}
+			c.Set(maxAgeKey, time.Duration(seconds)*time.Second)
+		}
+		c.Next()
+	}
+}
+
+// requestMaxAge returns the request's ?max_age, or DebounceMaxAge without one
+func requestMaxAge(c *gin.Context) time.Duration {
+	if maxAge, ok := c.Get(maxAgeKey); ok {
+		return maxAge.(time.Duration)
+	}
+	return DebounceMaxAge
+}
+
+// RateLimiter applies a token-bucket rate limit per client IP
+type RateLimiter struct {
+	mu        sync.Mutex
+	limiters  map[string]*clientLimiter
+	rps       rate.Limit
+	burst     int
+	idleTTL   time.Duration
+	lastSweep time.Time
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewRateLimiter creates a per-IP rate limiter; rps <= 0 disables limiting
+func NewRateLimiter(rps float64, burst int) *RateLimiter {
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(rps)))
+	}
+	return &RateLimiter{
+		limiters:  make(map[string]*clientLimiter),
+		rps:       rate.Limit(rps),
+		burst:     burst,
+		idleTTL:   RateLimitIdleTTL,
+		lastSweep: time.Now(),
+	}
+}
+
+// allow reports whether a request from ip may proceed, and if not, how long to wait
+func (l *RateLimiter) allow(ip string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.evictIdle(now)
+
+	entry, exists := l.limiters[ip]
+	if !exists {
+		entry = &clientLimiter{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.limiters[ip] = entry
+	}
+	entry.lastSeen = now
+
+	reservation := entry.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// evictIdle drops limiters for clients not seen within idleTTL; callers hold l.mu
+func (l *RateLimiter) evictIdle(now time.Time) {
+	if now.Sub(l.lastSweep) < l.idleTTL {
+		return
+	}
+	for ip, entry := range l.limiters {
+		if now.Sub(entry.lastSeen) >= l.idleTTL {
+			delete(l.limiters, ip)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Middleware rejects over-limit requests with 429 and a Retry-After header
+func (l *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l.rps <= 0 {
+			c.Next()
+			return
+		}
+
+		if ok, delay := l.allow(c.ClientIP()); !ok {
+			retryAfter := int(math.Ceil(delay.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			abortWithError(c, newAppError(http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded"))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Compression gzips responses for clients that accept it. Bodies smaller than
+// minSize bytes are sent uncompressed, since gzip overhead outweighs the savings.
+// Only the first minSize bytes are held back to decide, so long responses stream.
+func Compression(minSize int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		original := c.Writer
+		writer := &gzipWriter{ResponseWriter: original, minSize: minSize}
+		c.Writer = writer
+		// Restored even if a handler panics, so the error still reaches the client
+		defer func() { c.Writer = original }()
+		c.Next()
+		writer.finish()
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, by name or
+// through *, with a q-value above zero
+func acceptsGzip(acceptEncoding string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, ok := strings.Cut(param, "=")
+			if !ok || !strings.EqualFold(strings.TrimSpace(name), "q") {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				parsed = 0
+			}
+			q = parsed
+		}
+
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// gzipWriter holds back the start of a response until minSize bytes show it is
+// worth compressing, then streams the rest through gzip. A response that ends or
+// is flushed before then is sent as is.
+type gzipWriter struct {
+	gin.ResponseWriter
+	minSize int
+	status  int
+	pending bytes.Buffer
+	started bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if !w.started {
+		w.status = code
+	}
+}
+
+func (w *gzipWriter) WriteHeaderNow() {}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	if w.started {
+		if w.gz != nil {
+			return w.gz.Write(data)
+		}
+		return w.ResponseWriter.Write(data)
+	}
+
+	w.pending.Write(data)
+	if w.pending.Len() > 0 && w.pending.Len() >= w.minSize {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush gives up on compressing a response still below minSize, since a handler
+// that flushes is streaming and its final size can't be known
+func (w *gzipWriter) Flush() {
+	if !w.started {
+		w.start(false)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *gzipWriter) Status() int {
+	if !w.started && w.status != 0 {
+		return w.status
+	}
+	return w.ResponseWriter.Status()
+}
+
+func (w *gzipWriter) Size() int {
+	if !w.started {
+		if w.pending.Len() == 0 {
+			return -1
+		}
+		return w.pending.Len()
+	}
+	return w.ResponseWriter.Size()
+}
+
+func (w *gzipWriter) Written() bool {
+	return w.started || w.pending.Len() > 0
+}
+
+// start sends the status and what was held back, switching to gzip when compress
+// is set
+func (w *gzipWriter) start(compress bool) error {
+	w.started = true
+	if compress {
+		header := w.ResponseWriter.Header()
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+
+	if w.pending.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(w.pending.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.pending.Bytes())
+	}
+	w.pending.Reset()
+	return err
+}
+
+// finish ends the gzip stream, or sends a response that stayed below minSize
+// uncompressed with its length
+func (w *gzipWriter) finish() {
+	if w.gz != nil {
+		w.gz.Close()
+		return
+	}
+	if w.started {
+		return
+	}
+	if w.pending.Len() > 0 {
+		w.ResponseWriter.Header().Set("Content-Length", strconv.Itoa(w.pending.Len()))
+	}
+	w.start(false)
+}
+
+// bufferedWriter holds the status and body in memory instead of sending them
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedWriter) Status() int {
+	if w.status == 0 {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	if w.body.Len() == 0 {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.body.Len() > 0
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		apiKey  string
+		headers map[string]string
+		status  int
+	}{
+		{"no key configured", "", nil, http.StatusOK},
+		{"bearer token", "secret", map[string]string{"Authorization": "Bearer secret"}, http.StatusOK},
+		{"x-api-key header", "secret", map[string]string{"X-API-Key": "secret"}, http.StatusOK},
+		{"missing key", "secret", nil, http.StatusUnauthorized},
+		{"wrong key", "secret", map[string]string{"Authorization": "Bearer wrong"}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
+			quota := router.Group("/quota", APIKeyAuth(tt.apiKey))
+			quota.GET("/overview", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/quota/overview", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+
+			// Health checks are never authenticated
+			w = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", "/healthz", nil)
+			router.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected /healthz to bypass auth, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	tests := []struct {
+		query  string
+		status int
+		maxAge time.Duration
+	}{
+		{"", http.StatusOK, DebounceMaxAge},
+		{"?max_age=0", http.StatusOK, 0},
+		{"?max_age=90", http.StatusOK, 90 * time.Second},
+		{"?max_age=-1", http.StatusBadRequest, 0},
+		{"?max_age=soon", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		router := gin.New()
+		var got time.Duration
+		router.GET("/quota/status", MaxAge(), func(c *gin.Context) {
+			got = requestMaxAge(c)
+			c.Status(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/quota/status"+tt.query, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%q: expected status %d, got %d", tt.query, tt.status, w.Code)
+		}
+		if tt.status == http.StatusOK && got != tt.maxAge {
+			t.Errorf("%q: expected max age %s, got %s", tt.query, tt.maxAge, got)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	router := gin.New()
+	router.Use(RequestID())
+	quota := router.Group("/quota", APIKeyAuth("secret"))
+	quota.GET("/overview", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// A supplied request ID is echoed in the header and error body
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/overview", nil)
+	req.Header.Set(RequestIDHeader, "client-abc-123")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401, got %d", w.Code)
+	}
+	if got := w.Header().Get(RequestIDHeader); got != "client-abc-123" {
+		t.Errorf("Expected echoed request ID, got %q", got)
+	}
+	var body errorEnvelope
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to parse error body: %v", err)
+	}
+	if body.RequestID != "client-abc-123" || body.Error.Code != CodeUnauthorized || body.Error.Message != "unauthorized" {
+		t.Errorf("Unexpected error body: %+v", body)
+	}
+
+	// A missing request ID is generated as a UUID
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/quota/overview", nil)
+	router.ServeHTTP(w, req)
+
+	uuidPattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if got := w.Header().Get(RequestIDHeader); !uuidPattern.MatchString(got) {
+		t.Errorf("Expected generated UUID, got %q", got)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	router := gin.New()
+	router.Use(RequestID(), Compression(DefaultGzipMinSize), Recovery())
+	router.GET("/quota/boom", func(c *gin.Context) {
+		var counts map[string]int
+		counts["boom"]++
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/boom", nil)
+	req.Header.Set(RequestIDHeader, "panic-req-1")
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected 500, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("Expected a JSON content type, got %q", ct)
+	}
+	var body errorEnvelope
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to parse error body %q: %v", w.Body.String(), err)
+	}
+	if body.Error.Code != CodeInternal || body.Error.Message != "internal" || body.RequestID != "panic-req-1" {
+		t.Errorf("Unexpected error body: %+v", body)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(1, 3)
+
+	router := gin.New()
+	router.GET("/quota/pro", limiter.Middleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	allowed, limited := 0, 0
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/quota/pro", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		router.ServeHTTP(w, req)
+
+		switch w.Code {
+		case http.StatusOK:
+			allowed++
+		case http.StatusTooManyRequests:
+			limited++
+			if w.Header().Get("Retry-After") == "" {
+				t.Errorf("Expected Retry-After header on 429")
+			}
+		default:
+			t.Errorf("Unexpected status %d", w.Code)
+		}
+	}
+
+	if allowed != 3 {
+		t.Errorf("Expected 3 allowed requests (burst), got %d", allowed)
+	}
+	if limited != 7 {
+		t.Errorf("Expected 7 rate-limited requests, got %d", limited)
+	}
+
+	// A different client has its own bucket
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/pro", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected other client to be allowed, got %d", w.Code)
+	}
+}
+
+func TestRateLimiterEvictsIdleClients(t *testing.T) {
+	limiter := NewRateLimiter(1, 1)
+	limiter.allow("192.0.2.1")
+	limiter.allow("192.0.2.2")
+
+	// Age both entries past the idle TTL and trigger a sweep
+	limiter.mu.Lock()
+	for _, entry := range limiter.limiters {
+		entry.lastSeen = time.Now().Add(-2 * limiter.idleTTL)
+	}
+	limiter.lastSweep = time.Now().Add(-2 * limiter.idleTTL)
+	limiter.mu.Unlock()
+
+	limiter.allow("192.0.2.3")
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if len(limiter.limiters) != 1 {
+		t.Errorf("Expected idle clients to be evicted, %d remain", len(limiter.limiters))
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	limiter := NewRateLimiter(0, 0)
+
+	router := gin.New()
+	router.GET("/quota/pro", limiter.Middleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i := 0; i < 20; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/quota/pro", nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected no rate limiting when disabled, got %d", w.Code)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	large := strings.Repeat("\x1b[32m●\x1b[0m quota ", 200)
+
+	router := gin.New()
+	router.Use(Compression(1024))
+	router.GET("/large", func(c *gin.Context) { c.String(http.StatusOK, "%s", large) })
+	router.GET("/small", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	router.GET("/threshold", func(c *gin.Context) { c.String(http.StatusOK, "%s", strings.Repeat("a", 1024)) })
+	router.GET("/missing", func(c *gin.Context) { c.JSON(http.StatusNotFound, gin.H{"error": "not found"}) })
+
+	// Large bodies are compressed and round-trip unchanged, ANSI escapes included
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/large", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected gzip encoding, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Expected text/plain content type, got %q", w.Header().Get("Content-Type"))
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Expected Vary: Accept-Encoding, got %q", w.Header().Get("Vary"))
+	}
+	if w.Header().Get("Content-Length") != "" {
+		t.Errorf("Expected a streamed gzip body without Content-Length, got %q", w.Header().Get("Content-Length"))
+	}
+	if w.Body.Len() >= len(large) {
+		t.Errorf("Expected compressed body smaller than %d bytes, got %d", len(large), w.Body.Len())
+	}
+	reader, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("Response is not valid gzip: %v", err)
+	}
+	body, _ := io.ReadAll(reader)
+	if string(body) != large {
+		t.Errorf("Decompressed body does not match original")
+	}
+
+	// Small bodies are sent uncompressed
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/small", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected no encoding for small body, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if w.Body.String() != "ok" {
+		t.Errorf("Expected plain body, got %q", w.Body.String())
+	}
+	if w.Header().Get("Content-Length") != "2" {
+		t.Errorf("Expected Content-Length 2, got %q", w.Header().Get("Content-Length"))
+	}
+
+	// A body of exactly minSize bytes is compressed
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/threshold", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Expected gzip encoding at the minimum size, got %q", w.Header().Get("Content-Encoding"))
+	}
+
+	// Status codes survive buffering
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/missing", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+
+	// Clients that don't accept gzip get the raw body
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/large", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != large {
+		t.Errorf("Expected uncompressed response without Accept-Encoding")
+	}
+}
+
+func TestCompressionAcceptEncoding(t *testing.T) {
+	large := strings.Repeat("quota ", 500)
+
+	router := gin.New()
+	router.Use(Compression(1024))
+	router.GET("/large", func(c *gin.Context) { c.String(http.StatusOK, "%s", large) })
+
+	tests := []struct {
+		acceptEncoding string
+		gzipped        bool
+	}{
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"GZIP; Q=1.0", true},
+		{"*", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.000", false},
+		{"*;q=0.8, gzip;q=0", false},
+		{"gzip;q=0, *", false},
+		{"*;q=0", false},
+		{"identity", false},
+		{"gzipx", false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/large", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		router.ServeHTTP(w, req)
+
+		gzipped := w.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != tt.gzipped {
+			t.Errorf("Accept-Encoding %q: expected gzipped=%v, got %v", tt.acceptEncoding, tt.gzipped, gzipped)
+		}
+		if !gzipped && w.Body.String() != large {
+			t.Errorf("Accept-Encoding %q: expected the raw body", tt.acceptEncoding)
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: expected Vary: Accept-Encoding, got %q", tt.acceptEncoding, w.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestCompressionStreaming(t *testing.T) {
+	chunk := strings.Repeat("a", 600)
+	var sentBeforeEnd int
+
+	router := gin.New()
+	router.Use(Compression(1024))
+	w := httptest.NewRecorder()
+	router.GET("/large", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		for i := 0; i < 4; i++ {
+			c.Writer.WriteString(chunk)
+		}
+		c.Writer.Flush()
+		sentBeforeEnd = w.Body.Len()
+		c.Writer.WriteString(chunk)
+	})
+	router.GET("/small", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		c.Writer.WriteString("event: quota\n\n")
+		c.Writer.Flush()
+		sentBeforeEnd = w.Body.Len()
+		c.Writer.WriteString(chunk)
+	})
+
+	// Past minSize the response is compressed as it is written, not held until the end
+	req, _ := http.NewRequest("GET", "/large", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected gzip encoding, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if sentBeforeEnd == 0 {
+		t.Error("Expected the flushed gzip data to be sent before the handler returned")
+	}
+	reader, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("Response is not valid gzip: %v", err)
+	}
+	body, _ := io.ReadAll(reader)
+	if string(body) != strings.Repeat(chunk, 5) {
+		t.Errorf("Decompressed body does not match what was written")
+	}
+
+	// A flush below minSize sends what was held back, uncompressed
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/small", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected no encoding once flushed below the minimum size, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if sentBeforeEnd != len("event: quota\n\n") {
+		t.Errorf("Expected the flush to send the held back event, got %d bytes", sentBeforeEnd)
+	}
+	if w.Body.String() != "event: quota\n\n"+chunk {
+		t.Errorf("Expected the raw streamed body, got %q", w.Body.String())
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPISpec is the hand-maintained OpenAPI 3.0 description of the API.
+// Keep openapi.json in sync when adding or changing routes.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// GetOpenAPISpec serves the OpenAPI spec
+func (s *QuotaService) GetOpenAPISpec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", openAPISpec)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+func TestBuildPrometheusText(t *testing.T) {
+	resetTime := time.Now().Add(time.Hour).Truncate(time.Second)
+	quota := &FormattedQuota{
+		Models: []FormattedModel{
+			{Name: "gemini-3-flash", Percentage: 90, ResetTime: resetTime.Format(time.RFC3339)},
+			{Name: "odd\"model\\name\n", Percentage: 10},
+		},
+		LastUpdated: time.Now().Unix(),
+		IsStale:     true,
+	}
+
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(strings.NewReader(buildPrometheusText(quota)))
+	if err != nil {
+		t.Fatalf("Output does not parse as Prometheus text format: %v", err)
+	}
+
+	remaining, ok := families["antigravity_quota_remaining_percent"]
+	if !ok {
+		t.Fatalf("Missing antigravity_quota_remaining_percent")
+	}
+	if remaining.GetHelp() == "" {
+		t.Errorf("Expected HELP text")
+	}
+	if len(remaining.GetMetric()) != 2 {
+		t.Fatalf("Expected 2 remaining samples, got %d", len(remaining.GetMetric()))
+	}
+	if label := remaining.GetMetric()[1].GetLabel()[0].GetValue(); label != "odd\"model\\name\n" {
+		t.Errorf("Expected escaped label to round-trip, got %q", label)
+	}
+	if value := remaining.GetMetric()[0].GetGauge().GetValue(); value != 90 {
+		t.Errorf("Expected 90, got %v", value)
+	}
+
+	reset := families["antigravity_quota_reset_timestamp_seconds"]
+	if len(reset.GetMetric()) != 1 || int64(reset.GetMetric()[0].GetGauge().GetValue()) != resetTime.Unix() {
+		t.Errorf("Expected a single reset timestamp of %d", resetTime.Unix())
+	}
+
+	if stale := families["antigravity_quota_stale"]; stale.GetMetric()[0].GetGauge().GetValue() != 1 {
+		t.Errorf("Expected stale gauge to be 1")
+	}
+}
+
+func TestGetPrometheusTextfile(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}
+	service := NewQuotaService(NewCloudCodeClient(config))
+
+	router := gin.New()
+	router.GET("/quota/prometheus-textfile", service.GetPrometheusTextfile)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/prometheus-textfile", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if w.Header().Get("Content-Type") != PrometheusContentType {
+		t.Errorf("Expected Prometheus content type, got %q", w.Header().Get("Content-Type"))
+	}
+
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(w.Body)
+	if err != nil {
+		t.Fatalf("Response does not parse as Prometheus text format: %v", err)
+	}
+	if got := len(families["antigravity_quota_remaining_percent"].GetMetric()); got != 3 {
+		t.Errorf("Expected 3 models, got %d", got)
+	}
+}
+
+func TestBuildOpenMetricsText(t *testing.T) {
+	text := buildOpenMetricsText(&QuotaResponse{Models: map[string]ModelInfo{
+		"gemini-3-flash":    {QuotaInfo: QuotaInfo{RemainingFraction: 0.875, ResetTime: "2025-12-26T11:00:00Z"}},
+		"claude-sonnet-4-5": {QuotaInfo: QuotaInfo{RemainingFraction: 0.5}},
+		"chat_20706":        {QuotaInfo: QuotaInfo{RemainingFraction: 1}},
+	}}, defaultQuotaFormat)
+
+	families := parseOpenMetrics(t, text)
+	remaining, ok := families["antigravity_quota_remaining_fraction"]
+	if !ok {
+		t.Fatalf("Missing antigravity_quota_remaining_fraction")
+	}
+	if remaining.GetType().String() != "GAUGE" || remaining.GetHelp() == "" {
+		t.Errorf("Expected gauge type and HELP metadata, got %v %q", remaining.GetType(), remaining.GetHelp())
+	}
+	if len(remaining.GetMetric()) != 2 {
+		t.Fatalf("Expected 2 samples, got %d", len(remaining.GetMetric()))
+	}
+
+	labels := func(i int) map[string]string {
+		result := map[string]string{}
+		for _, label := range remaining.GetMetric()[i].GetLabel() {
+			result[label.GetName()] = label.GetValue()
+		}
+		return result
+	}
+	// Models are sorted by name, so claude comes first
+	if got := labels(0); got["model"] != "claude-sonnet-4-5" || got["reset_time"] != "" {
+		t.Errorf("Expected no reset_time label without a reset time, got %v", got)
+	}
+	if got := labels(1); got["model"] != "gemini-3-flash" || got["reset_time"] != "2025-12-26T11:00:00Z" {
+		t.Errorf("Expected reset_time label on gemini-3-flash, got %v", got)
+	}
+	if value := remaining.GetMetric()[1].GetGauge().GetValue(); value != 0.875 {
+		t.Errorf("Expected the exact fraction 0.875, got %v", value)
+	}
+}
+
+func TestGetOpenMetrics(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}
+	service := NewQuotaService(NewCloudCodeClient(config))
+
+	router := gin.New()
+	router.GET("/openmetrics", service.GetOpenMetrics)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/openmetrics", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/openmetrics-text") {
+		t.Errorf("Expected OpenMetrics content type, got %q", contentType)
+	}
+
+	families := parseOpenMetrics(t, w.Body.String())
+	if got := len(families["antigravity_quota_remaining_fraction"].GetMetric()); got != 3 {
+		t.Errorf("Expected 3 models, got %d", got)
+	}
+}
+
+// parseOpenMetrics checks the OpenMetrics framing (a single trailing EOF marker and
+// TYPE metadata ahead of every sample), then parses the body with the Prometheus
+// text parser, which accepts the gauge-only subset this service emits
+func parseOpenMetrics(t *testing.T, text string) map[string]*dto.MetricFamily {
+	t.Helper()
+
+	body, ok := strings.CutSuffix(text, "# EOF\n")
+	if !ok {
+		t.Fatalf("Expected output to end with the # EOF marker, got %q", text)
+	}
+	if strings.Contains(body, "# EOF") {
+		t.Fatalf("Expected a single # EOF marker, got %q", text)
+	}
+
+	typed := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if fields := strings.Fields(line); len(fields) == 4 && fields[1] == "TYPE" {
+			typed[fields[2]] = true
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := strings.FieldsFunc(line, func(r rune) bool { return r == '{' || r == ' ' })[0]
+		if !typed[name] {
+			t.Errorf("Sample %q has no preceding TYPE metadata", line)
+		}
+	}
+
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Output does not parse as OpenMetrics: %v", err)
+	}
+	return families
+}
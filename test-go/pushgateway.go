@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// PushgatewayPusher pushes the Prometheus textfile metrics to PUSHGATEWAY_URL on
+// each fresh fetch, for short-lived jobs that can't be scraped
+type PushgatewayPusher struct {
+	url        string
+	format     quotaFormat
+	httpClient *http.Client
+}
+
+// NewPushgatewayPusher creates a pusher for config.PushgatewayURL, or returns nil when unset
+func NewPushgatewayPusher(config *Config, httpClient *http.Client) *PushgatewayPusher {
+	if config.PushgatewayURL == "" {
+		return nil
+	}
+
+	job := config.PushgatewayJob
+	if job == "" {
+		job = DefaultPushgatewayJob
+	}
+	return &PushgatewayPusher{
+		url:        strings.TrimRight(config.PushgatewayURL, "/") + "/metrics/job/" + url.PathEscape(job),
+		format:     quotaFormatFor(config),
+		httpClient: httpClient,
+	}
+}
+
+// Push replaces the job's metric group with the current quota. Failures are only
+// logged, so an unreachable pushgateway never fails a quota request.
+func (p *PushgatewayPusher) Push(quotaRaw *QuotaResponse) {
+	if err := p.put(buildPrometheusText(formatQuota(quotaRaw, false, p.format))); err != nil {
+		slog.Warn("Failed to push quota metrics", "url", redact(p.url), "error", err)
+		return
+	}
+	slog.Debug("Pushed quota metrics", "models", len(quotaRaw.Models))
+}
+
+// put sends metrics to the pushgateway, replacing every metric in the job's group
+func (p *PushgatewayPusher) put(metrics string) error {
+	req, err := http.NewRequest("PUT", p.url, strings.NewReader(metrics))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", PrometheusContentType)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("pushgateway returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPushgatewayPush(t *testing.T) {
+	type push struct {
+		method, path, contentType, body string
+	}
+	pushes := make(chan push, 10)
+	pushgateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		pushes <- push{r.Method, r.URL.Path, r.Header.Get("Content-Type"), string(body)}
+	}))
+	defer pushgateway.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+			"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.4}},
+		}})
+	}))
+	defer upstream.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:         upstream.URL,
+		AccountFile:    createTestAccount(t),
+		QueryDebounce:  1,
+		PushgatewayURL: pushgateway.URL + "/",
+		PushgatewayJob: "short lived",
+	})
+
+	if _, err := client.GetQuota("test-access-token", "test-project-id"); err != nil {
+		t.Fatalf("GetQuota failed: %v", err)
+	}
+
+	select {
+	case got := <-pushes:
+		if got.method != "PUT" || got.path != "/metrics/job/short lived" {
+			t.Errorf("Expected PUT /metrics/job/short lived, got %s %s", got.method, got.path)
+		}
+		if got.contentType != PrometheusContentType {
+			t.Errorf("Expected Prometheus content type, got %q", got.contentType)
+		}
+		if !strings.Contains(got.body, `antigravity_quota_remaining_percent{model="gemini-3-flash"} 40`) {
+			t.Errorf("Expected pushed metrics to include the model's quota, got %q", got.body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a push to the pushgateway")
+	}
+
+	// Served from cache, so nothing new to push
+	if _, err := client.GetQuota("test-access-token", "test-project-id"); err != nil {
+		t.Fatalf("GetQuota failed: %v", err)
+	}
+	select {
+	case got := <-pushes:
+		t.Errorf("Expected no push for a cache hit, got %s %s", got.method, got.path)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestPushgatewayUnreachable(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(newLogger("info", "json", &buf))
+	defer slog.SetDefault(previous)
+
+	pusher := NewPushgatewayPusher(&Config{PushgatewayURL: "http://127.0.0.1:1"}, http.DefaultClient)
+	pusher.Push(&QuotaResponse{Models: map[string]ModelInfo{
+		"gemini-3-flash": {QuotaInfo: QuotaInfo{RemainingFraction: 0.4}},
+	}})
+
+	if !strings.Contains(buf.String(), "Failed to push quota metrics") {
+		t.Errorf("Expected the failed push to be logged, got %q", buf.String())
+	}
+	if NewPushgatewayPusher(&Config{}, http.DefaultClient) != nil {
+		t.Error("Expected no pusher without PUSHGATEWAY_URL")
+	}
+}
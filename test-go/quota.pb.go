@@ -0,0 +1,401 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: quota.proto
+
+package main
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetQuotaRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Project ID to query instead of the account's own; cached separately per project
+	Project string `protobuf:"bytes,1,opt,name=project,proto3" json:"project,omitempty"`
+	// Oldest cached quota to accept, in seconds, in place of QUERY_DEBOUNCE; unset uses QUERY_DEBOUNCE
+	MaxAgeSeconds *int64 `protobuf:"varint,2,opt,name=max_age_seconds,json=maxAgeSeconds,proto3,oneof" json:"max_age_seconds,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetQuotaRequest) Reset() {
+	*x = GetQuotaRequest{}
+	mi := &file_quota_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetQuotaRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetQuotaRequest) ProtoMessage() {}
+
+func (x *GetQuotaRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_quota_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetQuotaRequest.ProtoReflect.Descriptor instead.
+func (*GetQuotaRequest) Descriptor() ([]byte, []int) {
+	return file_quota_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GetQuotaRequest) GetProject() string {
+	if x != nil {
+		return x.Project
+	}
+	return ""
+}
+
+func (x *GetQuotaRequest) GetMaxAgeSeconds() int64 {
+	if x != nil && x.MaxAgeSeconds != nil {
+		return *x.MaxAgeSeconds
+	}
+	return 0
+}
+
+type GetQuotaResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Quota         *Quota                 `protobuf:"bytes,1,opt,name=quota,proto3" json:"quota,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetQuotaResponse) Reset() {
+	*x = GetQuotaResponse{}
+	mi := &file_quota_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetQuotaResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetQuotaResponse) ProtoMessage() {}
+
+func (x *GetQuotaResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_quota_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetQuotaResponse.ProtoReflect.Descriptor instead.
+func (*GetQuotaResponse) Descriptor() ([]byte, []int) {
+	return file_quota_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetQuotaResponse) GetQuota() *Quota {
+	if x != nil {
+		return x.Quota
+	}
+	return nil
+}
+
+// Quota mirrors the REST FormattedQuota
+type Quota struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Models      []*ModelQuota          `protobuf:"bytes,1,rep,name=models,proto3" json:"models,omitempty"`
+	LastUpdated int64                  `protobuf:"varint,2,opt,name=last_updated,json=lastUpdated,proto3" json:"last_updated,omitempty"`
+	IsForbidden bool                   `protobuf:"varint,3,opt,name=is_forbidden,json=isForbidden,proto3" json:"is_forbidden,omitempty"`
+	IsStale     bool                   `protobuf:"varint,4,opt,name=is_stale,json=isStale,proto3" json:"is_stale,omitempty"`
+	// Set when any model is below its alert threshold; alerts names them
+	Alert         bool     `protobuf:"varint,5,opt,name=alert,proto3" json:"alert,omitempty"`
+	Alerts        []string `protobuf:"bytes,6,rep,name=alerts,proto3" json:"alerts,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Quota) Reset() {
+	*x = Quota{}
+	mi := &file_quota_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Quota) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Quota) ProtoMessage() {}
+
+func (x *Quota) ProtoReflect() protoreflect.Message {
+	mi := &file_quota_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Quota.ProtoReflect.Descriptor instead.
+func (*Quota) Descriptor() ([]byte, []int) {
+	return file_quota_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Quota) GetModels() []*ModelQuota {
+	if x != nil {
+		return x.Models
+	}
+	return nil
+}
+
+func (x *Quota) GetLastUpdated() int64 {
+	if x != nil {
+		return x.LastUpdated
+	}
+	return 0
+}
+
+func (x *Quota) GetIsForbidden() bool {
+	if x != nil {
+		return x.IsForbidden
+	}
+	return false
+}
+
+func (x *Quota) GetIsStale() bool {
+	if x != nil {
+		return x.IsStale
+	}
+	return false
+}
+
+func (x *Quota) GetAlert() bool {
+	if x != nil {
+		return x.Alert
+	}
+	return false
+}
+
+func (x *Quota) GetAlerts() []string {
+	if x != nil {
+		return x.Alerts
+	}
+	return nil
+}
+
+// ModelQuota mirrors the REST FormattedModel
+type ModelQuota struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Name  string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// Alias from the built-in names or MODEL_DISPLAY_NAMES; the raw name when there is none
+	DisplayName       string `protobuf:"bytes,8,opt,name=display_name,json=displayName,proto3" json:"display_name,omitempty"`
+	Percentage        int32  `protobuf:"varint,2,opt,name=percentage,proto3" json:"percentage,omitempty"`
+	ResetTime         string `protobuf:"bytes,3,opt,name=reset_time,json=resetTime,proto3" json:"reset_time,omitempty"`
+	ResetTimeUnix     int64  `protobuf:"varint,4,opt,name=reset_time_unix,json=resetTimeUnix,proto3" json:"reset_time_unix,omitempty"`
+	ResetTimeRelative string `protobuf:"bytes,5,opt,name=reset_time_relative,json=resetTimeRelative,proto3" json:"reset_time_relative,omitempty"`
+	ResetTimeLocal    string `protobuf:"bytes,6,opt,name=reset_time_local,json=resetTimeLocal,proto3" json:"reset_time_local,omitempty"`
+	// Set only when DECIMAL_PERCENT is enabled
+	PercentagePrecise *float64 `protobuf:"fixed64,7,opt,name=percentage_precise,json=percentagePrecise,proto3,oneof" json:"percentage_precise,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *ModelQuota) Reset() {
+	*x = ModelQuota{}
+	mi := &file_quota_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ModelQuota) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ModelQuota) ProtoMessage() {}
+
+func (x *ModelQuota) ProtoReflect() protoreflect.Message {
+	mi := &file_quota_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ModelQuota.ProtoReflect.Descriptor instead.
+func (*ModelQuota) Descriptor() ([]byte, []int) {
+	return file_quota_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ModelQuota) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ModelQuota) GetDisplayName() string {
+	if x != nil {
+		return x.DisplayName
+	}
+	return ""
+}
+
+func (x *ModelQuota) GetPercentage() int32 {
+	if x != nil {
+		return x.Percentage
+	}
+	return 0
+}
+
+func (x *ModelQuota) GetResetTime() string {
+	if x != nil {
+		return x.ResetTime
+	}
+	return ""
+}
+
+func (x *ModelQuota) GetResetTimeUnix() int64 {
+	if x != nil {
+		return x.ResetTimeUnix
+	}
+	return 0
+}
+
+func (x *ModelQuota) GetResetTimeRelative() string {
+	if x != nil {
+		return x.ResetTimeRelative
+	}
+	return ""
+}
+
+func (x *ModelQuota) GetResetTimeLocal() string {
+	if x != nil {
+		return x.ResetTimeLocal
+	}
+	return ""
+}
+
+func (x *ModelQuota) GetPercentagePrecise() float64 {
+	if x != nil && x.PercentagePrecise != nil {
+		return *x.PercentagePrecise
+	}
+	return 0
+}
+
+var File_quota_proto protoreflect.FileDescriptor
+
+const file_quota_proto_rawDesc = "" +
+	"\n" +
+	"\vquota.proto\x12\bquota.v1\"l\n" +
+	"\x0fGetQuotaRequest\x12\x18\n" +
+	"\aproject\x18\x01 \x01(\tR\aproject\x12+\n" +
+	"\x0fmax_age_seconds\x18\x02 \x01(\x03H\x00R\rmaxAgeSeconds\x88\x01\x01B\x12\n" +
+	"\x10_max_age_seconds\"9\n" +
+	"\x10GetQuotaResponse\x12%\n" +
+	"\x05quota\x18\x01 \x01(\v2\x0f.quota.v1.QuotaR\x05quota\"\xc4\x01\n" +
+	"\x05Quota\x12,\n" +
+	"\x06models\x18\x01 \x03(\v2\x14.quota.v1.ModelQuotaR\x06models\x12!\n" +
+	"\flast_updated\x18\x02 \x01(\x03R\vlastUpdated\x12!\n" +
+	"\fis_forbidden\x18\x03 \x01(\bR\visForbidden\x12\x19\n" +
+	"\bis_stale\x18\x04 \x01(\bR\aisStale\x12\x14\n" +
+	"\x05alert\x18\x05 \x01(\bR\x05alert\x12\x16\n" +
+	"\x06alerts\x18\x06 \x03(\tR\x06alerts\"\xcf\x02\n" +
+	"\n" +
+	"ModelQuota\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12!\n" +
+	"\fdisplay_name\x18\b \x01(\tR\vdisplayName\x12\x1e\n" +
+	"\n" +
+	"percentage\x18\x02 \x01(\x05R\n" +
+	"percentage\x12\x1d\n" +
+	"\n" +
+	"reset_time\x18\x03 \x01(\tR\tresetTime\x12&\n" +
+	"\x0freset_time_unix\x18\x04 \x01(\x03R\rresetTimeUnix\x12.\n" +
+	"\x13reset_time_relative\x18\x05 \x01(\tR\x11resetTimeRelative\x12(\n" +
+	"\x10reset_time_local\x18\x06 \x01(\tR\x0eresetTimeLocal\x122\n" +
+	"\x12percentage_precise\x18\a \x01(\x01H\x00R\x11percentagePrecise\x88\x01\x01B\x15\n" +
+	"\x13_percentage_precise2Q\n" +
+	"\fQuotaService\x12A\n" +
+	"\bGetQuota\x12\x19.quota.v1.GetQuotaRequest\x1a\x1a.quota.v1.GetQuotaResponseB\tZ\a./;mainb\x06proto3"
+
+var (
+	file_quota_proto_rawDescOnce sync.Once
+	file_quota_proto_rawDescData []byte
+)
+
+func file_quota_proto_rawDescGZIP() []byte {
+	file_quota_proto_rawDescOnce.Do(func() {
+		file_quota_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_quota_proto_rawDesc), len(file_quota_proto_rawDesc)))
+	})
+	return file_quota_proto_rawDescData
+}
+
+var file_quota_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_quota_proto_goTypes = []any{
+	(*GetQuotaRequest)(nil),  // 0: quota.v1.GetQuotaRequest
+	(*GetQuotaResponse)(nil), // 1: quota.v1.GetQuotaResponse
+	(*Quota)(nil),            // 2: quota.v1.Quota
+	(*ModelQuota)(nil),       // 3: quota.v1.ModelQuota
+}
+var file_quota_proto_depIdxs = []int32{
+	2, // 0: quota.v1.GetQuotaResponse.quota:type_name -> quota.v1.Quota
+	3, // 1: quota.v1.Quota.models:type_name -> quota.v1.ModelQuota
+	0, // 2: quota.v1.QuotaService.GetQuota:input_type -> quota.v1.GetQuotaRequest
+	1, // 3: quota.v1.QuotaService.GetQuota:output_type -> quota.v1.GetQuotaResponse
+	3, // [3:4] is the sub-list for method output_type
+	2, // [2:3] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_quota_proto_init() }
+func file_quota_proto_init() {
+	if File_quota_proto != nil {
+		return
+	}
+	file_quota_proto_msgTypes[0].OneofWrappers = []any{}
+	file_quota_proto_msgTypes[3].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_quota_proto_rawDesc), len(file_quota_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_quota_proto_goTypes,
+		DependencyIndexes: file_quota_proto_depIdxs,
+		MessageInfos:      file_quota_proto_msgTypes,
+	}.Build()
+	File_quota_proto = out.File
+	file_quota_proto_goTypes = nil
+	file_quota_proto_depIdxs = nil
+}
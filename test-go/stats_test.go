@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStatsCountersIncrement(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	config := &Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		UserAgent:     "test-agent",
+		QueryDebounce: 1,
+	}
+	client := NewCloudCodeClient(config)
+
+	// First call misses the cache, second call hits it
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetQuota("test-access-token", "test-project-id"); err != nil {
+			t.Fatalf("Failed to get quota: %v", err)
+		}
+	}
+
+	snapshot := client.stats.Snapshot()
+	if snapshot.CacheMisses != 1 {
+		t.Errorf("Expected 1 cache miss, got %d", snapshot.CacheMisses)
+	}
+	if snapshot.CacheHits != 1 {
+		t.Errorf("Expected 1 cache hit, got %d", snapshot.CacheHits)
+	}
+	if snapshot.UpstreamErrors != 0 {
+		t.Errorf("Expected 0 upstream errors, got %d", snapshot.UpstreamErrors)
+	}
+}
+
+func TestStatsUpstreamErrors(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{APIURL: mockServer.URL, QueryDebounce: 1})
+	if _, err := client.GetQuota("test-access-token", ""); err == nil {
+		t.Fatal("Expected error from upstream")
+	}
+
+	if errors := client.stats.Snapshot().UpstreamErrors; errors != 1 {
+		t.Errorf("Expected 1 upstream error, got %d", errors)
+	}
+}
+
+func TestStatsEndpoint(t *testing.T) {
+	stats := NewStats()
+	service := NewQuotaService(&CloudCodeClient{stats: stats})
+
+	router := gin.New()
+	router.Use(StatsMiddleware(stats))
+	router.GET("/healthz", service.GetHealthz)
+	router.GET("/stats", service.GetStats)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/healthz", nil)
+			router.ServeHTTP(w, req)
+		}()
+	}
+	wg.Wait()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/stats", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var snapshot StatsSnapshot
+	if err := json.Unmarshal(w.Body.Bytes(), &snapshot); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	if snapshot.Endpoints["/healthz"] != 20 {
+		t.Errorf("Expected 20 hits on /healthz, got %d", snapshot.Endpoints["/healthz"])
+	}
+	if snapshot.Endpoints["/stats"] != 1 {
+		t.Errorf("Expected 1 hit on /stats, got %d", snapshot.Endpoints["/stats"])
+	}
+}
+
+func TestStatsReset(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		QueryDebounce: 1,
+	})
+	service := NewQuotaService(client)
+
+	router := gin.New()
+	router.Use(StatsMiddleware(client.stats))
+	router.GET("/healthz", service.GetHealthz)
+	router.POST("/stats/reset", APIKeyAuth("secret"), service.PostStatsReset)
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/healthz", nil)
+		router.ServeHTTP(w, req)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetQuota("test-access-token", "test-project-id"); err != nil {
+			t.Fatalf("Failed to get quota: %v", err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/stats/reset", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without the API key, got %d", w.Code)
+	}
+	if hits := client.stats.Snapshot().Endpoints["/healthz"]; hits != 3 {
+		t.Fatalf("Expected counters untouched by an unauthorized reset, got %d /healthz hits", hits)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/stats/reset", nil)
+	req.Header.Set("X-API-Key", "secret")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var previous StatsSnapshot
+	if err := json.Unmarshal(w.Body.Bytes(), &previous); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if previous.Endpoints["/healthz"] != 3 || previous.CacheHits != 1 || previous.CacheMisses != 1 {
+		t.Errorf("Expected the reset to return the closed window's counters, got %+v", previous)
+	}
+
+	snapshot := client.stats.Snapshot()
+	if len(snapshot.Endpoints) != 0 || snapshot.CacheHits != 0 || snapshot.CacheMisses != 0 || snapshot.UpstreamErrors != 0 {
+		t.Errorf("Expected all counters zero after reset, got %+v", snapshot)
+	}
+}
+
+func TestStatsResetConcurrent(t *testing.T) {
+	stats := NewStats()
+
+	// Every increment lands either before a reset or after it, never lost between
+	var wg sync.WaitGroup
+	var cleared sync.Map
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				stats.RecordEndpoint("/healthz")
+				stats.RecordCacheHit()
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				snapshot := stats.Reset()
+				cleared.Store([2]int{i, j}, snapshot)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	final := stats.Reset()
+	endpointHits, cacheHits := final.Endpoints["/healthz"], final.CacheHits
+	cleared.Range(func(_, value any) bool {
+		snapshot := value.(StatsSnapshot)
+		endpointHits += snapshot.Endpoints["/healthz"]
+		cacheHits += snapshot.CacheHits
+		return true
+	})
+	if endpointHits != 2000 || cacheHits != 2000 {
+		t.Errorf("Expected 2000 of each increment across resets, got %d endpoint and %d cache hits", endpointHits, cacheHits)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tableHeaders are the /quota/table column titles
+var tableHeaders = [3]string{"Model", "Quota", "Reset"}
+
+// GetQuotaTable returns every model as a box-drawn text table of name, percentage,
+// and relative reset time. ?color=true colors percentages like the status endpoint.
+func (s *QuotaService) GetQuotaTable(c *gin.Context) {
+	quotaRaw, err := s.getQuotaData(c.Request.Context(), c.Query("project"), requestMaxAge(c))
+	if err != nil {
+		c.String(quotaErrorStatus(err), "error: %s", err.Error())
+		return
+	}
+
+	var theme ColorTheme
+	if raw := c.Query("color"); raw != "" {
+		color, err := strconv.ParseBool(raw)
+		if err != nil {
+			c.String(http.StatusBadRequest, "error: invalid color %q: must be true or false", raw)
+			return
+		}
+		if color {
+			theme = ANSITheme
+		}
+	}
+
+	quota := s.formatDisplayQuota(quotaRaw)
+	c.String(http.StatusOK, "%s", buildQuotaTable(quota.Models, theme, s.displayOptions().thresholds()))
+}
+
+// buildQuotaTable renders models as a table sized to its widest cells. Colors
+// wrap the padded percentage, so they never shift the borders.
+func buildQuotaTable(models []FormattedModel, theme ColorTheme, thresholds QuotaThresholds) string {
+	rows := make([][3]string, 0, len(models))
+	for _, model := range models {
+		reset := model.ResetTimeRelative
+		if reset == "" {
+			reset = "-"
+		}
+		rows = append(rows, [3]string{model.Name, strconv.Itoa(model.Percentage) + "%", reset})
+	}
+
+	var widths [3]int
+	for _, row := range append([][3]string{tableHeaders}, rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	border := func(left, middle, right string) string {
+		parts := make([]string, len(widths))
+		for i, width := range widths {
+			parts[i] = strings.Repeat("─", width+2)
+		}
+		return left + strings.Join(parts, middle) + right + "\n"
+	}
+	line := func(row [3]string, color string) string {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			padding := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+			if i != 1 {
+				cells[i] = " " + cell + padding + " "
+				continue
+			}
+			// Right-align percentages
+			if color != "" {
+				cell = color + cell + theme.Reset
+			}
+			cells[i] = " " + padding + cell + " "
+		}
+		return "│" + strings.Join(cells, "│") + "│\n"
+	}
+
+	var table strings.Builder
+	table.WriteString(border("┌", "┬", "┐"))
+	table.WriteString(line(tableHeaders, ""))
+	table.WriteString(border("├", "┼", "┤"))
+	for i, row := range rows {
+		table.WriteString(line(row, classColor(models[i].Percentage, theme, thresholds)))
+	}
+	table.WriteString(border("└", "┴", "┘"))
+	return table.String()
+}
+
+// classColor returns the theme color for a percentage's class
+func classColor(pct int, theme ColorTheme, thresholds QuotaThresholds) string {
+	switch classifyPercentage(pct, thresholds) {
+	case "good":
+		return theme.Green
+	case "warning":
+		return theme.Yellow
+	default:
+		return theme.Red
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBuildQuotaTable(t *testing.T) {
+	models := []FormattedModel{
+		{Name: "claude-sonnet-4-5-thinking", Percentage: 100, ResetTimeRelative: "4h 59m"},
+		{Name: "gemini-3-flash", Percentage: 35},
+		{Name: "gpt", Percentage: 5, ResetTimeRelative: "2d 3h"},
+	}
+	ansi := regexp.MustCompile("\033\\[[0-9;]*m")
+
+	for _, theme := range []ColorTheme{{}, ANSITheme} {
+		table := buildQuotaTable(models, theme, DefaultQuotaThresholds)
+		lines := strings.Split(strings.TrimSuffix(table, "\n"), "\n")
+		if len(lines) != 4+len(models) {
+			t.Fatalf("Expected borders, header, and %d rows, got:\n%s", len(models), table)
+		}
+
+		width := utf8.RuneCountInString(ansi.ReplaceAllString(lines[0], ""))
+		for _, line := range lines {
+			if got := utf8.RuneCountInString(ansi.ReplaceAllString(line, "")); got != width {
+				t.Errorf("Expected every line to be %d wide, got %d: %q", width, got, line)
+			}
+		}
+
+		plain := ansi.ReplaceAllString(table, "")
+		for _, want := range []string{
+			"│ Model                      │ Quota │ Reset  │",
+			"│ claude-sonnet-4-5-thinking │  100% │ 4h 59m │",
+			"│ gemini-3-flash             │   35% │ -      │",
+			"│ gpt                        │    5% │ 2d 3h  │",
+		} {
+			if !strings.Contains(plain, want) {
+				t.Errorf("Expected table to contain %q, got:\n%s", want, plain)
+			}
+		}
+	}
+
+	colored := buildQuotaTable(models, ANSITheme, DefaultQuotaThresholds)
+	for _, want := range []string{ANSITheme.Green + "100%", ANSITheme.Yellow + "35%", ANSITheme.Red + "5%"} {
+		if !strings.Contains(colored, want) {
+			t.Errorf("Expected colored table to contain %q", want)
+		}
+	}
+	if strings.Contains(buildQuotaTable(models, ColorTheme{}, DefaultQuotaThresholds), "\033") {
+		t.Error("Expected no ANSI codes without a theme")
+	}
+}
+
+func TestGetQuotaTable(t *testing.T) {
+	mockServer := createMockServer(t)
+	defer mockServer.Close()
+
+	service := NewQuotaService(NewCloudCodeClient(&Config{
+		APIURL:        mockServer.URL + "/v1internal:fetchAvailableModels",
+		ProjectAPIURL: mockServer.URL + "/v1internal:loadCodeAssist",
+		TokenURL:      mockServer.URL + "/token",
+		AccountFile:   createTestAccount(t),
+		QueryDebounce: 1,
+	}))
+	router := gin.New()
+	router.GET("/quota/table", service.GetQuotaTable)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quota/table", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Expected text/plain, got %q", ct)
+	}
+	if !strings.Contains(w.Body.String(), "│ gemini-3-pro-high │   95% │") {
+		t.Errorf("Expected a row for gemini-3-pro-high, got:\n%s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/quota/table?color=maybe", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid color, got %d", w.Code)
+	}
+}
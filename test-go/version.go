@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Build metadata, set at build time with
+// -ldflags "-X main.Version=... -X main.Commit=... -X main.BuildTime=..."
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+// GetVersion reports the build version, commit, and build time
+func (s *QuotaService) GetVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"version":    Version,
+		"commit":     Commit,
+		"build_time": BuildTime,
+	})
+}
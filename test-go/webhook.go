@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// WebhookNotifier posts a message to WEBHOOK_URL when a tracked model's remaining
+// quota crosses below the threshold. Each model alerts once per crossing and is
+// re-armed when it climbs back to the threshold, so repeated polls don't spam.
+type WebhookNotifier struct {
+	url        string
+	threshold  int
+	patterns   []string
+	format     quotaFormat
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	alerted map[string]bool
+}
+
+// webhookMessage is the Slack incoming-webhook payload. Discord accepts it on
+// webhook URLs ending in /slack.
+type webhookMessage struct {
+	Text string `json:"text"`
+}
+
+// NewWebhookNotifier creates a notifier for config.WebhookURL, or returns nil when unset
+func NewWebhookNotifier(config *Config, httpClient *http.Client) *WebhookNotifier {
+	if config.WebhookURL == "" {
+		return nil
+	}
+
+	patterns := config.TrackedModels
+	if len(patterns) == 0 {
+		patterns = DefaultTrackedModels
+	}
+	return &WebhookNotifier{
+		url:        config.WebhookURL,
+		threshold:  config.WebhookThreshold,
+		patterns:   patterns,
+		format:     quotaFormatFor(config),
+		httpClient: httpClient,
+		alerted:    make(map[string]bool),
+	}
+}
+
+// Check posts one message for every tracked model that is newly below the threshold
+func (n *WebhookNotifier) Check(quotaRaw *QuotaResponse) {
+	for _, model := range n.crossings(formatQuota(quotaRaw, true, n.format)) {
+		text := webhookText(model, n.threshold)
+		if err := n.post(text); err != nil {
+			slog.Error("Failed to send quota webhook", "model", model.Name, "url", redact(n.url), "error", err)
+			continue
+		}
+		slog.Info("Sent quota webhook", "model", model.Name, "percentage", model.Percentage)
+	}
+}
+
+// crossings returns the tracked models below the threshold that have not alerted
+// yet, and re-arms those back at or above it
+func (n *WebhookNotifier) crossings(quota *FormattedQuota) []FormattedModel {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var crossed []FormattedModel
+	for _, pattern := range n.patterns {
+		model, ok := findTrackedModel(quota.Models, pattern)
+		if !ok {
+			continue
+		}
+		if model.Percentage >= n.threshold {
+			delete(n.alerted, model.Name)
+			continue
+		}
+		if !n.alerted[model.Name] {
+			n.alerted[model.Name] = true
+			crossed = append(crossed, model)
+		}
+	}
+	return crossed
+}
+
+// webhookText describes a model that dropped below the threshold and when it resets
+func webhookText(model FormattedModel, threshold int) string {
+	text := fmt.Sprintf("Quota alert: %s is at %d%% (below %d%%)", model.Name, model.Percentage, threshold)
+	if model.ResetTime == "" {
+		return text
+	}
+	text += ", resets " + model.ResetTime
+	if model.ResetTimeRelative != "" {
+		text += " (" + model.ResetTimeRelative + ")"
+	}
+	return text
+}
+
+// post sends text to the webhook as a Slack-compatible JSON message
+func (n *WebhookNotifier) post(text string) error {
+	body, err := json.Marshal(webhookMessage{Text: text})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
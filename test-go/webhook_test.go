@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotification(t *testing.T) {
+	payloads := make(chan webhookMessage, 10)
+	webhookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected JSON content type, got %q", r.Header.Get("Content-Type"))
+		}
+		var message webhookMessage
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Errorf("Failed to decode webhook payload: %v", err)
+		}
+		payloads <- message
+	}))
+	defer webhookServer.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(QuotaResponse{Models: map[string]ModelInfo{
+			"gemini-3-pro-high": {QuotaInfo: QuotaInfo{RemainingFraction: 0.15, ResetTime: "2025-12-26T10:00:00Z"}},
+			"gemini-3-flash":    {QuotaInfo: QuotaInfo{RemainingFraction: 0.9}},
+		}})
+	}))
+	defer upstream.Close()
+
+	client := NewCloudCodeClient(&Config{
+		APIURL:           upstream.URL,
+		AccountFile:      createTestAccount(t),
+		QueryDebounce:    1,
+		WebhookURL:       webhookServer.URL,
+		WebhookThreshold: 20,
+	})
+
+	if _, err := client.GetQuota("test-access-token", "test-project-id"); err != nil {
+		t.Fatalf("GetQuota failed: %v", err)
+	}
+
+	select {
+	case message := <-payloads:
+		for _, want := range []string{"gemini-3-pro-high", "15%", "below 20%", "2025-12-26T10:00:00Z"} {
+			if !strings.Contains(message.Text, want) {
+				t.Errorf("Expected webhook text to contain %q, got %q", want, message.Text)
+			}
+		}
+		if strings.Contains(message.Text, "gemini-3-flash") {
+			t.Errorf("Expected no alert for a model above the threshold, got %q", message.Text)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a webhook notification")
+	}
+
+	// Still below the threshold on the next fetch, so no repeat message
+	client.ClearCache()
+	if _, err := client.GetQuota("test-access-token", "test-project-id"); err != nil {
+		t.Fatalf("GetQuota failed: %v", err)
+	}
+	select {
+	case message := <-payloads:
+		t.Errorf("Expected no repeat notification, got %q", message.Text)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestWebhookCrossings(t *testing.T) {
+	notifier := NewWebhookNotifier(&Config{WebhookURL: "http://example.invalid", WebhookThreshold: 20}, http.DefaultClient)
+	quotaAt := func(pct int) *FormattedQuota {
+		return &FormattedQuota{Models: []FormattedModel{{Name: "gemini-3-pro-high", Percentage: pct}}}
+	}
+
+	steps := []struct {
+		pct     int
+		alerted bool
+	}{
+		{50, false},
+		{15, true},
+		{10, false},
+		{20, false},
+		{5, true},
+	}
+	for _, step := range steps {
+		crossed := notifier.crossings(quotaAt(step.pct))
+		if got := len(crossed) == 1; got != step.alerted {
+			t.Errorf("At %d%%: expected alerted=%v, got %v", step.pct, step.alerted, crossed)
+		}
+	}
+
+	if NewWebhookNotifier(&Config{}, http.DefaultClient) != nil {
+		t.Error("Expected no notifier without WEBHOOK_URL")
+	}
+}